@@ -0,0 +1,214 @@
+// Package auth authenticates the callers of gRPC servers.
+//
+// An Authenticator verifies the credentials of an incoming call and returns the Principal making it.
+// The interceptors of the package store the principal in the context of the call, where handlers read it with FromContext:
+//
+//	if p, ok := auth.FromContext(ctx); ok && p.HasScope("echo.write") {
+//		...
+//	}
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pgbytes/grpc-playground/logging"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// AuthorizationKey is the metadata key carrying the bearer token.
+	AuthorizationKey = "authorization"
+	// ErrorDomain is the domain of the ErrorInfo attached to authentication failures.
+	ErrorDomain = "auth.grpc-playground.pgbytes.github.com"
+
+	bearerPrefix = "Bearer "
+)
+
+// Reasons of the ErrorInfo attached to authentication failures.
+const (
+	ReasonMissingCredentials = "MISSING_CREDENTIALS"
+	ReasonInvalidCredentials = "INVALID_CREDENTIALS"
+	ReasonExpiredCredentials = "EXPIRED_CREDENTIALS"
+)
+
+// Principal is the authenticated caller of an RPC.
+type Principal struct {
+	// Subject identifies the caller, e.g. the sub claim of a JWT.
+	Subject string
+	// Scopes the caller has been granted.
+	Scopes []string
+	// Claims holds every claim of the credentials, including the ones already mapped to Subject and Scopes.
+	Claims map[string]interface{}
+}
+
+// HasScope reports whether the principal has been granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies the credentials of an incoming call.
+// Failures are returned as *rpcerror.Unauthenticated.
+type Authenticator interface {
+	Authenticate(ctx context.Context) (*Principal, error)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the call, false for unauthenticated calls.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// unauthenticated builds the error returned for credentials failing for the given reason.
+func unauthenticated(reason, msg string) error {
+	return &rpcerror.Unauthenticated{Message: msg, Reason: reason, Domain: ErrorDomain}
+}
+
+// BearerToken returns the bearer token of the incoming call.
+// Calls without, with several or with a non bearer authorization value are rejected.
+func BearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(AuthorizationKey)
+	switch {
+	case len(values) == 0:
+		return "", unauthenticated(ReasonMissingCredentials, "missing bearer token")
+	case len(values) > 1:
+		return "", unauthenticated(ReasonInvalidCredentials, "more than one authorization value")
+	case !strings.HasPrefix(values[0], bearerPrefix):
+		return "", unauthenticated(ReasonInvalidCredentials, "authorization is not a bearer token")
+	}
+	token := strings.TrimPrefix(values[0], bearerPrefix)
+	if token == "" {
+		return "", unauthenticated(ReasonMissingCredentials, "empty bearer token")
+	}
+	return token, nil
+}
+
+// Services whose methods are commonly exempt from authentication, for use with WithExemptMethods.
+const (
+	HealthService          = "/grpc.health.v1.Health/"
+	ReflectionService      = "/grpc.reflection.v1.ServerReflection/"
+	ReflectionAlphaService = "/grpc.reflection.v1alpha.ServerReflection/"
+)
+
+// Option configures the authentication interceptors.
+type Option func(*options)
+
+type options struct {
+	exempt      []string
+	exemptFuncs []func(fullMethod string) bool
+}
+
+// WithExemptMethods lets calls of the given methods through without credentials.
+// A full method name, e.g. "/grpc_playground.echo.EchoService/Echo", exempts the method, a service name
+// ending in a slash, e.g. HealthService, exempts all methods of the service.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		o.exempt = append(o.exempt, methods...)
+	}
+}
+
+// WithExemptFunc lets calls through without credentials whenever exempt reports true for their full method name,
+// for exemptions changing while the server runs, e.g. the public rules of a reloaded authorization policy.
+func WithExemptFunc(exempt func(fullMethod string) bool) Option {
+	return func(o *options) {
+		o.exemptFuncs = append(o.exemptFuncs, exempt)
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) isExempt(fullMethod string) bool {
+	for _, exempt := range o.exempt {
+		if fullMethod == exempt || (strings.HasSuffix(exempt, "/") && strings.HasPrefix(fullMethod, exempt)) {
+			return true
+		}
+	}
+	for _, exempt := range o.exemptFuncs {
+		if exempt(fullMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate verifies the call and returns its context carrying the principal.
+func authenticate(ctx context.Context, a Authenticator) (context.Context, error) {
+	p, err := a.Authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logging.SetIdentity(ctx, p.Subject)
+	return NewContext(ctx, p), nil
+}
+
+// UnaryServerInterceptor rejects unary calls the authenticator does not accept
+// and passes the principal to the handler in its context.
+func UnaryServerInterceptor(a Authenticator, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if o.isExempt(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams the authenticator does not accept before the handler runs
+// and passes the principal to the handler in the context of the stream.
+func StreamServerInterceptor(a Authenticator, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if o.isExempt(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(ss.Context(), a)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// ServerOptions installs the unary and stream authentication interceptors, so no kind of RPC is left unauthenticated.
+// The interceptors are chained after the ones installed by earlier options.
+func ServerOptions(a Authenticator, opts ...Option) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(a, opts...)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(a, opts...)),
+	}
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (c *contextStream) Context() context.Context {
+	return c.ctx
+}
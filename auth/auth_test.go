@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// withToken returns an incoming context carrying the bearer token.
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, "Bearer "+token))
+}
+
+// sign creates a JWT for alice valid for an hour, claims override the defaults and nil claims remove them.
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	all := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range claims {
+		if v == nil {
+			delete(all, k)
+			continue
+		}
+		all[k] = v
+	}
+	token := jwt.NewWithClaims(method, all)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// writeJWKS writes the public keys to a JWKS file, keyed by their kid.
+func writeJWKS(t *testing.T, keys map[string]interface{}) string {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "EC", "kid": kid, "crv": k.Curve.Params().Name, "x": b64(k.X.FillBytes(make([]byte, size))), "y": b64(k.Y.FillBytes(make([]byte, size))),
+			})
+		}
+	}
+	content, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+	return path
+}
+
+func TestBearerToken(t *testing.T) {
+	testCases := []struct {
+		description string
+		ctx         context.Context
+		token       string
+		reason      string
+	}{
+		{description: "no metadata", ctx: context.Background(), reason: ReasonMissingCredentials},
+		{description: "no authorization", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("other", "x")), reason: ReasonMissingCredentials},
+		{description: "several authorizations", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, "Bearer a", AuthorizationKey, "Bearer b")), reason: ReasonInvalidCredentials},
+		{description: "basic auth", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, "Basic YTpi")), reason: ReasonInvalidCredentials},
+		{description: "empty bearer token", ctx: withToken(""), reason: ReasonMissingCredentials},
+		{description: "bearer token", ctx: withToken("secret"), token: "secret"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			token, err := BearerToken(tc.ctx)
+			if tc.reason == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tc.token, token)
+				return
+			}
+			var unauthenticated *rpcerror.Unauthenticated
+			require.True(t, errors.As(err, &unauthenticated))
+			assert.Equal(t, tc.reason, unauthenticated.Reason)
+		})
+	}
+}
+
+func TestStaticTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+tokens:
+  - token: alice-secret
+    subject: alice
+    scopes: [echo.read, echo.write]
+  - token: bob-secret
+    subject: bob
+    claims:
+      team: playground
+`), 0o600))
+	tokens, err := LoadStaticTokens(path)
+	require.NoError(t, err)
+
+	p, err := tokens.Authenticate(withToken("alice-secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", p.Subject)
+	assert.True(t, p.HasScope("echo.write"))
+	assert.False(t, p.HasScope("admin"))
+
+	p, err = tokens.Authenticate(withToken("bob-secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "bob", p.Subject)
+	assert.Equal(t, "playground", p.Claims["team"])
+
+	_, err = tokens.Authenticate(withToken("alice-secre"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: invalid token tables are rejected")
+	_, err = NewStaticTokens([]StaticToken{{Token: "a", Subject: "alice"}, {Token: "a", Subject: "bob"}})
+	assert.Error(t, err)
+	_, err = NewStaticTokens([]StaticToken{{Token: "a"}})
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("tokens:\n  - tokn: typo\n"), 0o600))
+	_, err = LoadStaticTokens(path)
+	assert.Error(t, err)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keys, err := LoadJWKS(writeJWKS(t, map[string]interface{}{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}))
+	require.NoError(t, err)
+	opts := JWTOptions{Issuer: "playground", Audience: "echo"}
+	jwks := NewJWKSAuthenticator(keys, opts)
+	hmac, err := NewHMACAuthenticator(hmacSecret, opts)
+	require.NoError(t, err)
+	valid := jwt.MapClaims{"iss": "playground", "aud": "echo", "scope": "echo.read echo.write"}
+
+	testCases := []struct {
+		description   string
+		authenticator Authenticator
+		token         string
+		reason        string
+	}{
+		{
+			description:   "hmac: valid token",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodHS256, hmacSecret, "", valid),
+		},
+		{
+			description:   "hmac: wrong secret",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodHS256, []byte("another-secret-another-secret-32"), "", valid),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "hmac: expired",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"iss": "playground", "aud": "echo", "exp": time.Now().Add(-time.Hour).Unix()}),
+			reason:        ReasonExpiredCredentials,
+		},
+		{
+			description:   "hmac: without exp",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"iss": "playground", "aud": "echo", "exp": nil}),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "hmac: without sub",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"iss": "playground", "aud": "echo", "sub": nil}),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "hmac: wrong issuer",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"iss": "elsewhere", "aud": "echo"}),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "hmac: wrong audience",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"iss": "playground", "aud": "chat"}),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "hmac: unsigned token",
+			authenticator: hmac,
+			token:         sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", valid),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "hmac: not a jwt",
+			authenticator: hmac,
+			token:         "some-super-secret",
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "jwks: valid RSA token",
+			authenticator: jwks,
+			token:         sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", valid),
+		},
+		{
+			description:   "jwks: valid RSA-PSS token",
+			authenticator: jwks,
+			token:         sign(t, jwt.SigningMethodPS256, rsaKey, "rsa", valid),
+		},
+		{
+			description:   "jwks: valid ECDSA token",
+			authenticator: jwks,
+			token:         sign(t, jwt.SigningMethodES256, ecKey, "ec", valid),
+		},
+		{
+			description:   "jwks: unknown kid",
+			authenticator: jwks,
+			token:         sign(t, jwt.SigningMethodES256, ecKey, "unknown", valid),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "jwks: signed by a key not in the set",
+			authenticator: jwks,
+			token:         sign(t, jwt.SigningMethodES256, otherECKey, "ec", valid),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "jwks: algorithm not matching the key type",
+			authenticator: jwks,
+			token:         sign(t, jwt.SigningMethodES256, ecKey, "rsa", valid),
+			reason:        ReasonInvalidCredentials,
+		},
+		{
+			description:   "jwks: hmac token signed with the public key",
+			authenticator: jwks,
+			token:         sign(t, jwt.SigningMethodHS256, hmacSecret, "rsa", valid),
+			reason:        ReasonInvalidCredentials,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p, err := tc.authenticator.Authenticate(withToken(tc.token))
+			if tc.reason == "" {
+				require.NoError(t, err)
+				assert.Equal(t, "alice", p.Subject)
+				assert.Equal(t, []string{"echo.read", "echo.write"}, p.Scopes)
+				assert.Equal(t, "playground", p.Claims["iss"])
+				return
+			}
+			var unauthenticated *rpcerror.Unauthenticated
+			require.Truef(t, errors.As(err, &unauthenticated), "expected an unauthenticated error, got %v", err)
+			assert.Equal(t, tc.reason, unauthenticated.Reason)
+		})
+	}
+
+	t.Log("testing: short HMAC secrets are rejected")
+	_, err = NewHMACAuthenticator([]byte("short"), JWTOptions{})
+	assert.Error(t, err)
+}
+
+func TestParseJWKS(t *testing.T) {
+	testCases := []struct {
+		description string
+		jwks        string
+	}{
+		{description: "not json", jwks: "keys"},
+		{description: "no keys", jwks: `{"keys":[]}`},
+		{description: "only encryption keys", jwks: `{"keys":[{"kty":"EC","use":"enc","crv":"P-256","x":"","y":""}]}`},
+		{description: "symmetric key", jwks: `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`},
+		{description: "unknown curve", jwks: `{"keys":[{"kty":"EC","crv":"P-224","x":"AA","y":"AA"}]}`},
+		{description: "point not on the curve", jwks: `{"keys":[{"kty":"EC","crv":"P-256","x":"` + b64(make([]byte, 32)) + `","y":"` + b64(make([]byte, 32)) + `"}]}`},
+		{description: "short RSA key", jwks: `{"keys":[{"kty":"RSA","n":"` + b64(make([]byte, 64)) + `","e":"AQAB"}]}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := ParseJWKS([]byte(tc.jwks))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// principalEchoServer echoes the subject of the principal it finds in the context.
+type principalEchoServer struct {
+	echo.UnimplementedEchoServiceServer
+}
+
+func (p *principalEchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Internal, "no principal")
+	}
+	return &echo.EchoResponse{Response: principal.Subject}, nil
+}
+
+func (p *principalEchoServer) ServerStreamEcho(req *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer) error {
+	principal, ok := FromContext(stream.Context())
+	if !ok {
+		return status.Error(codes.Internal, "no principal")
+	}
+	return stream.Send(&echo.EchoResponse{Response: principal.Subject})
+}
+
+// startServer serves the principal echo server behind the authentication of ServerOptions configured by opts.
+func startServer(t *testing.T, opts ...Option) echo.EchoServiceClient {
+	tokens, err := NewStaticTokens([]StaticToken{{Token: "some-super-secret", Subject: "echo-client"}})
+	require.NoError(t, err)
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(ServerOptions(tokens, opts...)...)
+	echo.RegisterEchoServiceServer(server, &principalEchoServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return echo.NewEchoServiceClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := startServer(t)
+
+	t.Log("testing: handlers read the principal")
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AuthorizationKey, "Bearer some-super-secret")
+	resp, err := client.Echo(ctx, &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "echo-client", resp.Response)
+
+	t.Log("testing: calls without credentials are rejected with the reason")
+	_, err = client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	var unauthenticated *rpcerror.Unauthenticated
+	require.True(t, errors.As(rpcerror.FromError(err), &unauthenticated))
+	assert.Equal(t, ReasonMissingCredentials, unauthenticated.Reason)
+	assert.Equal(t, ErrorDomain, unauthenticated.Domain)
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := startServer(t)
+	req := &echo.ServerStreamEchoRequest{Request: &echo.EchoRequest{Message: "hello"}, Count: 1}
+
+	t.Log("testing: stream handlers read the principal")
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AuthorizationKey, "Bearer some-super-secret")
+	stream, err := client.ServerStreamEcho(ctx, req)
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "echo-client", resp.Response)
+
+	t.Log("testing: streams without credentials are rejected")
+	stream, err = client.ServerStreamEcho(context.Background(), req)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestExemptMethods(t *testing.T) {
+	client := startServer(t, WithExemptMethods("/grpc_playground.echo.EchoService/Echo"))
+
+	t.Log("testing: exempt methods are called without credentials and principal")
+	_, err := client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	assert.Equal(t, codes.Internal, status.Code(err), "the handler runs and finds no principal")
+
+	t.Log("testing: other methods still require credentials")
+	stream, err := client.ServerStreamEcho(context.Background(), &echo.ServerStreamEchoRequest{Count: 1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	testCases := []struct {
+		description string
+		exempt      []string
+		method      string
+		expected    bool
+	}{
+		{description: "exact method", exempt: []string{"/grpc_playground.echo.EchoService/Echo"}, method: "/grpc_playground.echo.EchoService/Echo", expected: true},
+		{description: "other method of the service", exempt: []string{"/grpc_playground.echo.EchoService/Echo"}, method: "/grpc_playground.echo.EchoService/BidiStreamEcho", expected: false},
+		{description: "method name prefix", exempt: []string{"/grpc_playground.echo.EchoService/Echo"}, method: "/grpc_playground.echo.EchoService/EchoMore", expected: false},
+		{description: "whole service", exempt: []string{HealthService}, method: "/grpc.health.v1.Health/Watch", expected: true},
+		{description: "service name prefix", exempt: []string{HealthService}, method: "/grpc.health.v1.HealthCheck/Watch", expected: false},
+		{description: "no exemptions", method: "/grpc.health.v1.Health/Check", expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, newOptions([]Option{WithExemptMethods(tc.exempt...)}).isExempt(tc.method))
+		})
+	}
+
+	t.Log("testing: exempt funcs are asked for methods not exempted by name")
+	public := map[string]bool{"/grpc_playground.echo.EchoService/Echo": true}
+	o := newOptions([]Option{WithExemptMethods(HealthService), WithExemptFunc(func(fullMethod string) bool { return public[fullMethod] })})
+	assert.True(t, o.isExempt("/grpc.health.v1.Health/Check"))
+	assert.True(t, o.isExempt("/grpc_playground.echo.EchoService/Echo"))
+	assert.False(t, o.isExempt("/grpc_playground.echo.EchoService/BidiStreamEcho"))
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// spiffeScheme is the URI scheme of SPIFFE IDs, e.g. spiffe://playground/echo-client.
+const spiffeScheme = "spiffe"
+
+// ClientCertAuthenticator authenticates callers by the client certificate verified during the mutual TLS handshake.
+// The server must require and verify client certificates, see tlsconfig.Reloader.ServerConfig.
+//
+// The subject of the principal is the SPIFFE ID of the certificate, its common name without SPIFFE ID,
+// or its first DNS name without either. The claims hold cn, dns, uris, emails, spiffe_id and serial.
+type ClientCertAuthenticator struct{}
+
+// NewClientCertAuthenticator creates an authenticator for the verified client certificates of mutual TLS.
+func NewClientCertAuthenticator() *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{}
+}
+
+// Authenticate returns the principal of the verified client certificate of the call.
+func (c *ClientCertAuthenticator) Authenticate(ctx context.Context) (*Principal, error) {
+	cert, ok := VerifiedClientCertificate(ctx)
+	if !ok {
+		return nil, unauthenticated(ReasonMissingCredentials, "missing verified client certificate")
+	}
+	p, err := certificatePrincipal(cert)
+	if err != nil {
+		return nil, unauthenticated(ReasonInvalidCredentials, err.Error())
+	}
+	return p, nil
+}
+
+// VerifiedClientCertificate returns the leaf client certificate the TLS handshake of the call verified,
+// false for calls without TLS or without verified client certificate.
+func VerifiedClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return tlsInfo.State.VerifiedChains[0][0], true
+}
+
+// certificatePrincipal maps the identities of the certificate to a principal.
+func certificatePrincipal(cert *x509.Certificate) (*Principal, error) {
+	var spiffeID string
+	var uris []interface{}
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+		if uri.Scheme != spiffeScheme {
+			continue
+		}
+		if spiffeID != "" {
+			return nil, fmt.Errorf("certificate has more than one SPIFFE ID")
+		}
+		spiffeID = uri.String()
+	}
+	var dns, emails []interface{}
+	for _, name := range cert.DNSNames {
+		dns = append(dns, name)
+	}
+	for _, email := range cert.EmailAddresses {
+		emails = append(emails, email)
+	}
+
+	subject := spiffeID
+	if subject == "" {
+		subject = cert.Subject.CommonName
+	}
+	if subject == "" && len(cert.DNSNames) > 0 {
+		subject = cert.DNSNames[0]
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("certificate has neither SPIFFE ID, common name nor DNS name")
+	}
+	return &Principal{
+		Subject: subject,
+		Claims: map[string]interface{}{
+			"cn":        cert.Subject.CommonName,
+			"dns":       dns,
+			"uris":      uris,
+			"emails":    emails,
+			"spiffe_id": spiffeID,
+			"serial":    cert.SerialNumber.String(),
+		},
+	}, nil
+}
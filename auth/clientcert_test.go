@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/testpki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func TestCertificatePrincipal(t *testing.T) {
+	testCases := []struct {
+		description string
+		cert        *x509.Certificate
+		subject     string
+		expectError bool
+	}{
+		{
+			description: "SPIFFE ID takes precedence",
+			cert: &x509.Certificate{
+				Subject:  pkix.Name{CommonName: "echo client"},
+				DNSNames: []string{"client.playground.test"},
+				URIs:     []*url.URL{mustParseURL(t, "https://playground.test"), mustParseURL(t, "spiffe://playground.test/echo-client")},
+			},
+			subject: "spiffe://playground.test/echo-client",
+		},
+		{
+			description: "common name without SPIFFE ID",
+			cert:        &x509.Certificate{Subject: pkix.Name{CommonName: "echo client"}, DNSNames: []string{"client.playground.test"}},
+			subject:     "echo client",
+		},
+		{
+			description: "DNS name without common name",
+			cert:        &x509.Certificate{DNSNames: []string{"client.playground.test", "other.playground.test"}},
+			subject:     "client.playground.test",
+		},
+		{
+			description: "no identity",
+			cert:        &x509.Certificate{EmailAddresses: []string{"alice@playground.test"}},
+			expectError: true,
+		},
+		{
+			description: "several SPIFFE IDs",
+			cert:        &x509.Certificate{URIs: []*url.URL{mustParseURL(t, "spiffe://playground.test/a"), mustParseURL(t, "spiffe://playground.test/b")}},
+			expectError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tc.cert.SerialNumber = big.NewInt(42)
+			p, err := certificatePrincipal(tc.cert)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.subject, p.Subject)
+			assert.Equal(t, "42", p.Claims["serial"])
+		})
+	}
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	ca := testpki.NewCA(t, testpki.WithCommonName("playground CA"))
+	serverCert := ca.Server(t, testpki.WithDNSNames("echo.playground.test"))
+	clientCert := ca.Client(t, testpki.WithCommonName("echo client"), testpki.WithURIs("spiffe://playground.test/echo-client"))
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	opts := append([]grpc.ServerOption{grpc.Creds(credentials.NewTLS(serverCert.ServerConfig(ca)))}, ServerOptions(NewClientCertAuthenticator())...)
+	server := grpc.NewServer(opts...)
+	echo.RegisterEchoServiceServer(server, &principalEchoServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(ca.ClientConfig("echo.playground.test", clientCert))))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Log("testing: handlers read the SPIFFE ID of the client certificate")
+	resp, err := echo.NewEchoServiceClient(conn).Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://playground.test/echo-client", resp.Response)
+
+	t.Log("testing: calls without TLS are not authenticated")
+	_, err = NewClientCertAuthenticator().Authenticate(context.Background())
+	assert.Error(t, err)
+
+	t.Log("testing: plaintext callers cannot connect to the mTLS server")
+	plain, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer plain.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = echo.NewEchoServiceClient(plain).Echo(ctx, &echo.EchoRequest{Message: "hello"})
+	assert.Error(t, err)
+}
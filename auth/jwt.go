@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minHMACSecretBytes is the minimum length of HMAC secrets, the size of the SHA-256 output.
+const minHMACSecretBytes = 32
+
+var (
+	hmacMethods       = []string{"HS256", "HS384", "HS512"}
+	asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+// JWTOptions are the claims checked on top of the signature and the exp and nbf claims.
+type JWTOptions struct {
+	// Issuer must match the iss claim when set.
+	Issuer string
+	// Audience must be contained in the aud claim when set.
+	Audience string
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// JWTAuthenticator authenticates bearer tokens that are signed JWTs.
+// Tokens must carry the sub and exp claims, the scopes are read from the space separated scope claim.
+type JWTAuthenticator struct {
+	parser  *jwt.Parser
+	keyFunc jwt.Keyfunc
+}
+
+func newJWTAuthenticator(methods []string, opts JWTOptions, keyFunc jwt.Keyfunc) *JWTAuthenticator {
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(opts.Leeway),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	return &JWTAuthenticator{parser: jwt.NewParser(parserOpts...), keyFunc: keyFunc}
+}
+
+// NewHMACAuthenticator creates an authenticator for JWTs signed with the shared secret using HS256, HS384 or HS512.
+func NewHMACAuthenticator(secret []byte, opts JWTOptions) (*JWTAuthenticator, error) {
+	if len(secret) < minHMACSecretBytes {
+		return nil, fmt.Errorf("HMAC secret must be at least %d bytes long, got %d", minHMACSecretBytes, len(secret))
+	}
+	return newJWTAuthenticator(hmacMethods, opts, func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	}), nil
+}
+
+// NewJWKSAuthenticator creates an authenticator for JWTs signed with RSA or ECDSA keys of the key set.
+// The key is selected by the kid header of the token.
+func NewJWKSAuthenticator(keys *KeySet, opts JWTOptions) *JWTAuthenticator {
+	return newJWTAuthenticator(asymmetricMethods, opts, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.verificationKey(kid, token.Method.Alg())
+	})
+}
+
+// Authenticate verifies the bearer token of the call and returns the principal described by its claims.
+func (j *JWTAuthenticator) Authenticate(ctx context.Context) (*Principal, error) {
+	raw, err := BearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = j.parser.ParseWithClaims(raw, claims, j.keyFunc)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, unauthenticated(ReasonExpiredCredentials, "token expired")
+	} else if err != nil {
+		return nil, unauthenticated(ReasonInvalidCredentials, fmt.Sprintf("invalid token: %v", err))
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, unauthenticated(ReasonInvalidCredentials, "invalid token: missing sub claim")
+	}
+	scope, _ := claims["scope"].(string)
+	return &Principal{Subject: subject, Scopes: strings.Fields(scope), Claims: claims}, nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StaticToken grants the principal described by its fields to callers presenting Token.
+type StaticToken struct {
+	Token   string                 `yaml:"token"`
+	Subject string                 `yaml:"subject"`
+	Scopes  []string               `yaml:"scopes"`
+	Claims  map[string]interface{} `yaml:"claims"`
+}
+
+// StaticTokens authenticates bearer tokens against a fixed table of tokens.
+type StaticTokens struct {
+	tokens []StaticToken
+}
+
+// NewStaticTokens creates an authenticator accepting the given tokens.
+func NewStaticTokens(tokens []StaticToken) (*StaticTokens, error) {
+	seen := map[string]bool{}
+	for i, t := range tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token %d: token must not be empty", i)
+		}
+		if t.Subject == "" {
+			return nil, fmt.Errorf("token %d: subject must not be empty", i)
+		}
+		if seen[t.Token] {
+			return nil, fmt.Errorf("token %d: duplicate token for subject %q", i, t.Subject)
+		}
+		seen[t.Token] = true
+	}
+	return &StaticTokens{tokens: tokens}, nil
+}
+
+// LoadStaticTokens reads the token table from a YAML file of the form
+//
+//	tokens:
+//	  - token: some-super-secret
+//	    subject: echo-client
+//	    scopes: [echo]
+func LoadStaticTokens(path string) (*StaticTokens, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading token file: %w", err)
+	}
+	var file struct {
+		Tokens []StaticToken `yaml:"tokens"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing token file %s: %w", path, err)
+	}
+	return NewStaticTokens(file.Tokens)
+}
+
+// Authenticate looks the bearer token of the call up in the table.
+func (s *StaticTokens) Authenticate(ctx context.Context) (*Principal, error) {
+	presented, err := BearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// compare against every token in constant time, so the timing does not reveal which tokens exist
+	var match *StaticToken
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(s.tokens[i].Token)) == 1 {
+			match = &s.tokens[i]
+		}
+	}
+	if match == nil {
+		return nil, unauthenticated(ReasonInvalidCredentials, "invalid token")
+	}
+	return &Principal{Subject: match.Subject, Scopes: match.Scopes, Claims: match.Claims}, nil
+}
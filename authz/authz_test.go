@@ -0,0 +1,242 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/auth"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	echoMethod   = "/grpc_playground.echo.EchoService/Echo"
+	bidiMethod   = "/grpc_playground.echo.EchoService/BidiStreamEcho"
+	clientMethod = "/grpc_playground.echo.EchoService/ClientStreamEcho"
+	serverMethod = "/grpc_playground.echo.EchoService/ServerStreamEcho"
+	healthMethod = "/grpc.health.v1.Health/Check"
+)
+
+const testPolicy = `
+default: deny
+rules:
+  - method: /grpc.health.v1.Health/*
+    public: true
+  - method: /grpc_playground.echo.EchoService/*
+    scopes: [echo]
+  - method: /grpc_playground.echo.EchoService/BidiStreamEcho
+    scopes: [echo]
+    roles: [admin, tester]
+  - method: /grpc_playground.echo.EchoService/ClientStreamEcho
+    subjects: [spiffe://playground/echo-client]
+`
+
+func TestPolicy_Authorize(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	echoer := &auth.Principal{Subject: "alice", Scopes: []string{"echo"}}
+	tester := &auth.Principal{Subject: "bob", Scopes: []string{"echo"}, Claims: map[string]interface{}{"roles": []interface{}{"tester"}}}
+	workload := &auth.Principal{Subject: "spiffe://playground/echo-client"}
+
+	testCases := []struct {
+		description string
+		method      string
+		principal   *auth.Principal
+		code        codes.Code
+		reason      string
+	}{
+		{description: "public method without credentials", method: healthMethod},
+		{description: "service rule with scope", method: echoMethod, principal: echoer},
+		{description: "service rule without scope", method: serverMethod, principal: workload, code: codes.PermissionDenied, reason: ReasonMissingScope},
+		{description: "service rule without credentials", method: echoMethod, code: codes.Unauthenticated, reason: ReasonMissingCredentials},
+		{description: "method rule takes precedence over service rule", method: bidiMethod, principal: echoer, code: codes.PermissionDenied, reason: ReasonMissingRole},
+		{description: "method rule with role", method: bidiMethod, principal: tester},
+		{description: "allowed subject", method: clientMethod, principal: workload},
+		{description: "subject not allowed", method: clientMethod, principal: echoer, code: codes.PermissionDenied, reason: ReasonSubjectNotAllowed},
+		{description: "no rule denied by default", method: "/grpc_playground.chat.ChatService/Chat", principal: tester, code: codes.PermissionDenied, reason: ReasonNoRule},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := policy.Authorize(tc.method, tc.principal)
+			assert.Equal(t, tc.code, status.Code(err))
+			if tc.reason == "" {
+				return
+			}
+			var denied *rpcerror.PermissionDenied
+			var unauthenticated *rpcerror.Unauthenticated
+			switch {
+			case errors.As(err, &denied):
+				assert.Equal(t, tc.reason, denied.Reason)
+				assert.Equal(t, ErrorDomain, denied.Domain)
+				assert.Equal(t, tc.method, denied.Metadata["method"])
+			case errors.As(err, &unauthenticated):
+				assert.Equal(t, tc.reason, unauthenticated.Reason)
+			default:
+				t.Fatalf("unexpected error %T: %v", err, err)
+			}
+		})
+	}
+
+	t.Log("testing: default allow admits authenticated callers of methods without rule")
+	allow, err := NewPolicy(DefaultAllow, nil)
+	require.NoError(t, err)
+	assert.NoError(t, allow.Authorize(echoMethod, echoer))
+	assert.Equal(t, codes.Unauthenticated, status.Code(allow.Authorize(echoMethod, nil)))
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	testCases := []struct {
+		description string
+		policy      string
+	}{
+		{description: "unknown default", policy: "default: maybe"},
+		{description: "unknown field", policy: "rules:\n  - method: /a.B/C\n    scope: [echo]"},
+		{description: "method without service", policy: "rules:\n  - method: Echo"},
+		{description: "method without leading slash", policy: "rules:\n  - method: a.B/C"},
+		{description: "method without name", policy: "rules:\n  - method: /a.B/"},
+		{description: "duplicate method", policy: "rules:\n  - method: /a.B/C\n  - method: /a.B/C"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(tc.policy))
+			assert.Error(t, err)
+		})
+	}
+}
+
+type fakeEchoServer struct {
+	echo.UnimplementedEchoServiceServer
+}
+
+func (f *fakeEchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	return &echo.EchoResponse{Response: req.Message}, nil
+}
+
+func (f *fakeEchoServer) BidiStreamEcho(stream echo.EchoService_BidiStreamEchoServer) error {
+	return stream.Send(&echo.EchoResponse{Response: "bidi"})
+}
+
+// startServer serves the fake echo server behind static token authentication and the engine.
+func startServer(t *testing.T, engine *Engine) echo.EchoServiceClient {
+	tokens, err := auth.NewStaticTokens([]auth.StaticToken{
+		{Token: "alice-token", Subject: "alice", Scopes: []string{"echo"}},
+		{Token: "bob-token", Subject: "bob", Scopes: []string{"echo"}, Claims: map[string]interface{}{"roles": []interface{}{"admin"}}},
+	})
+	require.NoError(t, err)
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	opts := append(auth.ServerOptions(tokens, auth.WithExemptFunc(engine.IsPublic)), engine.ServerOptions()...)
+	server := grpc.NewServer(opts...)
+	echo.RegisterEchoServiceServer(server, &fakeEchoServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return echo.NewEchoServiceClient(conn)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), auth.AuthorizationKey, "Bearer "+token)
+}
+
+func TestEngine_Interceptors(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	client := startServer(t, NewEngine(policy))
+
+	t.Log("testing: unary call with the required scope")
+	_, err = client.Echo(withToken("alice-token"), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+
+	t.Log("testing: stream denied without role, with ErrorInfo details")
+	stream, err := client.BidiStreamEcho(withToken("alice-token"))
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	var denied *rpcerror.PermissionDenied
+	require.True(t, errors.As(rpcerror.FromError(err), &denied))
+	assert.Equal(t, ReasonMissingRole, denied.Reason)
+	assert.Equal(t, ErrorDomain, denied.Domain)
+	assert.Equal(t, bidiMethod, denied.Metadata["method"])
+
+	t.Log("testing: stream allowed with role")
+	stream, err = client.BidiStreamEcho(withToken("bob-token"))
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "bidi", resp.Response)
+}
+
+func TestEngine_PublicRules(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	engine := NewEngine(policy)
+	client := startServer(t, engine)
+
+	t.Log("testing: methods without public rule need credentials")
+	_, err = client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: a public rule lets unauthenticated callers through authentication and authorization")
+	public, err := NewPolicy(DefaultDeny, []Rule{{Method: echoMethod, Public: true}})
+	require.NoError(t, err)
+	engine.SetPolicy(public)
+	resp, err := client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", resp.Response)
+	stream, err := client.BidiStreamEcho(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "the public rule of one method does not open the others")
+}
+
+func TestEngine_WatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testPolicy), 0o600))
+	policy, err := LoadPolicy(path)
+	require.NoError(t, err)
+	engine := NewEngine(policy)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.WatchFile(ctx, path, 10*time.Millisecond)
+	client := startServer(t, engine)
+
+	_, err = client.Echo(withToken("alice-token"), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+
+	t.Log("testing: a changed policy is enforced without restart")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - method: /grpc_playground.echo.EchoService/Echo
+    roles: [admin]
+`), 0o600))
+	require.Eventually(t, func() bool {
+		_, err := client.Echo(withToken("alice-token"), &echo.EchoRequest{Message: "hello"})
+		return status.Code(err) == codes.PermissionDenied
+	}, 2*time.Second, 10*time.Millisecond)
+	_, err = client.Echo(withToken("bob-token"), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+
+	t.Log("testing: an invalid policy keeps the previous one")
+	current := engine.Policy()
+	require.NoError(t, os.WriteFile(path, []byte("default: sometimes\n"), 0o600))
+	time.Sleep(100 * time.Millisecond)
+	assert.Same(t, current, engine.Policy())
+	_, err = client.Echo(withToken("bob-token"), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+}
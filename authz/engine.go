@@ -0,0 +1,108 @@
+package authz
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/auth"
+	"google.golang.org/grpc"
+)
+
+// Engine enforces the current policy, which can be replaced while the server is running.
+type Engine struct {
+	policy atomic.Pointer[Policy]
+}
+
+// NewEngine creates an engine enforcing the policy.
+func NewEngine(policy *Policy) *Engine {
+	e := &Engine{}
+	e.policy.Store(policy)
+	return e
+}
+
+// Policy returns the policy currently enforced.
+func (e *Engine) Policy() *Policy {
+	return e.policy.Load()
+}
+
+// SetPolicy replaces the enforced policy, calls already authorized are not affected.
+func (e *Engine) SetPolicy(policy *Policy) {
+	e.policy.Store(policy)
+}
+
+// Authorize decides whether the principal of ctx, set by the auth interceptors, may call the method.
+func (e *Engine) Authorize(ctx context.Context, fullMethod string) error {
+	principal, _ := auth.FromContext(ctx)
+	return e.Policy().Authorize(fullMethod, principal)
+}
+
+// IsPublic reports whether the current policy admits unauthenticated callers of the method,
+// for use with auth.WithExemptFunc.
+func (e *Engine) IsPublic(fullMethod string) bool {
+	return e.Policy().IsPublic(fullMethod)
+}
+
+// WatchFile reloads the policy from path whenever its modification time or size changes, checking every interval
+// until ctx is done. Invalid policies are logged and ignored, the engine keeps enforcing the previous one.
+func (e *Engine) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Warn("checking policy file failed", "path", path, "error", err)
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		policy, err := LoadPolicy(path)
+		if err != nil {
+			slog.Warn("reloading policy failed, keeping the previous policy", "path", path, "error", err)
+			continue
+		}
+		e.SetPolicy(policy)
+		slog.Info("reloaded policy", "path", path, "rules", len(policy.Rules))
+	}
+}
+
+// UnaryServerInterceptor rejects unary calls the policy does not authorize.
+// It must run after the authentication interceptors, which set the principal.
+func (e *Engine) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := e.Authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams the policy does not authorize before the handler runs.
+// It must run after the authentication interceptors, which set the principal.
+func (e *Engine) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := e.Authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// ServerOptions installs the unary and stream authorization interceptors,
+// chained after the ones installed by earlier options.
+func (e *Engine) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(e.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(e.StreamServerInterceptor()),
+	}
+}
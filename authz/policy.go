@@ -0,0 +1,219 @@
+// Package authz authorizes the callers authenticated by the auth package per gRPC method.
+//
+// A policy maps full method names, or all methods of a service, to the scopes, roles or subjects callers need:
+//
+//	default: deny
+//	rules:
+//	  - method: /grpc.health.v1.Health/*
+//	    public: true
+//	  - method: /grpc_playground.echo.EchoService/*
+//	    scopes: [echo]
+//	  - method: /grpc_playground.echo.EchoService/BidiStreamEcho
+//	    roles: [admin, tester]
+//	  - method: /grpc_playground.echo.EchoService/ClientStreamEcho
+//	    subjects: [spiffe://playground/echo-client]
+//
+// The rule of the exact method takes precedence over the rule of its service. A caller needs every scope
+// and at least one of the roles and subjects of the rule, roles are read from the roles claim of the principal.
+// Rules without requirements admit every authenticated caller, public rules admit unauthenticated callers as well.
+// Those only reach the policy when the authentication interceptors let them through, install them with
+// auth.WithExemptFunc(engine.IsPublic) so public rules exempt their methods from authentication.
+// Methods without rule are allowed to authenticated callers or denied, depending on the default.
+package authz
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pgbytes/grpc-playground/auth"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultAllow = "allow"
+	DefaultDeny  = "deny"
+
+	// ErrorDomain is the domain of the ErrorInfo attached to denials.
+	ErrorDomain = "authz.grpc-playground.pgbytes.github.com"
+
+	// serviceWildcard ends rules applying to all methods of a service, e.g. /grpc.health.v1.Health/*.
+	serviceWildcard = "/*"
+	rolesClaim      = "roles"
+)
+
+// Reasons of the ErrorInfo attached to denials.
+const (
+	ReasonNoRule             = "NO_MATCHING_RULE"
+	ReasonMissingScope       = "MISSING_SCOPE"
+	ReasonMissingRole        = "MISSING_ROLE"
+	ReasonSubjectNotAllowed  = "SUBJECT_NOT_ALLOWED"
+	ReasonMissingCredentials = auth.ReasonMissingCredentials
+)
+
+// Rule lists what callers need to call Method.
+type Rule struct {
+	// Method is a full method name, e.g. /grpc_playground.echo.EchoService/Echo, or a service followed by /*.
+	Method string `yaml:"method"`
+	// Public admits callers without credentials.
+	Public   bool     `yaml:"public"`
+	Scopes   []string `yaml:"scopes"`
+	Roles    []string `yaml:"roles"`
+	Subjects []string `yaml:"subjects"`
+}
+
+// Policy maps methods to the rules callers are authorized by.
+type Policy struct {
+	Default string `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+
+	rules map[string]*Rule
+}
+
+// NewPolicy creates a policy from rules, defaultDecision applies to methods without rule.
+func NewPolicy(defaultDecision string, rules []Rule) (*Policy, error) {
+	policy := &Policy{Default: defaultDecision, Rules: rules}
+	return policy, policy.compile()
+}
+
+// ParsePolicy parses and validates a YAML policy.
+func ParsePolicy(content []byte) (*Policy, error) {
+	policy := &Policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err := decoder.Decode(policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, policy.compile()
+}
+
+// LoadPolicy reads a YAML policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy file: %w", err)
+	}
+	policy, err := ParsePolicy(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing policy file %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// compile validates the policy and indexes its rules by method.
+func (p *Policy) compile() error {
+	switch p.Default {
+	case "":
+		p.Default = DefaultDeny
+	case DefaultAllow, DefaultDeny:
+	default:
+		return fmt.Errorf("unknown default %q, must be one of: %s, %s", p.Default, DefaultAllow, DefaultDeny)
+	}
+	p.rules = map[string]*Rule{}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		// a valid method has a service and a method name: /service/method
+		parts := strings.Split(rule.Method, "/")
+		if len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" {
+			return fmt.Errorf("rule %d: invalid method %q, must be /package.Service/Method or /package.Service/*", i, rule.Method)
+		}
+		if _, ok := p.rules[rule.Method]; ok {
+			return fmt.Errorf("rule %d: duplicate rule for %s", i, rule.Method)
+		}
+		p.rules[rule.Method] = rule
+	}
+	return nil
+}
+
+// ruleFor returns the rule of the method, falling back to the rule of its service.
+func (p *Policy) ruleFor(fullMethod string) *Rule {
+	if rule, ok := p.rules[fullMethod]; ok {
+		return rule
+	}
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		return p.rules[fullMethod[:i]+serviceWildcard]
+	}
+	return nil
+}
+
+// IsPublic reports whether the rule of the method admits unauthenticated callers.
+func (p *Policy) IsPublic(fullMethod string) bool {
+	rule := p.ruleFor(fullMethod)
+	return rule != nil && rule.Public
+}
+
+// denied builds the error returned to callers the policy does not authorize.
+func denied(reason, fullMethod, msg string, metadata map[string]string) error {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata["method"] = fullMethod
+	return &rpcerror.PermissionDenied{Message: msg, Reason: reason, Domain: ErrorDomain, Metadata: metadata}
+}
+
+// Authorize decides whether the principal may call the method, a nil principal being an unauthenticated caller.
+// Denials are returned as *rpcerror.PermissionDenied, unauthenticated callers of non public methods as *rpcerror.Unauthenticated.
+func (p *Policy) Authorize(fullMethod string, principal *auth.Principal) error {
+	if p.IsPublic(fullMethod) {
+		return nil
+	}
+	rule := p.ruleFor(fullMethod)
+	if principal == nil {
+		return &rpcerror.Unauthenticated{
+			Message: "credentials required for " + fullMethod,
+			Reason:  ReasonMissingCredentials,
+			Domain:  ErrorDomain,
+		}
+	}
+	if rule == nil {
+		if p.Default == DefaultAllow {
+			return nil
+		}
+		return denied(ReasonNoRule, fullMethod, "no rule allows calling "+fullMethod, nil)
+	}
+	for _, scope := range rule.Scopes {
+		if !principal.HasScope(scope) {
+			return denied(ReasonMissingScope, fullMethod, fmt.Sprintf("scope %q required", scope), map[string]string{"scope": scope})
+		}
+	}
+	if len(rule.Roles) > 0 && !containsAny(roles(principal), rule.Roles) {
+		return denied(ReasonMissingRole, fullMethod, "one of the roles "+strings.Join(rule.Roles, ", ")+" required",
+			map[string]string{"roles": strings.Join(rule.Roles, ",")})
+	}
+	if len(rule.Subjects) > 0 && !containsAny([]string{principal.Subject}, rule.Subjects) {
+		return denied(ReasonSubjectNotAllowed, fullMethod, fmt.Sprintf("subject %q not allowed", principal.Subject), nil)
+	}
+	return nil
+}
+
+// roles returns the string values of the roles claim of the principal.
+func roles(p *auth.Principal) []string {
+	switch values := p.Claims[rolesClaim].(type) {
+	case []string:
+		return values
+	case []interface{}:
+		var roles []string
+		for _, v := range values {
+			if role, ok := v.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	default:
+		return nil
+	}
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
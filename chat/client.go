@@ -3,23 +3,107 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/pgbytes/grpc-playground/chat/seal"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 )
 
+const (
+	// heartbeatHeader tells how often the server expects heartbeats, e.g. 15s, 0s when it needs none.
+	heartbeatHeader = "x-chat-heartbeat-interval"
+	// defaultHeartbeatInterval is used with servers not telling their interval.
+	defaultHeartbeatInterval = 10 * time.Second
+)
+
+// heartbeatInterval returns the interval the server asks for in its header, or the default.
+func heartbeatInterval(header metadata.MD) time.Duration {
+	if values := header.Get(heartbeatHeader); len(values) > 0 {
+		if interval, err := time.ParseDuration(values[0]); err == nil && interval >= 0 {
+			return interval
+		}
+	}
+	return defaultHeartbeatInterval
+}
+
+// render formats a received chat message, opening sealed messages with key when the room is encrypted.
+func render(msg *chat.ChatMessage, key *seal.RoomKey) string {
+	if msg.Sealed == nil {
+		if key != nil {
+			return fmt.Sprintf("%s (unencrypted): %s", msg.User, msg.Message)
+		}
+		return fmt.Sprintf("%s: %s", msg.User, msg.Message)
+	}
+	if key == nil {
+		return fmt.Sprintf("%s: <encrypted message, start the client with -passphrase to read it>", msg.User)
+	}
+	text, err := key.Open(msg.User, msg.Sealed)
+	switch err {
+	case nil:
+		return fmt.Sprintf("%s (encrypted): %s", msg.User, text)
+	case seal.ErrUnknownKey:
+		return fmt.Sprintf("%s: <cannot decrypt, message was encrypted with a different passphrase or room>", msg.User)
+	default:
+		return fmt.Sprintf("%s: <cannot decrypt, message is corrupted or was tampered with>", msg.User)
+	}
+}
+
 func main() {
-	if len(os.Args) != 3 {
+	passphrase := flag.String("passphrase", os.Getenv("CHAT_PASSPHRASE"), "encrypt messages end-to-end with a key derived from this passphrase, defaults to $CHAT_PASSPHRASE")
+	room := flag.String("room", "lobby", "name of the room to chat in, the encryption key is derived for it")
+	token := flag.String("token", os.Getenv("CHAT_TOKEN"), "bearer token for servers running with auth-mode token, defaults to $CHAT_TOKEN")
+	caFile := flag.String("ca", "", "CA certificate to verify servers running with TLS, plaintext is used when empty")
+	serverName := flag.String("server-name", "", "overrides the host name the server certificate is verified for")
+	flag.Parse()
+	if flag.NArg() != 2 {
 		fmt.Println("Must have connection string and user name")
 		return
 	}
+	addr, user := flag.Arg(0), flag.Arg(1)
+
+	var key *seal.RoomKey
+	if *passphrase != "" {
+		var err error
+		key, err = seal.NewRoomKey(*passphrase, *room)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Messages are end-to-end encrypted for room %s with key %s \n", *room, key.ID())
+	}
 
-	ctx := context.Background()
+	// the server only relays messages between the streams of the same room
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-chat-room", *room)
+	if *token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+	}
 
-	conn, err := grpc.Dial(os.Args[1], grpc.WithInsecure())
+	transport := grpc.WithInsecure()
+	if *caFile != "" {
+		creds, err := credentials.NewClientTLSFromFile(*caFile, *serverName)
+		if err != nil {
+			panic(err)
+		}
+		transport = grpc.WithTransportCredentials(creds)
+	}
+	opts := []grpc.DialOption{
+		transport,
+		// ping the server when the transport is quiet, must not be more often than the server's keepalive-min-time.
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    time.Minute,
+			Timeout: 20 * time.Second,
+		}),
+	}
+	conn, err := grpc.Dial(addr, opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -31,6 +115,20 @@ func main() {
 		panic(err)
 	}
 
+	header, err := stream.Header()
+	if err != nil {
+		panic(err)
+	}
+	interval := heartbeatInterval(header)
+
+	// a stream must not be sent on from multiple go routines at the same time
+	var sendLock sync.Mutex
+	send := func(msg *chat.ChatMessage) error {
+		sendLock.Lock()
+		defer sendLock.Unlock()
+		return stream.Send(msg)
+	}
+
 	waitC := make(chan struct{})
 	go func() {
 		for {
@@ -41,7 +139,37 @@ func main() {
 			} else if err != nil {
 				panic(err)
 			}
-			fmt.Printf("%s: %s \n", msg.User, msg.Message)
+			switch msg.Event {
+			case chat.EventType_EVENT_TYPE_HEARTBEAT:
+				// nothing to show, our own heartbeats keep the stream alive
+			case chat.EventType_EVENT_TYPE_PRESENCE:
+				presence := strings.ToLower(strings.TrimPrefix(msg.Presence.String(), "PRESENCE_"))
+				fmt.Printf("* %s is %s \n", msg.User, presence)
+			case chat.EventType_EVENT_TYPE_REJECTED:
+				fmt.Printf("* message rejected: %s \n", msg.Message)
+			default:
+				fmt.Println(render(msg, key))
+			}
+		}
+	}()
+
+	// keep sending heartbeats so the server does not evict us while we are not typing
+	go func() {
+		if interval == 0 {
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := send(&chat.ChatMessage{User: user, Event: chat.EventType_EVENT_TYPE_HEARTBEAT})
+				if err != nil {
+					return
+				}
+			case <-waitC:
+				return
+			}
 		}
 	}()
 
@@ -50,17 +178,25 @@ func main() {
 	for scanner.Scan() {
 		msg := scanner.Text()
 		if msg == "quit" {
+			sendLock.Lock()
 			err := stream.CloseSend()
+			sendLock.Unlock()
 			if err != nil {
 				panic(err)
 			}
 			break
 		}
 
-		err := stream.Send(&chat.ChatMessage{
-			User:    os.Args[2],
-			Message: msg,
-		})
+		chatMsg := &chat.ChatMessage{User: user, Message: msg}
+		if key != nil {
+			// only the sealed text leaves this client, the server relays it without being able to read it
+			sealed, err := key.Seal(user, msg)
+			if err != nil {
+				panic(err)
+			}
+			chatMsg = &chat.ChatMessage{User: user, Sealed: sealed}
+		}
+		err := send(chatMsg)
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,100 @@
+// Package seal implements the end-to-end encryption of chat rooms.
+// All members of a room derive the same key from a shared passphrase and seal
+// message text with AES-256-GCM before it is sent, the server only ever sees ciphertext.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keySize = 32
+	// scrypt cost parameters as recommended for interactive logins.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// saltPrefix separates keys derived for chat rooms from other uses of the same passphrase.
+	saltPrefix = "grpc-playground/chat/room:"
+)
+
+var (
+	ErrEmptyPassphrase = fmt.Errorf("passphrase must not be empty")
+	ErrUnknownKey      = fmt.Errorf("message was sealed with a different key")
+	ErrDecrypt         = fmt.Errorf("message could not be decrypted")
+)
+
+// RoomKey seals and opens the messages of one room.
+type RoomKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewRoomKey derives the key of room from passphrase.
+// Every member using the same passphrase and room name derives the same key.
+func NewRoomKey(passphrase, room string) (*RoomKey, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	key, err := scrypt.Key([]byte(passphrase), []byte(saltPrefix+room), scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// the id is a hash of the key, it does not allow recovering the key but tells members whether they share it.
+	fingerprint := sha256.Sum256(key)
+	return &RoomKey{
+		id:   hex.EncodeToString(fingerprint[:8]),
+		aead: aead,
+	}, nil
+}
+
+// ID returns the fingerprint of the key sent along with every sealed message.
+func (k *RoomKey) ID() string {
+	return k.id
+}
+
+// Seal encrypts text sent by user. The user name is authenticated as well,
+// so a relay cannot attribute the message to someone else.
+func (k *RoomKey) Seal(user, text string) (*chat.SealedMessage, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.SealedMessage{
+		KeyId:      k.id,
+		Nonce:      nonce,
+		Ciphertext: k.aead.Seal(nil, nonce, []byte(text), []byte(user)),
+	}, nil
+}
+
+// Open decrypts a message sealed by user. It returns ErrUnknownKey when the message was
+// sealed with another key and ErrDecrypt when it was tampered with or attributed to the wrong user.
+func (k *RoomKey) Open(user string, sealed *chat.SealedMessage) (string, error) {
+	if sealed.GetKeyId() != k.id {
+		return "", ErrUnknownKey
+	}
+	if len(sealed.GetNonce()) != k.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	text, err := k.aead.Open(nil, sealed.GetNonce(), sealed.GetCiphertext(), []byte(user))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(text), nil
+}
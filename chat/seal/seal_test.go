@@ -0,0 +1,97 @@
+package seal
+
+import (
+	"testing"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRoomKey_SealOpen(t *testing.T) {
+	alice, err := NewRoomKey("correct horse battery staple", "lobby")
+	require.NoError(t, err)
+	bob, err := NewRoomKey("correct horse battery staple", "lobby")
+	require.NoError(t, err)
+	mallory, err := NewRoomKey("wrong passphrase", "lobby")
+	require.NoError(t, err)
+	otherRoom, err := NewRoomKey("correct horse battery staple", "kitchen")
+	require.NoError(t, err)
+
+	require.Equal(t, alice.ID(), bob.ID(), "same passphrase and room must derive the same key")
+	require.NotEqual(t, alice.ID(), mallory.ID())
+	require.NotEqual(t, alice.ID(), otherRoom.ID())
+
+	sealed, err := alice.Seal("alice", "meet at noon")
+	require.NoError(t, err)
+	require.NotContains(t, string(sealed.Ciphertext), "meet at noon")
+
+	tamperedCiphertext := proto.Clone(sealed).(*chat.SealedMessage)
+	tamperedCiphertext.Ciphertext[0] ^= 0xff
+	shortNonce := proto.Clone(sealed).(*chat.SealedMessage)
+	shortNonce.Nonce = shortNonce.Nonce[:4]
+
+	testCases := []struct {
+		description  string
+		key          *RoomKey
+		user         string
+		sealed       *chat.SealedMessage
+		expectedText string
+		expectedErr  error
+	}{
+		{
+			description:  "golden case: member opens message",
+			key:          bob,
+			user:         "alice",
+			sealed:       sealed,
+			expectedText: "meet at noon",
+		},
+		{
+			description: "failure case: different passphrase",
+			key:         mallory,
+			user:        "alice",
+			sealed:      sealed,
+			expectedErr: ErrUnknownKey,
+		},
+		{
+			description: "failure case: different room",
+			key:         otherRoom,
+			user:        "alice",
+			sealed:      sealed,
+			expectedErr: ErrUnknownKey,
+		},
+		{
+			description: "failure case: message attributed to another user",
+			key:         bob,
+			user:        "mallory",
+			sealed:      sealed,
+			expectedErr: ErrDecrypt,
+		},
+		{
+			description: "failure case: tampered ciphertext",
+			key:         bob,
+			user:        "alice",
+			sealed:      tamperedCiphertext,
+			expectedErr: ErrDecrypt,
+		},
+		{
+			description: "failure case: invalid nonce",
+			key:         bob,
+			user:        "alice",
+			sealed:      shortNonce,
+			expectedErr: ErrDecrypt,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			text, err := tc.key.Open(tc.user, tc.sealed)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedText, text)
+		})
+	}
+}
+
+func TestNewRoomKey_EmptyPassphrase(t *testing.T) {
+	_, err := NewRoomKey("", "lobby")
+	require.Equal(t, ErrEmptyPassphrase, err)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/pgbytes/grpc-playground/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// staticTokenIdentity is logged for callers authenticated by the shared token, which carries no user of its own.
+const staticTokenIdentity = "static-token"
+
+// queryTokenParam is the query parameter browsers pass the token in, see requireToken.
+const queryTokenParam = "token"
+
+// newTokenAuthenticator accepts token as bearer token, granting the static token identity.
+func newTokenAuthenticator(token string) (*auth.StaticTokens, error) {
+	return auth.NewStaticTokens([]auth.StaticToken{{Token: token, Subject: staticTokenIdentity}})
+}
+
+// tokenStreamInterceptor rejects streams the authenticator does not accept.
+// Health checks and server reflection are exempt, so orchestrators and tools can probe the server without the token.
+func tokenStreamInterceptor(a auth.Authenticator) grpc.StreamServerInterceptor {
+	return auth.StreamServerInterceptor(a, auth.WithExemptMethods(auth.HealthService, auth.ReflectionService, auth.ReflectionAlphaService))
+}
+
+// requireToken rejects WebSocket upgrades the authenticator does not accept, reading the bearer token
+// of the Authorization header.
+//
+// Browsers cannot set headers on WebSocket requests, so browser upgrades, i.e. upgrades with an Origin header,
+// without Authorization header may pass the token in the token query parameter instead. Query strings end up
+// in access logs of proxies and in the browser history, so the parameter is removed before the request is
+// handled, and clients that can set headers must use the Authorization header.
+func requireToken(a auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		md := metadata.MD{auth.AuthorizationKey: r.Header.Values("Authorization")}
+		query := r.URL.Query()
+		if query.Has(queryTokenParam) {
+			if len(md[auth.AuthorizationKey]) > 0 || r.Header.Get("Origin") == "" || !websocket.IsWebSocketUpgrade(r) {
+				http.Error(w, "the token query parameter is only accepted from browsers, use the Authorization header", http.StatusBadRequest)
+				return
+			}
+			md.Set(auth.AuthorizationKey, "Bearer "+query.Get(queryTokenParam))
+			query.Del(queryTokenParam)
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+		}
+		_, err := a.Authenticate(metadata.NewIncomingContext(r.Context(), md))
+		if err != nil {
+			http.Error(w, status.Convert(err).Message(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/logging"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	authModeNone  = "none"
+	authModeToken = "token"
+
+	historyStoreNone   = "none"
+	historyStoreMemory = "memory"
+	historyStoreFile   = "file"
+
+	// envPrefix is prepended to the upper cased flag name to build its environment variable, e.g. CHAT_WS_ADDR.
+	envPrefix = "CHAT_"
+)
+
+// Config is the effective configuration of the chat server.
+// Values are resolved from defaults, the config file, environment variables and flags, the latter taking precedence.
+type Config struct {
+	ListenAddr    string `yaml:"listen_addr"`
+	WebSocketAddr string `yaml:"websocket_addr"`
+	// WebSocketOrigins are the origins besides the server's own that browsers may open WebSocket connections from.
+	WebSocketOrigins originList      `yaml:"websocket_origins"`
+	TLS              TLSConfig       `yaml:"tls"`
+	Auth             AuthConfig      `yaml:"auth"`
+	History          HistoryConfig   `yaml:"history"`
+	Buffers          BufferConfig    `yaml:"buffers"`
+	RateLimit        RateLimitConfig `yaml:"rate_limit"`
+	Idle             IdlePolicy      `yaml:"idle"`
+	Keepalive        KeepalivePolicy `yaml:"keepalive"`
+	Health           HealthConfig    `yaml:"health"`
+	Shutdown         ShutdownConfig  `yaml:"shutdown"`
+	LogLevel         string          `yaml:"log_level"`
+	LogFormat        string          `yaml:"log_format"`
+}
+
+// originList is a list of origins, set from a comma separated flag, e.g. https://a.example,https://b.example.
+type originList []string
+
+func (o *originList) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *originList) Set(value string) error {
+	*o = nil
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			*o = append(*o, origin)
+		}
+	}
+	return nil
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// AuthConfig selects how clients authenticate, mode "token" requires the bearer token on every stream.
+type AuthConfig struct {
+	Mode  string `yaml:"mode"`
+	Token string `yaml:"token"`
+}
+
+// HistoryConfig selects where recent messages are kept to be replayed to users joining the chat.
+type HistoryConfig struct {
+	Store string `yaml:"store"`
+	Size  int    `yaml:"size"`
+	Path  string `yaml:"path"`
+}
+
+// HealthConfig controls how often the health service checks dependencies such as the history store.
+type HealthConfig struct {
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// ShutdownConfig controls graceful shutdown: NOT_SERVING is reported for Drain before the server stops
+// accepting streams, streams still open after Timeout are cancelled.
+type ShutdownConfig struct {
+	Drain   time.Duration `yaml:"drain"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type BufferConfig struct {
+	Send      int `yaml:"send"`
+	Broadcast int `yaml:"broadcast"`
+}
+
+// RateLimitConfig limits the messages every connection may send, a zero rate disables the limit.
+type RateLimitConfig struct {
+	MessagesPerSecond float64 `yaml:"messages_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// DefaultConfig returns the configuration used for everything not configured otherwise.
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr: ":8080",
+		Auth:       AuthConfig{Mode: authModeNone},
+		History:    HistoryConfig{Store: historyStoreNone, Size: 100},
+		Idle: IdlePolicy{
+			HeartbeatInterval: 10 * time.Second,
+			IdleTimeout:       30 * time.Second,
+		},
+		Keepalive: KeepalivePolicy{
+			Time:    time.Minute,
+			Timeout: 20 * time.Second,
+			MinTime: 5 * time.Second,
+		},
+		Health:    HealthConfig{CheckInterval: 10 * time.Second},
+		Shutdown:  ShutdownConfig{Drain: 5 * time.Second, Timeout: 30 * time.Second},
+		LogLevel:  "info",
+		LogFormat: logging.FormatText,
+	}
+}
+
+// bindFlags registers a flag for every config value, flags write straight into cfg.
+func bindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.ListenAddr, "addr", cfg.ListenAddr, "address to serve the gRPC chat service on")
+	fs.StringVar(&cfg.WebSocketAddr, "ws-addr", cfg.WebSocketAddr, "address to serve the WebSocket bridge on, disabled when empty")
+	fs.Var(&cfg.WebSocketOrigins, "ws-origins", "comma separated origins browsers may connect to the WebSocket bridge from besides its own, e.g. https://chat.example.com")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "TLS certificate file, TLS is disabled when empty")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "TLS private key file")
+	fs.StringVar(&cfg.Auth.Mode, "auth-mode", cfg.Auth.Mode, "client authentication: none or token")
+	fs.StringVar(&cfg.Auth.Token, "auth-token", cfg.Auth.Token, "bearer token clients must present when auth-mode is token")
+	fs.StringVar(&cfg.History.Store, "history-store", cfg.History.Store, "where recent messages are kept: none, memory or file")
+	fs.IntVar(&cfg.History.Size, "history-size", cfg.History.Size, "number of recent messages replayed to joining users")
+	fs.StringVar(&cfg.History.Path, "history-path", cfg.History.Path, "file messages are appended to when history-store is file")
+	fs.IntVar(&cfg.Buffers.Send, "send-buffer", cfg.Buffers.Send, "messages buffered for every connection before broadcasting blocks")
+	fs.IntVar(&cfg.Buffers.Broadcast, "broadcast-buffer", cfg.Buffers.Broadcast, "messages buffered before receiving from connections blocks")
+	fs.Float64Var(&cfg.RateLimit.MessagesPerSecond, "rate-limit", cfg.RateLimit.MessagesPerSecond, "messages per second every connection may send, 0 disables the limit")
+	fs.IntVar(&cfg.RateLimit.Burst, "rate-burst", cfg.RateLimit.Burst, "messages a connection may send at once above the rate limit")
+	fs.DurationVar(&cfg.Idle.HeartbeatInterval, "heartbeat-interval", cfg.Idle.HeartbeatInterval, "interval of heartbeats sent on every chat stream")
+	fs.DurationVar(&cfg.Idle.IdleTimeout, "idle-timeout", cfg.Idle.IdleTimeout, "evict chat streams quiet for longer than this, 0 disables eviction")
+	fs.DurationVar(&cfg.Keepalive.Time, "keepalive-time", cfg.Keepalive.Time, "ping clients after the transport has been quiet for this long")
+	fs.DurationVar(&cfg.Keepalive.Timeout, "keepalive-timeout", cfg.Keepalive.Timeout, "close the transport when a ping is not acknowledged within this time")
+	fs.DurationVar(&cfg.Keepalive.MinTime, "keepalive-min-time", cfg.Keepalive.MinTime, "minimum interval clients are allowed to send keepalive pings at")
+	fs.DurationVar(&cfg.Health.CheckInterval, "health-check-interval", cfg.Health.CheckInterval, "interval the dependencies reported by the health service are checked at")
+	fs.DurationVar(&cfg.Shutdown.Drain, "shutdown-drain", cfg.Shutdown.Drain, "time NOT_SERVING is reported on shutdown before the server stops accepting streams")
+	fs.DurationVar(&cfg.Shutdown.Timeout, "shutdown-timeout", cfg.Shutdown.Timeout, "time open streams may take to finish on shutdown before they are cancelled")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum level of logged diagnostics: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "format of the logs: text or json")
+}
+
+// envName returns the environment variable overriding the flag name, e.g. CHAT_WS_ADDR for ws-addr.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadConfig resolves the configuration from defaults, the config file given by -config or $CHAT_CONFIG,
+// environment variables and finally the command line args, then validates it.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := DefaultConfig()
+	fs := flag.NewFlagSet("chat-server", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML config file, defaults to $"+envName("config"))
+	bindFlags(fs, &cfg)
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+	// remember the flags given on the command line, they are applied again last to take precedence.
+	given := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	cfg = DefaultConfig()
+	path := *configFile
+	if envPath, ok := lookupEnv(envName("config")); ok && path == "" {
+		path = envPath
+	}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&cfg)
+		if err != nil {
+			return Config{}, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok || f.Name == "config" || envErr != nil {
+			return
+		}
+		err := fs.Set(f.Name, value)
+		if err != nil {
+			envErr = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err)
+		}
+	})
+	if envErr != nil {
+		return Config{}, envErr
+	}
+	for name, value := range given {
+		err := fs.Set(name, value)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// redacted returns a copy of the configuration safe to log.
+func (c Config) redacted() Config {
+	if c.Auth.Token != "" {
+		c.Auth.Token = "REDACTED"
+	}
+	return c
+}
+
+// Validate reports the first invalid setting of the configuration.
+func (c Config) Validate() error {
+	if c.ListenAddr == "" {
+		return fmt.Errorf("listen_addr must not be empty")
+	}
+	for _, origin := range c.WebSocketOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			return fmt.Errorf("websocket_origins: %q is no origin, e.g. https://chat.example.com", origin)
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+	switch c.Auth.Mode {
+	case authModeNone:
+	case authModeToken:
+		if c.Auth.Token == "" {
+			return fmt.Errorf("auth.token must be set for auth mode %q", authModeToken)
+		}
+	default:
+		return fmt.Errorf("unknown auth.mode %q, must be one of: %s, %s", c.Auth.Mode, authModeNone, authModeToken)
+	}
+	switch c.History.Store {
+	case historyStoreNone, historyStoreMemory:
+	case historyStoreFile:
+		if c.History.Path == "" {
+			return fmt.Errorf("history.path must be set for history store %q", historyStoreFile)
+		}
+	default:
+		return fmt.Errorf("unknown history.store %q, must be one of: %s, %s, %s", c.History.Store, historyStoreNone, historyStoreMemory, historyStoreFile)
+	}
+	if c.History.Store != historyStoreNone && c.History.Size <= 0 {
+		return fmt.Errorf("history.size must be positive, got %d", c.History.Size)
+	}
+	if c.Buffers.Send < 0 || c.Buffers.Broadcast < 0 {
+		return fmt.Errorf("buffer sizes must not be negative")
+	}
+	if c.RateLimit.MessagesPerSecond < 0 {
+		return fmt.Errorf("rate_limit.messages_per_second must not be negative")
+	}
+	if c.RateLimit.MessagesPerSecond > 0 && c.RateLimit.Burst < 1 {
+		return fmt.Errorf("rate_limit.burst must be at least 1 when rate limiting")
+	}
+	if c.Idle.IdleTimeout > 0 && c.Idle.HeartbeatInterval <= 0 {
+		return fmt.Errorf("idle.heartbeat_interval must be positive when idle.timeout is set")
+	}
+	if c.Idle.IdleTimeout > 0 && c.Idle.IdleTimeout < c.Idle.HeartbeatInterval {
+		return fmt.Errorf("idle.timeout %s must not be shorter than idle.heartbeat_interval %s", c.Idle.IdleTimeout, c.Idle.HeartbeatInterval)
+	}
+	if c.Health.CheckInterval <= 0 {
+		return fmt.Errorf("health.check_interval must be positive, got %s", c.Health.CheckInterval)
+	}
+	if c.Shutdown.Drain < 0 || c.Shutdown.Timeout < 0 {
+		return fmt.Errorf("shutdown.drain and shutdown.timeout must not be negative")
+	}
+	_, err := logging.New(io.Discard, c.LogFormat, c.LogLevel)
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigFile = `
+listen_addr: ":9090"
+tls:
+  cert_file: server.pem
+  key_file: server.key
+auth:
+  mode: token
+  token: from-file
+history:
+  store: memory
+  size: 10
+rate_limit:
+  messages_per_second: 5
+  burst: 10
+idle:
+  heartbeat_interval: 5s
+  timeout: 15s
+log_level: debug
+websocket_origins:
+  - https://chat.example.com
+`
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "chat.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func envFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, testConfigFile)
+	testCases := []struct {
+		description string
+		args        []string
+		env         map[string]string
+		check       func(t *testing.T, cfg Config)
+	}{
+		{
+			description: "golden case: defaults without any configuration",
+			check: func(t *testing.T, cfg Config) {
+				require.Equal(t, DefaultConfig(), cfg)
+			},
+		},
+		{
+			description: "config file overrides defaults",
+			args:        []string{"-config", path},
+			check: func(t *testing.T, cfg Config) {
+				require.Equal(t, ":9090", cfg.ListenAddr)
+				require.Equal(t, "server.pem", cfg.TLS.CertFile)
+				require.Equal(t, AuthConfig{Mode: authModeToken, Token: "from-file"}, cfg.Auth)
+				require.Equal(t, 5*time.Second, cfg.Idle.HeartbeatInterval)
+				require.Equal(t, 15*time.Second, cfg.Idle.IdleTimeout)
+				require.Equal(t, 5.0, cfg.RateLimit.MessagesPerSecond)
+				require.Equal(t, originList{"https://chat.example.com"}, cfg.WebSocketOrigins)
+				require.Equal(t, DefaultConfig().Keepalive, cfg.Keepalive, "settings missing in file keep their default")
+			},
+		},
+		{
+			description: "config file from environment",
+			env:         map[string]string{"CHAT_CONFIG": path},
+			check: func(t *testing.T, cfg Config) {
+				require.Equal(t, ":9090", cfg.ListenAddr)
+			},
+		},
+		{
+			description: "environment overrides config file",
+			args:        []string{"-config", path},
+			env: map[string]string{"CHAT_ADDR": ":7070", "CHAT_AUTH_TOKEN": "from-env", "CHAT_IDLE_TIMEOUT": "1m",
+				"CHAT_WS_ORIGINS": "https://a.example.com, https://b.example.com"},
+			check: func(t *testing.T, cfg Config) {
+				require.Equal(t, ":7070", cfg.ListenAddr)
+				require.Equal(t, "from-env", cfg.Auth.Token)
+				require.Equal(t, originList{"https://a.example.com", "https://b.example.com"}, cfg.WebSocketOrigins)
+				require.Equal(t, time.Minute, cfg.Idle.IdleTimeout)
+			},
+		},
+		{
+			description: "flags override environment and config file",
+			args:        []string{"-config", path, "-addr", ":6060", "-log-level", "warn", "-ws-origins", "https://c.example.com"},
+			env:         map[string]string{"CHAT_ADDR": ":7070", "CHAT_WS_ORIGINS": "https://a.example.com"},
+			check: func(t *testing.T, cfg Config) {
+				require.Equal(t, ":6060", cfg.ListenAddr)
+				require.Equal(t, "warn", cfg.LogLevel)
+				require.Equal(t, "from-file", cfg.Auth.Token)
+				require.Equal(t, originList{"https://c.example.com"}, cfg.WebSocketOrigins)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			cfg, err := LoadConfig(tc.args, envFrom(tc.env))
+			require.NoError(t, err)
+			tc.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	testCases := []struct {
+		description string
+		args        []string
+		env         map[string]string
+		file        string
+	}{
+		{description: "unknown auth mode", args: []string{"-auth-mode", "magic"}},
+		{description: "token auth without token", args: []string{"-auth-mode", "token"}},
+		{description: "tls cert without key", args: []string{"-tls-cert", "server.pem"}},
+		{description: "websocket origin with path", args: []string{"-ws-origins", "https://chat.example.com/app"}},
+		{description: "websocket origin without scheme", args: []string{"-ws-origins", "chat.example.com"}},
+		{description: "file history without path", args: []string{"-history-store", "file"}},
+		{description: "unknown history store", args: []string{"-history-store", "redis"}},
+		{description: "negative buffer", args: []string{"-send-buffer", "-1"}},
+		{description: "rate limit without burst", args: []string{"-rate-limit", "10"}},
+		{description: "idle timeout shorter than heartbeat", args: []string{"-heartbeat-interval", "10s", "-idle-timeout", "5s"}},
+		{description: "health checks without interval", args: []string{"-health-check-interval", "0s"}},
+		{description: "negative shutdown drain", args: []string{"-shutdown-drain", "-1s"}},
+		{description: "negative shutdown timeout", args: []string{"-shutdown-timeout", "-1s"}},
+		{description: "unknown log level", args: []string{"-log-level", "chatty"}},
+		{description: "unknown log format", args: []string{"-log-format", "xml"}},
+		{description: "malformed environment value", env: map[string]string{"CHAT_SEND_BUFFER": "many"}},
+		{description: "unknown setting in config file", file: "listen_adr: \":8080\""},
+		{description: "missing config file", args: []string{"-config", "does-not-exist.yaml"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				args = append(args, "-config", writeConfigFile(t, tc.file))
+			}
+			_, err := LoadConfig(args, envFrom(tc.env))
+			require.Error(t, err)
+		})
+	}
+}
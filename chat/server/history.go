@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// HistoryStore keeps the most recent chat messages to replay them to users joining the chat.
+// End-to-end encrypted messages are stored as relayed, as ciphertext.
+type HistoryStore interface {
+	Append(msg *chat.ChatMessage) error
+	Recent() ([]*chat.ChatMessage, error)
+	// Check reports whether the store can keep messages, for the health service.
+	Check(ctx context.Context) error
+	Close() error
+}
+
+// newHistoryStore creates the store selected by cfg, nil when history is disabled.
+func newHistoryStore(cfg HistoryConfig) (HistoryStore, error) {
+	switch cfg.Store {
+	case historyStoreMemory:
+		return newMemoryHistory(cfg.Size), nil
+	case historyStoreFile:
+		return openFileHistory(cfg.Path, cfg.Size)
+	default:
+		return nil, nil
+	}
+}
+
+// memoryHistory keeps the last size messages in a ring buffer.
+type memoryHistory struct {
+	lock     sync.Mutex
+	messages []*chat.ChatMessage
+	next     int
+	full     bool
+}
+
+func newMemoryHistory(size int) *memoryHistory {
+	return &memoryHistory{messages: make([]*chat.ChatMessage, size)}
+}
+
+func (m *memoryHistory) Append(msg *chat.ChatMessage) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages[m.next] = msg
+	m.next = (m.next + 1) % len(m.messages)
+	if m.next == 0 {
+		m.full = true
+	}
+	return nil
+}
+
+// Recent returns the stored messages, oldest first.
+func (m *memoryHistory) Recent() ([]*chat.ChatMessage, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.full {
+		return append([]*chat.ChatMessage{}, m.messages[:m.next]...), nil
+	}
+	return append(append([]*chat.ChatMessage{}, m.messages[m.next:]...), m.messages[:m.next]...), nil
+}
+
+func (m *memoryHistory) Check(context.Context) error {
+	return nil
+}
+
+func (m *memoryHistory) Close() error {
+	return nil
+}
+
+// historyQueueSize is the number of messages that may wait to be written to the history file.
+const historyQueueSize = 1024
+
+// errHistoryBehind fails messages arriving while the queue of messages waiting to be written is full.
+var errHistoryBehind = errors.New("too many messages waiting to be written")
+
+// historyFile is the file messages are written to, an *os.File.
+type historyFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// fileHistory appends every message as a proto JSON line to a file and serves recent messages from memory.
+// The last messages of an existing file are loaded on open, so history survives restarts, and the file is
+// trimmed to them. Messages are
+// written by a goroutine of their own, so a slow disk does not hold up the broadcast appending them.
+type fileHistory struct {
+	*memoryHistory
+	file      historyFile
+	lines     chan []byte
+	quit      chan struct{}
+	written   chan struct{}
+	closeOnce sync.Once
+	lock      sync.Mutex
+	// writeErr is the error of the last write, nil once writing succeeds again
+	writeErr error
+}
+
+func openFileHistory(path string, size int) (*fileHistory, error) {
+	memory := newMemoryHistory(size)
+	count, err := loadHistoryFile(path, memory)
+	if err != nil {
+		return nil, err
+	}
+	if count > size {
+		// the file only grows while serving, keep it to the messages replayed
+		if err := rewriteHistoryFile(path, memory); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening history file: %w", err)
+	}
+	return newFileHistory(memory, file), nil
+}
+
+// loadHistoryFile appends the messages of the file at path to memory, which keeps the last of them, and returns
+// the number of messages in the file. The file is streamed line by line, lines of any length are read.
+func loadHistoryFile(path string, memory *memoryHistory) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("reading history file: %w", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	count := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			msg := &chat.ChatMessage{}
+			if err := protojson.Unmarshal(line, msg); err != nil {
+				return 0, fmt.Errorf("parsing history file %s: %w", path, err)
+			}
+			_ = memory.Append(msg)
+			count++
+		}
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return 0, fmt.Errorf("reading history file: %w", err)
+		}
+	}
+}
+
+// rewriteHistoryFile replaces the file at path by one holding the messages of memory only.
+func rewriteHistoryFile(path string, memory *memoryHistory) error {
+	recent, _ := memory.Recent()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("trimming history file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	for _, msg := range recent {
+		line, err := protojson.Marshal(msg)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("trimming history file: %w", err)
+		}
+		_, _ = writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("trimming history file: %w", err)
+	}
+	return nil
+}
+
+func newFileHistory(memory *memoryHistory, file historyFile) *fileHistory {
+	f := &fileHistory{
+		memoryHistory: memory,
+		file:          file,
+		lines:         make(chan []byte, historyQueueSize),
+		quit:          make(chan struct{}),
+		written:       make(chan struct{}),
+	}
+	go f.write()
+	return f
+}
+
+// Append queues the message to be written and keeps it for replay right away. It fails once closed,
+// or when the file is too far behind, i.e. the queue is full.
+func (f *fileHistory) Append(msg *chat.ChatMessage) error {
+	line, err := protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-f.quit:
+		return fmt.Errorf("writing history file: %w", os.ErrClosed)
+	default:
+	}
+	select {
+	case f.lines <- append(line, '\n'):
+	default:
+		f.setWriteErr(errHistoryBehind)
+		return fmt.Errorf("writing history file: %w", errHistoryBehind)
+	}
+	return f.memoryHistory.Append(msg)
+}
+
+// write writes the queued lines to the file until closed, then writes what is still queued.
+func (f *fileHistory) write() {
+	defer close(f.written)
+	for {
+		select {
+		case line := <-f.lines:
+			f.writeLine(line)
+		case <-f.quit:
+			for {
+				select {
+				case line := <-f.lines:
+					f.writeLine(line)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (f *fileHistory) writeLine(line []byte) {
+	_, err := f.file.Write(line)
+	f.setWriteErr(err)
+	if err != nil {
+		slog.Warn("storing message in history failed", "error", err)
+	}
+}
+
+func (f *fileHistory) setWriteErr(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.writeErr = err
+}
+
+// Check fails while writing messages fails, or the file cannot be synced to disk.
+func (f *fileHistory) Check(context.Context) error {
+	f.lock.Lock()
+	writeErr := f.writeErr
+	f.lock.Unlock()
+	if writeErr != nil {
+		return fmt.Errorf("writing history file: %w", writeErr)
+	}
+	err := f.file.Sync()
+	if err != nil {
+		return fmt.Errorf("syncing history file: %w", err)
+	}
+	return nil
+}
+
+// Close writes the messages still queued and closes the file.
+func (f *fileHistory) Close() error {
+	err := os.ErrClosed
+	f.closeOnce.Do(func() {
+		close(f.quit)
+		<-f.written
+		err = f.file.Close()
+	})
+	return err
+}
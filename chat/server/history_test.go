@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func messageTexts(messages []*chat.ChatMessage) []string {
+	texts := []string{}
+	for _, msg := range messages {
+		texts = append(texts, msg.Message)
+	}
+	return texts
+}
+
+func TestMemoryHistory(t *testing.T) {
+	history := newMemoryHistory(3)
+	recent, err := history.Recent()
+	require.NoError(t, err)
+	require.Empty(t, recent)
+
+	for _, text := range []string{"one", "two"} {
+		require.NoError(t, history.Append(&chat.ChatMessage{Message: text}))
+	}
+	recent, err = history.Recent()
+	require.NoError(t, err)
+	require.Equal(t, []string{"one", "two"}, messageTexts(recent))
+
+	for _, text := range []string{"three", "four", "five"} {
+		require.NoError(t, history.Append(&chat.ChatMessage{Message: text}))
+	}
+	recent, err = history.Recent()
+	require.NoError(t, err)
+	require.Equal(t, []string{"three", "four", "five"}, messageTexts(recent))
+}
+
+func TestFileHistory_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := openFileHistory(path, 2)
+	require.NoError(t, err)
+	sealed := &chat.ChatMessage{User: "alice", Sealed: &chat.SealedMessage{KeyId: "abc", Nonce: []byte{1}, Ciphertext: []byte{2}}}
+	for _, msg := range []*chat.ChatMessage{{User: "bob", Message: "one"}, {User: "bob", Message: "two"}, sealed} {
+		require.NoError(t, history.Append(msg))
+	}
+	require.NoError(t, history.Close())
+
+	reopened, err := openFileHistory(path, 2)
+	require.NoError(t, err)
+	defer reopened.Close()
+	recent, err := reopened.Recent()
+	require.NoError(t, err)
+	require.Len(t, recent, 2)
+	require.Equal(t, "two", recent[0].Message)
+	require.True(t, proto.Equal(sealed, recent[1]), "sealed messages are stored as ciphertext")
+}
+
+func TestFileHistory_TrimmedOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := openFileHistory(path, 2)
+	require.NoError(t, err)
+	long := strings.Repeat("x", 100*1024)
+	for _, text := range []string{"one", "two", long, "four"} {
+		require.NoError(t, history.Append(&chat.ChatMessage{User: "bob", Message: text}))
+	}
+	require.NoError(t, history.Close())
+
+	t.Log("testing: lines longer than a scanner token are loaded")
+	reopened, err := openFileHistory(path, 2)
+	require.NoError(t, err)
+	recent, err := reopened.Recent()
+	require.NoError(t, err)
+	require.Equal(t, []string{long, "four"}, messageTexts(recent))
+	require.NoError(t, reopened.Close())
+
+	t.Log("testing: the file keeps the replayed messages only")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(content, []byte("\n")))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file is left behind")
+}
+
+func TestFileHistory_Check(t *testing.T) {
+	history, err := openFileHistory(filepath.Join(t.TempDir(), "history.jsonl"), 2)
+	require.NoError(t, err)
+	require.NoError(t, history.Append(&chat.ChatMessage{User: "bob", Message: "one"}))
+	require.NoError(t, history.Check(context.Background()))
+
+	t.Log("testing: a store that cannot write messages is unhealthy")
+	require.NoError(t, history.Close())
+	require.Error(t, history.Append(&chat.ChatMessage{User: "bob", Message: "two"}))
+	require.Error(t, history.Check(context.Background()))
+}
+
+// slowFile is a history file whose writes block until release is closed.
+type slowFile struct {
+	release chan struct{}
+	lock    sync.Mutex
+	written []string
+}
+
+func (s *slowFile) Write(p []byte) (int, error) {
+	<-s.release
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.written = append(s.written, string(p))
+	return len(p), nil
+}
+
+func (s *slowFile) Sync() error  { return nil }
+func (s *slowFile) Close() error { return nil }
+
+func TestFileHistory_SlowDisk(t *testing.T) {
+	file := &slowFile{release: make(chan struct{})}
+	history := newFileHistory(newMemoryHistory(2), file)
+
+	t.Log("testing: appending does not wait for the disk")
+	appended := make(chan error, 1)
+	go func() {
+		appended <- history.Append(&chat.ChatMessage{User: "bob", Message: "one"})
+	}()
+	select {
+	case err := <-appended:
+		require.NoError(t, err)
+	case <-time.After(recvTimeout):
+		require.Fail(t, "append waited for the history file")
+	}
+	recent, err := history.Recent()
+	require.NoError(t, err)
+	require.Equal(t, []string{"one"}, messageTexts(recent))
+
+	t.Log("testing: queued messages are written on close")
+	close(file.release)
+	require.NoError(t, history.Close())
+	require.Len(t, file.written, 1)
+	require.Contains(t, file.written[0], `"message":"one"`)
+}
+
+func TestFileHistory_QueueFull(t *testing.T) {
+	file := &slowFile{release: make(chan struct{})}
+	history := newFileHistory(newMemoryHistory(2), file)
+	defer func() {
+		close(file.release)
+		_ = history.Close()
+	}()
+
+	// the first message is held by the blocked write, the others fill the queue
+	require.NoError(t, history.Append(&chat.ChatMessage{User: "bob", Message: "writing"}))
+	require.Eventually(t, func() bool { return len(history.lines) == 0 }, recvTimeout, time.Millisecond)
+	for i := 0; i < historyQueueSize; i++ {
+		require.NoError(t, history.Append(&chat.ChatMessage{User: "bob", Message: "queued"}))
+	}
+	err := history.Append(&chat.ChatMessage{User: "bob", Message: "dropped"})
+	require.ErrorIs(t, err, errHistoryBehind)
+	require.ErrorIs(t, history.Check(context.Background()), errHistoryBehind)
+}
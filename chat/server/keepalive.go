@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+// heartbeatMetadataKey is the response header telling clients how often to send heartbeats, e.g. 15s,
+// 0s when they need not. Terminal clients read it from the stream header, browsers from the WebSocket handshake.
+const heartbeatMetadataKey = "x-chat-heartbeat-interval"
+
+var errIdleTimeout = status.Error(codes.Unavailable, "connection evicted after being idle for too long")
+
+// IdlePolicy controls the application-level heartbeats on the chat stream.
+// The server sends a heartbeat every HeartbeatInterval and evicts connections
+// it has not received anything from, heartbeats included, for IdleTimeout.
+// A zero IdleTimeout disables eviction.
+type IdlePolicy struct {
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	IdleTimeout       time.Duration `yaml:"timeout"`
+}
+
+// ClientHeartbeatInterval is how often clients must send heartbeats not to be evicted, half the idle timeout
+// so a late heartbeat still arrives in time. Zero when idle connections are not evicted.
+func (p IdlePolicy) ClientHeartbeatInterval() time.Duration {
+	if p.IdleTimeout <= 0 {
+		return 0
+	}
+	return p.IdleTimeout / 2
+}
+
+// KeepalivePolicy holds the transport level HTTP/2 keepalive settings of the server.
+type KeepalivePolicy struct {
+	// Time after which the server pings a quiet client.
+	Time time.Duration `yaml:"time"`
+	// Timeout after a ping the server waits before closing the transport.
+	Timeout time.Duration `yaml:"timeout"`
+	// MinTime is the minimum interval clients are allowed to ping at, faster clients are disconnected.
+	MinTime time.Duration `yaml:"min_time"`
+}
+
+// ServerOptions returns the gRPC server options enforcing the keepalive policy.
+func (k KeepalivePolicy) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    k.Time,
+			Timeout: k.Timeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             k.MinTime,
+			PermitWithoutStream: true,
+		}),
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/pgbytes/grpc-playground/auth"
+	"github.com/pgbytes/grpc-playground/health"
+	"github.com/pgbytes/grpc-playground/logging"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"github.com/pgbytes/grpc-playground/validate"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// MessageStream is the transport a Connection exchanges chat messages over.
+// chat.ChatService_ChatServer satisfies it, as does the WebSocket bridge.
+type MessageStream interface {
+	Send(*chat.ChatMessage) error
+	Recv() (*chat.ChatMessage, error)
+}
+
+// ChatServerOptions tune the broadcast and the connections of a ChatServer.
+type ChatServerOptions struct {
+	Idle IdlePolicy
+	// SendBuffer is the number of messages queued for every connection.
+	SendBuffer int
+	// BroadcastBuffer is the number of messages queued for broadcasting.
+	BroadcastBuffer int
+	// RateLimit is the number of messages per second a connection may send, 0 is unlimited.
+	RateLimit rate.Limit
+	RateBurst int
+	// History replays recent messages to joining users when set.
+	History HistoryStore
+}
+
+// roomMetadataKey is the metadata key of the room of a gRPC chat stream, streams without it chat in the unnamed room.
+const roomMetadataKey = "x-chat-room"
+
+// maxRoomLength is the longest room name, the max_len of ChatMessage.room.
+const maxRoomLength = 64
+
+type Connection struct {
+	conn      MessageStream
+	room      string
+	policy    IdlePolicy
+	limiter   *rate.Limiter
+	send      chan *chat.ChatMessage
+	quit      chan struct{}
+	idle      chan struct{}
+	closeOnce sync.Once
+	// lastSeen is the unix nano time of the last message received, accessed atomically.
+	lastSeen int64
+	userLock sync.Mutex
+	user     string
+}
+
+// NewConnection creates the connection of a peer chatting in room.
+func NewConnection(conn MessageStream, room string, opts ChatServerOptions) *Connection {
+	c := &Connection{
+		conn:     conn,
+		room:     room,
+		policy:   opts.Idle,
+		send:     make(chan *chat.ChatMessage, opts.SendBuffer),
+		quit:     make(chan struct{}),
+		idle:     make(chan struct{}),
+		lastSeen: time.Now().UnixNano(),
+	}
+	if opts.RateLimit > 0 {
+		c.limiter = rate.NewLimiter(opts.RateLimit, opts.RateBurst)
+	}
+	go c.start()
+	if c.policy.HeartbeatInterval > 0 {
+		go c.heartbeat()
+	}
+	return c
+}
+
+func (c *Connection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+	})
+	return nil
+}
+
+func (c *Connection) Send(msg *chat.ChatMessage) {
+	// messages for a closed connection are dropped
+	select {
+	case c.send <- msg:
+	case <-c.quit:
+	}
+}
+
+// User returns the user name of the peer, known once it has sent its first message.
+func (c *Connection) User() string {
+	c.userLock.Lock()
+	defer c.userLock.Unlock()
+	return c.user
+}
+
+// Idle is closed once the connection has been quiet for longer than the idle timeout.
+func (c *Connection) Idle() <-chan struct{} {
+	return c.idle
+}
+
+func (c *Connection) start() {
+	running := true
+	for running {
+		select {
+		case msg := <-c.send:
+			c.conn.Send(msg)
+		case <-c.quit:
+			running = false
+		}
+	}
+}
+
+// heartbeat sends heartbeats to the peer and signals Idle when the peer stops responding.
+func (c *Connection) heartbeat() {
+	ticker := time.NewTicker(c.policy.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			quiet := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastSeen)))
+			if c.policy.IdleTimeout > 0 && quiet > c.policy.IdleTimeout {
+				close(c.idle)
+				return
+			}
+			c.Send(&chat.ChatMessage{Event: chat.EventType_EVENT_TYPE_HEARTBEAT})
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// GetMessages receives messages from the peer until it disconnects, forwarding chat
+// messages to broadcast in the room of the connection. onJoin is called with the user name of the first message received.
+// Invalid messages are rejected back to the peer only, one bad message does not end its stream.
+func (c *Connection) GetMessages(broadcast chan<- *chat.ChatMessage, onJoin func(user string)) error {
+	for {
+		msg, err := c.conn.Recv()
+		var invalid *rpcerror.Validation
+		if err == io.EOF {
+			c.Close()
+			return nil
+		} else if errors.As(err, &invalid) {
+			c.Send(&chat.ChatMessage{Event: chat.EventType_EVENT_TYPE_REJECTED, Message: invalid.Error()})
+			continue
+		} else if err != nil {
+			c.Close()
+			return err
+		}
+		atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+		c.userLock.Lock()
+		joined := c.user == "" && msg.User != ""
+		if joined {
+			c.user = msg.User
+		}
+		c.userLock.Unlock()
+		if joined {
+			onJoin(msg.User)
+		}
+		if msg.Event == chat.EventType_EVENT_TYPE_HEARTBEAT {
+			continue
+		}
+		// peers cannot post to other rooms than the one of their connection
+		msg.Room = c.room
+		if msg.Sealed != nil {
+			// end-to-end encrypted messages are relayed as ciphertext only, never alongside plain text
+			msg.Message = ""
+		}
+		if c.limiter != nil {
+			// slow down senders above the rate limit instead of dropping their messages
+			select {
+			case <-time.After(c.limiter.Reserve().Delay()):
+			case <-c.quit:
+				return nil
+			}
+		}
+		// in a separate go routine as it can still receive and then continue receiving more if the other end is not ready
+		go func(msg *chat.ChatMessage) {
+			select {
+			case broadcast <- msg:
+			case <-c.quit:
+			}
+		}(msg)
+	}
+}
+
+type ChatServer struct {
+	broadcast   chan *chat.ChatMessage
+	quit        chan struct{}
+	opts        ChatServerOptions
+	connections []*Connection
+	connLock    sync.Mutex
+	// online holds the user name of every joined connection, presence the last presence of every user in a room.
+	// A user is online in a room as long as any of its connections there is, the same name may be used by several.
+	online       map[*Connection]string
+	presence     map[roomUser]chat.Presence
+	presenceLock sync.Mutex
+}
+
+// roomUser is a user in a room.
+type roomUser struct {
+	room string
+	user string
+}
+
+func newChatServer(opts ChatServerOptions) *ChatServer {
+	srv := &ChatServer{
+		broadcast: make(chan *chat.ChatMessage, opts.BroadcastBuffer),
+		quit:      make(chan struct{}),
+		opts:      opts,
+		online:    make(map[*Connection]string),
+		presence:  make(map[roomUser]chat.Presence),
+	}
+	go srv.start()
+	return srv
+}
+
+func (c *ChatServer) Close() error {
+	close(c.quit)
+	return nil
+}
+
+func (c *ChatServer) start() {
+	running := true
+	for running {
+		select {
+		case msg := <-c.broadcast:
+			if c.opts.History != nil && msg.Event == chat.EventType_EVENT_TYPE_UNSPECIFIED {
+				err := c.opts.History.Append(msg)
+				if err != nil {
+					slog.Warn("storing message in history failed", "error", err)
+				}
+			}
+			c.connLock.Lock()
+			for _, v := range c.connections {
+				if v.room == msg.Room {
+					go v.Send(msg)
+				}
+			}
+			c.connLock.Unlock()
+		case <-c.quit:
+			running = false
+		}
+	}
+}
+
+// Presence returns the last known presence of user in room.
+func (c *ChatServer) Presence(room, user string) chat.Presence {
+	c.presenceLock.Lock()
+	defer c.presenceLock.Unlock()
+	return c.presenceOf(roomUser{room: room, user: user})
+}
+
+// presenceOf returns the presence of the user in the room, online while any of its connections there is.
+// Requires presenceLock.
+func (c *ChatServer) presenceOf(member roomUser) chat.Presence {
+	for conn, u := range c.online {
+		if u == member.user && conn.room == member.room {
+			return chat.Presence_PRESENCE_ONLINE
+		}
+	}
+	return c.presence[member]
+}
+
+// setPresence records the presence of a connection of user and announces it to everyone in the room of the
+// connection when it changes the presence of user there, e.g. not when another connection of the same name
+// is still online.
+func (c *ChatServer) setPresence(conn *Connection, user string, presence chat.Presence) {
+	if user == "" {
+		return
+	}
+	member := roomUser{room: conn.room, user: user}
+	c.presenceLock.Lock()
+	before := c.presenceOf(member)
+	if presence == chat.Presence_PRESENCE_ONLINE {
+		c.online[conn] = user
+	} else {
+		delete(c.online, conn)
+	}
+	c.presence[member] = presence
+	presence = c.presenceOf(member)
+	c.presenceLock.Unlock()
+	if presence == before {
+		return
+	}
+
+	msg := &chat.ChatMessage{User: user, Room: conn.room, Event: chat.EventType_EVENT_TYPE_PRESENCE, Presence: presence}
+	select {
+	case c.broadcast <- msg:
+	case <-c.quit:
+	}
+}
+
+// Chat joins the stream to the room named by its x-chat-room metadata, the unnamed room when there is none.
+// The header sent tells the client how often to send heartbeats.
+func (c *ChatServer) Chat(stream chat.ChatService_ChatServer) error {
+	var room string
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if values := md.Get(roomMetadataKey); len(values) > 0 {
+		room = values[0]
+	}
+	if err := validateRoom(room); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the interval is only known to the server, clients learn it before anything else is sent
+	header := metadata.Pairs(heartbeatMetadataKey, c.opts.Idle.ClientHeartbeatInterval().String())
+	if err := stream.SendHeader(header); err != nil {
+		return err
+	}
+	return c.serve(stream, room)
+}
+
+// validateRoom rejects room names longer than messages can carry.
+func validateRoom(room string) error {
+	if len(room) > maxRoomLength {
+		return fmt.Errorf("room must be at most %d bytes long", maxRoomLength)
+	}
+	return nil
+}
+
+// serve registers the stream with the broadcast of room and blocks until the peer disconnects or is evicted for being idle.
+func (c *ChatServer) serve(stream MessageStream, room string) error {
+	conn := NewConnection(stream, room, c.opts)
+
+	if c.opts.History != nil {
+		history, err := c.opts.History.Recent()
+		if err != nil {
+			slog.Warn("reading history failed", "error", err)
+		}
+		for _, msg := range history {
+			if msg.Room == room {
+				conn.Send(msg)
+			}
+		}
+	}
+
+	c.connLock.Lock()
+	c.connections = append(c.connections, conn)
+	c.connLock.Unlock()
+
+	received := make(chan error, 1)
+	go func() {
+		// the user is known with its first message, connects are logged then.
+		received <- conn.GetMessages(c.broadcast, func(user string) {
+			slog.Info("user connected", "user", user, "room", room)
+			c.setPresence(conn, user, chat.Presence_PRESENCE_ONLINE)
+		})
+	}()
+
+	var err error
+	presence := chat.Presence_PRESENCE_OFFLINE
+	select {
+	case err = <-received:
+	case <-conn.Idle():
+		// returning ends the stream, which unblocks the pending receive.
+		conn.Close()
+		err = errIdleTimeout
+		presence = chat.Presence_PRESENCE_IDLE
+	}
+
+	// remove itself from list of connections when disconnecting
+	c.connLock.Lock()
+	for i, v := range c.connections {
+		if v == conn {
+			c.connections = append(c.connections[:i], c.connections[i+1:]...)
+		}
+	}
+	c.connLock.Unlock()
+	if presence == chat.Presence_PRESENCE_IDLE {
+		slog.Info("user evicted after being idle", "user", conn.User(), "room", room)
+	} else {
+		slog.Info("user disconnected", "user", conn.User(), "room", room)
+	}
+	c.setPresence(conn, conn.User(), presence)
+
+	return err
+}
+
+func main() {
+	cfg, err := LoadConfig(os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		panic(fmt.Errorf("invalid configuration: %w", err))
+	}
+	logger, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+	slog.Debug("effective configuration", "config", fmt.Sprintf("%+v", cfg.redacted()))
+
+	history, err := newHistoryStore(cfg.History)
+	if err != nil {
+		panic(err)
+	}
+	if history != nil {
+		defer history.Close()
+	}
+
+	lst, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		panic(err)
+	}
+
+	streamInterceptors := []grpc.StreamServerInterceptor{logging.StreamServerInterceptor(logger)}
+	opts := cfg.Keepalive.ServerOptions()
+	if cfg.TLS.CertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	var tokens *auth.StaticTokens
+	if cfg.Auth.Mode == authModeToken {
+		tokens, err = newTokenAuthenticator(cfg.Auth.Token)
+		if err != nil {
+			panic(err)
+		}
+		streamInterceptors = append(streamInterceptors, tokenStreamInterceptor(tokens))
+	}
+	streamInterceptors = append(streamInterceptors, validate.StreamServerInterceptor())
+	opts = append(opts, grpc.ChainStreamInterceptor(streamInterceptors...))
+	server := grpc.NewServer(opts...)
+	chatServer := newChatServer(ChatServerOptions{
+		Idle:            cfg.Idle,
+		SendBuffer:      cfg.Buffers.Send,
+		BroadcastBuffer: cfg.Buffers.Broadcast,
+		RateLimit:       rate.Limit(cfg.RateLimit.MessagesPerSecond),
+		RateBurst:       cfg.RateLimit.Burst,
+		History:         history,
+	})
+	chat.RegisterChatServiceServer(server, chatServer)
+	reflection.Register(server)
+
+	// the chat service is NOT_SERVING while the history store fails
+	healthServer := health.NewServer(chat.ChatService_ServiceDesc.ServiceName)
+	healthServer.Register(server)
+	if history != nil {
+		healthServer.AddDependency("history", history.Check)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go healthServer.Run(ctx, cfg.Health.CheckInterval)
+
+	var wsServer *http.Server
+	if cfg.WebSocketAddr != "" {
+		handler := chatServer.WebSocketHandler(cfg.WebSocketOrigins)
+		if cfg.Auth.Mode == authModeToken {
+			handler = requireToken(tokens, handler)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(wsPath, handler)
+		wsServer = &http.Server{Addr: cfg.WebSocketAddr, Handler: mux}
+		go func() {
+			slog.Info("serving chat websocket bridge", "addr", cfg.WebSocketAddr, "path", wsPath)
+			var err error
+			if cfg.TLS.CertFile != "" {
+				err = wsServer.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			} else {
+				err = wsServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				panic(err)
+			}
+		}()
+	}
+
+	// on SIGINT or SIGTERM report NOT_SERVING, then stop accepting streams and close the bridge
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		healthServer.GracefulStop(server, cfg.Shutdown.Drain, cfg.Shutdown.Timeout)
+		if wsServer != nil {
+			// hijacked WebSocket connections are not waited for, they end with the process
+			_ = wsServer.Shutdown(context.Background())
+		}
+		close(stopped)
+	}()
+
+	slog.Info("serving chat server", "addr", cfg.ListenAddr)
+	err = server.Serve(lst)
+	if err != nil {
+		panic(err)
+	}
+	<-stopped
+}
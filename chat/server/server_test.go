@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/pgbytes/grpc-playground/health"
+	"github.com/pgbytes/grpc-playground/validate"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const recvTimeout = 5 * time.Second
+
+type ChatTestSuite struct {
+	suite.Suite
+	chatServer *ChatServer
+	grpcServer *grpc.Server
+	httpServer *httptest.Server
+	conn       *grpc.ClientConn
+}
+
+// startChatServer serves a chat server with the given options on a random local port.
+func startChatServer(t *testing.T, opts ChatServerOptions, serverOpts ...grpc.ServerOption) (*ChatServer, *grpc.Server, net.Addr) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	chatServer := newChatServer(opts)
+	grpcServer := grpc.NewServer(serverOpts...)
+	chat.RegisterChatServiceServer(grpcServer, chatServer)
+	health.NewServer(chat.ChatService_ServiceDesc.ServiceName).Register(grpcServer)
+	go func() {
+		_ = grpcServer.Serve(lst)
+	}()
+	t.Logf("serving chat server at %s", lst.Addr())
+	return chatServer, grpcServer, lst.Addr()
+}
+
+func (c *ChatTestSuite) SetupSuite() {
+	t := c.T()
+	var addr net.Addr
+	c.chatServer, c.grpcServer, addr = startChatServer(t, ChatServerOptions{})
+
+	c.httpServer = httptest.NewServer(c.chatServer.WebSocketHandler(nil))
+	t.Logf("serving chat websocket bridge at %s", c.httpServer.URL)
+
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	c.conn = conn
+}
+
+func (c *ChatTestSuite) TearDownSuite() {
+	c.T().Log("stopping chat servers...")
+	c.httpServer.Close()
+	c.grpcServer.Stop()
+	_ = c.chatServer.Close()
+	err := c.conn.Close()
+	if err != nil {
+		c.T().Logf("error shutting down chat client: %+v", err)
+	}
+}
+
+func TestChatTestSuite(t *testing.T) {
+	suite.Run(t, new(ChatTestSuite))
+}
+
+func (c *ChatTestSuite) dialWebSocket() *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(c.httpServer.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(c.T(), err)
+	return ws
+}
+
+// recvGRPC reads from the stream until the wanted message arrives, broadcast order is not guaranteed.
+func recvGRPC(t *testing.T, stream chat.ChatService_ChatClient, want *chat.ChatMessage) {
+	received := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				received <- err
+				return
+			}
+			if msg.User == want.User && msg.Message == want.Message {
+				received <- nil
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-received:
+		require.NoError(t, err)
+	case <-time.After(recvTimeout):
+		require.Failf(t, "timeout", "grpc client did not receive %v", want)
+	}
+}
+
+// recvWebSocket reads JSON frames until the wanted message arrives.
+func recvWebSocket(t *testing.T, ws *websocket.Conn, want *chat.ChatMessage) {
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(recvTimeout)))
+	for {
+		_, frame, err := ws.ReadMessage()
+		require.NoError(t, err)
+		msg := &chat.ChatMessage{}
+		require.NoError(t, protojson.Unmarshal(frame, msg))
+		if msg.User == want.User && msg.Message == want.Message {
+			return
+		}
+	}
+}
+
+func (c *ChatTestSuite) TestChatServer_WebSocketAndGRPCShareBroadcast() {
+	t := c.T()
+	ws := c.dialWebSocket()
+	defer ws.Close()
+
+	// every client receives its own messages, use that to know it has joined the broadcast.
+	wsHello := &chat.ChatMessage{User: "browser", Message: "browser joined"}
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"user":"browser","message":"browser joined"}`)))
+	recvWebSocket(t, ws, wsHello)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := chat.NewChatServiceClient(c.conn).Chat(ctx)
+	require.NoError(t, err)
+	grpcHello := &chat.ChatMessage{User: "terminal", Message: "terminal joined"}
+	require.NoError(t, stream.Send(grpcHello))
+	recvGRPC(t, stream, grpcHello)
+	recvWebSocket(t, ws, grpcHello)
+
+	t.Log("sending from websocket to grpc")
+	fromBrowser := &chat.ChatMessage{User: "browser", Message: "hello from the browser"}
+	frame, err := protojson.Marshal(fromBrowser)
+	require.NoError(t, err)
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, frame))
+	recvGRPC(t, stream, fromBrowser)
+
+	t.Log("sending from grpc to websocket")
+	fromTerminal := &chat.ChatMessage{User: "terminal", Message: "hello from the terminal"}
+	require.NoError(t, stream.Send(fromTerminal))
+	recvWebSocket(t, ws, fromTerminal)
+
+	require.NoError(t, stream.CloseSend())
+}
+
+func (c *ChatTestSuite) TestChatServer_WebSocketInvalidFrame() {
+	t := c.T()
+	ws := c.dialWebSocket()
+	defer ws.Close()
+
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte("not json")))
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(recvTimeout)))
+	// skip presence announcements of earlier tests until the server closes the connection.
+	var err error
+	for err == nil {
+		_, _, err = ws.ReadMessage()
+	}
+	require.True(t, websocket.IsCloseError(err, websocket.CloseUnsupportedData), "expected close frame, got: %v", err)
+}
+
+func TestChatServer_WebSocketOrigin(t *testing.T) {
+	chatServer := newChatServer(ChatServerOptions{})
+	defer chatServer.Close()
+	httpServer := httptest.NewServer(chatServer.WebSocketHandler([]string{"https://chat.example.com"}))
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	testCases := []struct {
+		description string
+		origin      string
+		refused     bool
+	}{
+		{description: "golden case: no origin, not a browser"},
+		{description: "golden case: same origin", origin: httpServer.URL},
+		{description: "golden case: allowed origin", origin: "https://CHAT.example.com"},
+		{description: "failure case: other site", origin: "https://evil.example.com", refused: true},
+		{description: "failure case: allowed host with other scheme", origin: "http://chat.example.com", refused: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			header := http.Header{}
+			if tc.origin != "" {
+				header.Set("Origin", tc.origin)
+			}
+			ws, resp, err := websocket.DefaultDialer.Dial(url, header)
+			if tc.refused {
+				require.ErrorIs(t, err, websocket.ErrBadHandshake)
+				require.Equal(t, http.StatusForbidden, resp.StatusCode)
+				return
+			}
+			require.NoError(t, err)
+			_ = ws.Close()
+		})
+	}
+}
+
+// recvRejected reads from the stream until the rejection of an invalid message arrives, returning its reason.
+func recvRejected(t *testing.T, recv func() (*chat.ChatMessage, error)) string {
+	for {
+		msg, err := recv()
+		require.NoError(t, err)
+		if msg.Event == chat.EventType_EVENT_TYPE_REJECTED {
+			return msg.Message
+		}
+	}
+}
+
+func TestChatServer_Validation(t *testing.T) {
+	chatServer, grpcServer, addr := startChatServer(t, ChatServerOptions{}, grpc.StreamInterceptor(validate.StreamServerInterceptor()))
+	defer chatServer.Close()
+	defer grpcServer.Stop()
+	httpServer := httptest.NewServer(chatServer.WebSocketHandler(nil))
+	defer httpServer.Close()
+
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), recvTimeout)
+	defer cancel()
+	stream, err := chat.NewChatServiceClient(conn).Chat(ctx)
+	require.NoError(t, err)
+
+	t.Log("testing: invalid grpc messages are rejected to the sender, the stream stays open")
+	require.NoError(t, stream.Send(&chat.ChatMessage{Message: "who am i"}))
+	require.Contains(t, recvRejected(t, stream.Recv), "user: is required")
+	valid := &chat.ChatMessage{User: "terminal", Message: "still here"}
+	require.NoError(t, stream.Send(valid))
+	recvGRPC(t, stream, valid)
+
+	t.Log("testing: invalid websocket messages are rejected to the sender, the connection stays open")
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "0s", resp.Header.Get(heartbeatMetadataKey), "heartbeats are not needed without idle timeout")
+	defer ws.Close()
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(recvTimeout)))
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"user":"browser","event":"EVENT_TYPE_PRESENCE","presence":42}`)))
+	reason := recvRejected(t, func() (*chat.ChatMessage, error) {
+		_, frame, err := ws.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		msg := &chat.ChatMessage{}
+		return msg, protojson.Unmarshal(frame, msg)
+	})
+	require.Contains(t, reason, "presence:")
+	fromBrowser := &chat.ChatMessage{User: "browser", Message: "still here too"}
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"user":"browser","message":"still here too"}`)))
+	recvWebSocket(t, ws, fromBrowser)
+	recvGRPC(t, stream, fromBrowser)
+}
+
+func TestChatServer_IdleEviction(t *testing.T) {
+	chatServer, grpcServer, addr := startChatServer(t, ChatServerOptions{
+		Idle: IdlePolicy{
+			HeartbeatInterval: 20 * time.Millisecond,
+			IdleTimeout:       100 * time.Millisecond,
+		},
+	})
+	defer chatServer.Close()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := chat.NewChatServiceClient(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the lively client answers every heartbeat of the server with one of its own.
+	lively, err := client.Chat(ctx)
+	require.NoError(t, err)
+	header, err := lively.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{"50ms"}, header.Get(heartbeatMetadataKey), "clients are told to send heartbeats at half the idle timeout")
+	require.NoError(t, lively.Send(&chat.ChatMessage{User: "lively", Message: "hi"}))
+	quietIdle := make(chan struct{})
+	go func() {
+		for {
+			msg, err := lively.Recv()
+			if err != nil {
+				return
+			}
+			switch {
+			case msg.Event == chat.EventType_EVENT_TYPE_HEARTBEAT:
+				_ = lively.Send(&chat.ChatMessage{User: "lively", Event: chat.EventType_EVENT_TYPE_HEARTBEAT})
+			case msg.Event == chat.EventType_EVENT_TYPE_PRESENCE && msg.User == "quiet" && msg.Presence == chat.Presence_PRESENCE_IDLE:
+				close(quietIdle)
+			}
+		}
+	}()
+
+	// the quiet client joins and then stops talking, like a client whose connection stalled.
+	quiet, err := client.Chat(ctx)
+	require.NoError(t, err)
+	require.NoError(t, quiet.Send(&chat.ChatMessage{User: "quiet", Message: "hi"}))
+	heartbeats := 0
+	for {
+		msg, err := quiet.Recv()
+		if err != nil {
+			require.Equal(t, codes.Unavailable, status.Code(err), "unexpected error: %v", err)
+			break
+		}
+		if msg.Event == chat.EventType_EVENT_TYPE_HEARTBEAT {
+			heartbeats++
+		}
+	}
+	require.Greater(t, heartbeats, 0, "quiet client should have received heartbeats before eviction")
+
+	select {
+	case <-quietIdle:
+	case <-time.After(recvTimeout):
+		require.Fail(t, "lively client was not told that the quiet client is idle")
+	}
+	require.Equal(t, chat.Presence_PRESENCE_IDLE, chatServer.Presence("", "quiet"))
+	require.Equal(t, chat.Presence_PRESENCE_ONLINE, chatServer.Presence("", "lively"))
+}
+
+func TestChatServer_PresenceOfSharedName(t *testing.T) {
+	chatServer, grpcServer, addr := startChatServer(t, ChatServerOptions{})
+	defer chatServer.Close()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := chat.NewChatServiceClient(conn)
+	online := func() int {
+		chatServer.presenceLock.Lock()
+		defer chatServer.presenceLock.Unlock()
+		return len(chatServer.online)
+	}
+
+	t.Log("testing: two connections join with the same name")
+	firstCtx, leaveFirst := context.WithCancel(context.Background())
+	defer leaveFirst()
+	first, err := client.Chat(firstCtx)
+	require.NoError(t, err)
+	require.NoError(t, first.Send(&chat.ChatMessage{User: "twin", Message: "hi from the laptop"}))
+	secondCtx, leaveSecond := context.WithCancel(context.Background())
+	defer leaveSecond()
+	second, err := client.Chat(secondCtx)
+	require.NoError(t, err)
+	require.NoError(t, second.Send(&chat.ChatMessage{User: "twin", Message: "hi from the phone"}))
+	require.Eventually(t, func() bool { return online() == 2 }, recvTimeout, 10*time.Millisecond)
+	require.Equal(t, chat.Presence_PRESENCE_ONLINE, chatServer.Presence("", "twin"))
+
+	t.Log("testing: one of them leaving keeps the user online")
+	leaveFirst()
+	require.Eventually(t, func() bool { return online() == 1 }, recvTimeout, 10*time.Millisecond)
+	require.Equal(t, chat.Presence_PRESENCE_ONLINE, chatServer.Presence("", "twin"))
+
+	t.Log("testing: the last of them leaving takes the user offline")
+	leaveSecond()
+	require.Eventually(t, func() bool { return online() == 0 }, recvTimeout, 10*time.Millisecond)
+	require.Equal(t, chat.Presence_PRESENCE_OFFLINE, chatServer.Presence("", "twin"))
+}
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of a logger.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.String()
+}
+
+func TestChatServer_ConnectLog(t *testing.T) {
+	logs := &syncBuffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	chatServer, grpcServer, addr := startChatServer(t, ChatServerOptions{})
+	defer chatServer.Close()
+	defer grpcServer.Stop()
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), recvTimeout)
+	defer cancel()
+	stream, err := chat.NewChatServiceClient(conn).Chat(ctx)
+	require.NoError(t, err)
+
+	hello := &chat.ChatMessage{User: "alice", Message: "hi"}
+	require.NoError(t, stream.Send(hello))
+	recvGRPC(t, stream, hello)
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"msg":"user connected","user":"alice"`)
+	}, recvTimeout, 10*time.Millisecond, "connect log without user: %s", logs)
+}
+
+// recvInRoom reads from the stream until the wanted message arrives, failing on messages of other rooms.
+func recvInRoom(t *testing.T, stream chat.ChatService_ChatClient, room string, want *chat.ChatMessage) {
+	for {
+		msg, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, room, msg.Room, "received %v of another room", msg)
+		if msg.User == want.User && msg.Message == want.Message {
+			return
+		}
+	}
+}
+
+func TestChatServer_Rooms(t *testing.T) {
+	chatServer, grpcServer, addr := startChatServer(t, ChatServerOptions{History: newMemoryHistory(10)})
+	defer chatServer.Close()
+	defer grpcServer.Stop()
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := chat.NewChatServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), recvTimeout)
+	defer cancel()
+	join := func(room string) chat.ChatService_ChatClient {
+		stream, err := client.Chat(metadata.AppendToOutgoingContext(ctx, roomMetadataKey, room))
+		require.NoError(t, err)
+		return stream
+	}
+
+	t.Log("testing: messages are relayed within their room only")
+	kitchen := join("kitchen")
+	fromKitchen := &chat.ChatMessage{User: "bob", Message: "dinner is ready", Room: "garden"}
+	require.NoError(t, kitchen.Send(fromKitchen))
+	recvInRoom(t, kitchen, "kitchen", fromKitchen)
+	garden := join("garden")
+	fromGarden := &chat.ChatMessage{User: "alice", Message: "the roses bloom"}
+	require.NoError(t, garden.Send(fromGarden))
+	recvInRoom(t, garden, "garden", fromGarden)
+	require.NoError(t, kitchen.Send(&chat.ChatMessage{User: "bob", Message: "anyone?"}))
+	recvInRoom(t, kitchen, "kitchen", &chat.ChatMessage{User: "bob", Message: "anyone?"})
+	fromGarden = &chat.ChatMessage{User: "alice", Message: "still here"}
+	require.NoError(t, garden.Send(fromGarden))
+	recvInRoom(t, garden, "garden", fromGarden)
+
+	t.Log("testing: presence is tracked per room")
+	require.Equal(t, chat.Presence_PRESENCE_ONLINE, chatServer.Presence("garden", "alice"))
+	require.Equal(t, chat.Presence_PRESENCE_UNSPECIFIED, chatServer.Presence("kitchen", "alice"))
+
+	t.Log("testing: history is replayed in its room only")
+	late := join("kitchen")
+	hello := &chat.ChatMessage{User: "carol", Message: "what's for dinner?"}
+	require.NoError(t, late.Send(hello))
+	recvInRoom(t, late, "kitchen", &chat.ChatMessage{User: "bob", Message: "dinner is ready"})
+	recvInRoom(t, late, "kitchen", hello)
+
+	t.Log("testing: room names longer than messages carry are rejected")
+	stream := join(strings.Repeat("r", maxRoomLength+1))
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestChatServer_HistoryReplay(t *testing.T) {
+	chatServer, grpcServer, addr := startChatServer(t, ChatServerOptions{History: newMemoryHistory(2)})
+	defer chatServer.Close()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := chat.NewChatServiceClient(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first, err := client.Chat(ctx)
+	require.NoError(t, err)
+	for _, text := range []string{"one", "two", "three"} {
+		msg := &chat.ChatMessage{User: "first", Message: text}
+		require.NoError(t, first.Send(msg))
+		recvGRPC(t, first, msg)
+	}
+
+	t.Log("joining user gets the two most recent messages replayed")
+	second, err := client.Chat(ctx)
+	require.NoError(t, err)
+	recvGRPC(t, second, &chat.ChatMessage{User: "first", Message: "two"})
+	recvGRPC(t, second, &chat.ChatMessage{User: "first", Message: "three"})
+}
+
+func TestChatServer_TokenAuth(t *testing.T) {
+	tokens, err := newTokenAuthenticator("chat-secret")
+	require.NoError(t, err)
+	chatServer, grpcServer, addr := startChatServer(t, ChatServerOptions{}, grpc.StreamInterceptor(tokenStreamInterceptor(tokens)))
+	defer chatServer.Close()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := chat.NewChatServiceClient(conn)
+
+	testCases := []struct {
+		description  string
+		token        string
+		expectedCode codes.Code
+	}{
+		{description: "golden case: valid token", token: "chat-secret", expectedCode: codes.OK},
+		{description: "failure case: invalid token", token: "guessed", expectedCode: codes.Unauthenticated},
+		{description: "failure case: missing token", token: "", expectedCode: codes.Unauthenticated},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.token != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tc.token)
+			}
+			stream, err := client.Chat(ctx)
+			require.NoError(t, err)
+			msg := &chat.ChatMessage{User: "auth", Message: tc.description}
+			require.NoError(t, stream.Send(msg))
+			if tc.expectedCode == codes.OK {
+				recvGRPC(t, stream, msg)
+				return
+			}
+			_, err = stream.Recv()
+			require.Equal(t, tc.expectedCode, status.Code(err))
+		})
+	}
+
+	t.Log("testing: health checks are exempt from the token")
+	ctx, cancel := context.WithTimeout(context.Background(), recvTimeout)
+	defer cancel()
+	watch, err := grpc_health_v1.NewHealthClient(conn).Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: chat.ChatService_ServiceDesc.ServiceName})
+	require.NoError(t, err)
+	resp, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestChatServer_WebSocketTokenAuth(t *testing.T) {
+	chatServer := newChatServer(ChatServerOptions{})
+	defer chatServer.Close()
+	tokens, err := newTokenAuthenticator("chat-secret")
+	require.NoError(t, err)
+	var requestURI string
+	wsHandler := chatServer.WebSocketHandler(nil)
+	httpServer := httptest.NewServer(requireToken(tokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.RequestURI
+		wsHandler.ServeHTTP(w, r)
+	})))
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	testCases := []struct {
+		description    string
+		query          string
+		authorization  string
+		origin         string
+		expectedStatus int
+	}{
+		{description: "golden case: token in the header", authorization: "Bearer chat-secret", expectedStatus: http.StatusSwitchingProtocols},
+		{description: "golden case: browser passes the token in the query", query: "?room=lobby&token=chat-secret", origin: httpServer.URL, expectedStatus: http.StatusSwitchingProtocols},
+		{description: "failure case: invalid token in the header", authorization: "Bearer guessed", expectedStatus: http.StatusUnauthorized},
+		{description: "failure case: invalid token in the query", query: "?token=guessed", origin: httpServer.URL, expectedStatus: http.StatusUnauthorized},
+		{description: "failure case: missing token", origin: httpServer.URL, expectedStatus: http.StatusUnauthorized},
+		{description: "failure case: token in the query without browser", query: "?token=chat-secret", expectedStatus: http.StatusBadRequest},
+		{description: "failure case: token in the query and the header", query: "?token=chat-secret", authorization: "Bearer chat-secret", origin: httpServer.URL, expectedStatus: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			requestURI = ""
+			header := http.Header{}
+			if tc.authorization != "" {
+				header.Set("Authorization", tc.authorization)
+			}
+			if tc.origin != "" {
+				header.Set("Origin", tc.origin)
+			}
+			ws, resp, err := websocket.DefaultDialer.Dial(url+tc.query, header)
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus != http.StatusSwitchingProtocols {
+				require.ErrorIs(t, err, websocket.ErrBadHandshake)
+				return
+			}
+			require.NoError(t, err)
+			_ = ws.Close()
+			require.NotContains(t, requestURI, "chat-secret", "the token is removed before the request is handled")
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/pgbytes/grpc-playground/validate"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	wsPath         = "/chat"
+	wsCloseTimeout = time.Second
+)
+
+// checkOrigin accepts WebSocket handshakes without Origin header, i.e. not sent by browsers, those from the
+// origin of the bridge itself and those from one of the allowed origins. Others are refused, so pages of
+// other sites cannot open connections with the cookies or credentials of the user's browser.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, a := range allowed {
+			if strings.EqualFold(strings.TrimSuffix(a, "/"), u.Scheme+"://"+u.Host) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// wsStream bridges a WebSocket connection to the MessageStream used by the chat broadcast.
+// Every text frame carries one chat.ChatMessage encoded as proto JSON, e.g. {"user":"alice","message":"hi"}.
+type wsStream struct {
+	conn *websocket.Conn
+}
+
+func (w *wsStream) Send(msg *chat.ChatMessage) error {
+	frame, err := protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w.conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+func (w *wsStream) Recv() (*chat.ChatMessage, error) {
+	_, frame, err := w.conn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+	msg := &chat.ChatMessage{}
+	err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(frame, msg)
+	if err != nil {
+		w.close(websocket.CloseUnsupportedData, "invalid chat message")
+		return nil, fmt.Errorf("decoding websocket frame: %w", err)
+	}
+	// websocket messages bypass the gRPC interceptors, they are validated here. Unlike undecodable frames,
+	// invalid messages keep the connection open, GetMessages rejects them back to the peer.
+	if err := validate.Validate(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// close sends a close frame with the given code and reason, ignoring peers that are already gone.
+func (w *wsStream) close(code int, reason string) {
+	deadline := time.Now().Add(wsCloseTimeout)
+	_ = w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+}
+
+// WebSocketHandler upgrades HTTP requests to WebSocket connections and joins them
+// to the same broadcast as the gRPC ChatService streams, in the room of the room query parameter.
+// Browsers may connect from the bridge's own origin and from allowedOrigins, e.g. https://chat.example.com.
+func (c *ChatServer) WebSocketHandler(allowedOrigins []string) http.Handler {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     checkOrigin(allowedOrigins),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		room := r.URL.Query().Get("room")
+		if err := validateRoom(room); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		header := http.Header{}
+		header.Set(heartbeatMetadataKey, c.opts.Idle.ClientHeartbeatInterval().String())
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			// the upgrader already replied with an HTTP error
+			slog.Warn("websocket upgrade failed", "peer", r.RemoteAddr, "error", err)
+			return
+		}
+		defer conn.Close()
+
+		stream := &wsStream{conn: conn}
+		err = c.serve(stream, room)
+		if err != nil {
+			slog.Warn("websocket connection closed with error", "peer", r.RemoteAddr, "error", err)
+			return
+		}
+		stream.close(websocket.CloseNormalClosure, "")
+	})
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/oauth"
 	"github.com/pgbytes/grpc-playground/testdata"
+	"github.com/pgbytes/grpc-playground/tlsconfig"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 type tokenAuth struct {
@@ -26,29 +34,48 @@ func (t tokenAuth) RequireTransportSecurity() bool {
 }
 
 func main() {
+	addr := flag.String("addr", "localhost:8080", "address of the echo server")
+	caFile := flag.String("ca", testdata.Path("ca.pem"), "CA file the server certificate is verified against")
+	serverName := flag.String("server-name", "echo.test.youtube.com", "name the server certificate is verified for")
+	certFile := flag.String("cert", "", "client certificate file presented for mutual TLS")
+	keyFile := flag.String("key", "", "private key file of the client certificate")
+	token := flag.String("token", "some-super-secret", "bearer token sent with every call, none when empty")
+	tokenURL := flag.String("token-url", "", "OAuth2 token endpoint access tokens are fetched from with the client credentials grant, replaces -token when set")
+	clientID := flag.String("client-id", "echo-client", "OAuth2 client ID sent to the token endpoint")
+	clientSecret := flag.String("client-secret", "echo-client-secret", "OAuth2 client secret sent to the token endpoint")
+	scopes := flag.String("scopes", "echo", "space separated scopes requested from the token endpoint")
+	flag.Parse()
 	ctx := context.Background()
 
-	// setup transport credentials for TLS
-	transportCreds, err := credentials.NewClientTLSFromFile(testdata.Path("ca.pem"), "echo.test.youtube.com")
+	// setup transport credentials for TLS, presenting the client certificate when given
+	tlsCfg, err := tlsconfig.Client(*caFile, *serverName, *certFile, *keyFile)
 	if err != nil {
 		panic(err)
 	}
 
-	// setup per request credentials
-	perRPCCreds := tokenAuth{token: "some-super-secret"}
-
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(transportCreds),
-		grpc.WithPerRPCCredentials(perRPCCreds),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg)),
 		// grpc.WithBlock(), // block the caller until connection is established
 	}
+	// setup per request credentials, access tokens of the token endpoint take precedence over the static token
+	if *tokenURL != "" {
+		creds := oauth.NewClientCredentials(oauth.Config{
+			TokenURL:     *tokenURL,
+			ClientID:     *clientID,
+			ClientSecret: *clientSecret,
+			Scopes:       strings.Fields(*scopes),
+		})
+		opts = append(opts, creds.DialOptions()...)
+	} else if *token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenAuth{token: *token}))
+	}
 
-	conn, err := grpc.Dial("localhost:8080", opts...)
+	conn, err := grpc.Dial(*addr, opts...)
 	if err != nil {
 		panic(err)
 	}
 	defer conn.Close()
-	fmt.Println("connection established to echo server at localhost:8080")
+	fmt.Printf("connection established to echo server at %s\n", *addr)
 
 	ec := echo.NewEchoServiceClient(conn)
 	resp, err := ec.Echo(ctx, &echo.EchoRequest{
@@ -58,4 +85,93 @@ func main() {
 		panic(err)
 	}
 	fmt.Printf("Got response from server: %s \n", resp.Response)
+
+	partialEcho(ctx, ec)
+	serverStreamEcho(ctx, ec)
+	clientStreamEcho(ctx, ec)
+	bidiStreamEcho(ctx, ec)
+}
+
+// partialEcho asks the server for the talk of the echo only, the other fields of the response stay empty.
+func partialEcho(ctx context.Context, ec echo.EchoServiceClient) {
+	resp, err := ec.PartialEcho(ctx, &echo.PartialEchoRequest{
+		Request:  &echo.EchoRequest{Message: "Hello partially!", Talk: &echo.Message{Text: "only this"}},
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"talk.text"}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Got partial response from server: %q %q \n", resp.Response, resp.GetTalk().GetText())
+}
+
+// serverStreamEcho asks the server to echo a message three times, half a second apart.
+func serverStreamEcho(ctx context.Context, ec echo.EchoServiceClient) {
+	stream, err := ec.ServerStreamEcho(ctx, &echo.ServerStreamEchoRequest{
+		Request:  &echo.EchoRequest{Message: "Hello again!"},
+		Count:    3,
+		Interval: durationpb.New(500 * time.Millisecond),
+	})
+	if err != nil {
+		panic(err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Got streamed response from server: %s \n", resp.Response)
+	}
+}
+
+// clientStreamEcho sends several messages and receives them concatenated in one response.
+func clientStreamEcho(ctx context.Context, ec echo.EchoServiceClient) {
+	stream, err := ec.ClientStreamEcho(ctx)
+	if err != nil {
+		panic(err)
+	}
+	for _, msg := range []string{"Hello", "from", "the", "client", "stream!"} {
+		err := stream.Send(&echo.EchoRequest{Message: msg})
+		if err != nil {
+			panic(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Got response for %d messages from server: %s \n", resp.MessageCount, resp.Response)
+}
+
+// bidiStreamEcho sends messages while receiving their echoes concurrently.
+func bidiStreamEcho(ctx context.Context, ec echo.EchoServiceClient) {
+	stream, err := ec.BidiStreamEcho(ctx)
+	if err != nil {
+		panic(err)
+	}
+	waitC := make(chan struct{})
+	go func() {
+		defer close(waitC)
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Got bidi response from server: %s \n", resp.Response)
+		}
+	}()
+	for _, msg := range []string{"ping", "ping", "ping"} {
+		err := stream.Send(&echo.EchoRequest{Message: msg})
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		panic(err)
+	}
+	<-waitC
 }
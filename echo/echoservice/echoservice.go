@@ -0,0 +1,146 @@
+// Package echoservice holds the request handling shared by the echo servers, so the example servers
+// answer the same requests the same way whatever interceptors they install.
+package echoservice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/reflection"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxStreamEchoCount bounds the responses of a single ServerStreamEcho call.
+	MaxStreamEchoCount = 1000
+	// MaxStreamEchoInterval bounds the interval between the responses of a ServerStreamEcho call,
+	// together with the count it bounds how long a call keeps its stream open.
+	MaxStreamEchoInterval = time.Minute
+)
+
+// ValidateServerStreamEcho checks the count and interval of a ServerStreamEcho request.
+func ValidateServerStreamEcho(req *echo.ServerStreamEchoRequest) error {
+	var violations []rpcerror.FieldViolation
+	if req.GetCount() < 1 || req.GetCount() > MaxStreamEchoCount {
+		violations = append(violations, rpcerror.FieldViolation{
+			Field:       "count",
+			Description: fmt.Sprintf("must be between 1 and %d, got %d", MaxStreamEchoCount, req.GetCount()),
+		})
+	}
+	if req.GetInterval() != nil {
+		if err := req.GetInterval().CheckValid(); err != nil {
+			violations = append(violations, rpcerror.FieldViolation{Field: "interval", Description: err.Error()})
+		} else if interval := req.GetInterval().AsDuration(); interval < 0 || interval > MaxStreamEchoInterval {
+			violations = append(violations, rpcerror.FieldViolation{
+				Field:       "interval",
+				Description: fmt.Sprintf("must be between 0s and %s, got %s", MaxStreamEchoInterval, interval),
+			})
+		}
+	}
+	if len(violations) > 0 {
+		return &rpcerror.Validation{Violations: violations}
+	}
+	return nil
+}
+
+// EchoFunc answers a single EchoRequest, e.g. the Echo method of an echo server.
+type EchoFunc func(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error)
+
+// PartialEcho answers req with the response of echoFn, pruned to the fields of the read mask.
+// Requests without request or with an invalid read mask are rejected before echoFn is called,
+// servers without the validate interceptor get them as well.
+func PartialEcho(ctx context.Context, req *echo.PartialEchoRequest, echoFn EchoFunc) (*echo.EchoResponse, error) {
+	var violations []rpcerror.FieldViolation
+	if req.GetRequest() == nil {
+		violations = append(violations, rpcerror.FieldViolation{Field: "request", Description: "is required"})
+	}
+	if err := reflection.ValidateFieldMask((&echo.EchoResponse{}).ProtoReflect().Descriptor(), req.GetReadMask()); err != nil {
+		violations = append(violations, rpcerror.FieldViolation{Field: "read_mask", Description: err.Error()})
+	}
+	if len(violations) > 0 {
+		return nil, &rpcerror.Validation{Violations: violations}
+	}
+	resp, err := echoFn(ctx, req.GetRequest())
+	if err != nil {
+		return nil, err
+	}
+	if err := reflection.PruneFieldMask(resp, req.GetReadMask()); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ServerStreamEcho validates req, then sends the response of echoFn to its request count times, waiting
+// the interval between responses. Waiting ends early when the call is cancelled or its deadline exceeded.
+func ServerStreamEcho(req *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer, echoFn EchoFunc) error {
+	if err := ValidateServerStreamEcho(req); err != nil {
+		return err
+	}
+	interval := req.GetInterval().AsDuration()
+	for i := int32(0); i < req.GetCount(); i++ {
+		if i > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-stream.Context().Done():
+				return status.FromContextError(stream.Context().Err()).Err()
+			}
+		}
+		resp, err := echoFn(stream.Context(), req.GetRequest())
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClientStreamEcho answers all requests of the stream with one response joining their messages.
+// check is called with every request when set, its error ends the call.
+func ClientStreamEcho(stream echo.EchoService_ClientStreamEchoServer, check func(*echo.EchoRequest) error) error {
+	var messages []string
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(req); err != nil {
+				return err
+			}
+		}
+		messages = append(messages, req.GetMessage())
+	}
+	return stream.SendAndClose(&echo.EchoResponse{
+		Response:     "My Echo: " + strings.Join(messages, " "),
+		MessageCount: int32(len(messages)),
+	})
+}
+
+// BidiStreamEcho answers every request of the stream with the response of echoFn as it arrives,
+// until the client closes the stream or echoFn fails.
+func BidiStreamEcho(stream echo.EchoService_BidiStreamEchoServer, echoFn EchoFunc) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		resp, err := echoFn(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pgbytes/grpc-playground/auth"
+	"github.com/pgbytes/grpc-playground/testdata"
+)
+
+const (
+	authModeStatic = "static"
+	authModeHMAC   = "hmac"
+	authModeJWKS   = "jwks"
+	authModeMTLS   = "mtls"
+)
+
+// authConfig selects and configures the authenticator of the echo server.
+type authConfig struct {
+	mode          string
+	tokensFile    string
+	jwtSecretFile string
+	jwksFile      string
+	jwtIssuer     string
+	jwtAudience   string
+}
+
+func (a *authConfig) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.mode, "auth-mode", authModeStatic, "how callers authenticate: static, hmac, jwks or mtls")
+	fs.StringVar(&a.tokensFile, "auth-tokens", testdata.Path("tokens.yaml"), "YAML file of the bearer tokens accepted with auth-mode static")
+	fs.StringVar(&a.jwtSecretFile, "jwt-secret-file", "", "file holding the shared secret of HS256/384/512 JWTs with auth-mode hmac")
+	fs.StringVar(&a.jwksFile, "jwks", "", "JWKS file of the RSA and ECDSA keys JWTs are verified with in auth-mode jwks")
+	fs.StringVar(&a.jwtIssuer, "jwt-issuer", "", "required iss claim of JWTs, not checked when empty")
+	fs.StringVar(&a.jwtAudience, "jwt-audience", "", "required aud claim of JWTs, not checked when empty")
+}
+
+// newAuthenticator creates the authenticator selected by the mode.
+func (a *authConfig) newAuthenticator() (auth.Authenticator, error) {
+	opts := auth.JWTOptions{Issuer: a.jwtIssuer, Audience: a.jwtAudience}
+	switch a.mode {
+	case authModeStatic:
+		return auth.LoadStaticTokens(a.tokensFile)
+	case authModeHMAC:
+		secret, err := os.ReadFile(a.jwtSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT secret: %w", err)
+		}
+		return auth.NewHMACAuthenticator([]byte(strings.TrimSpace(string(secret))), opts)
+	case authModeJWKS:
+		keys, err := auth.LoadJWKS(a.jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewJWKSAuthenticator(keys, opts), nil
+	case authModeMTLS:
+		return auth.NewClientCertAuthenticator(), nil
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q, must be one of: %s, %s, %s, %s", a.mode, authModeStatic, authModeHMAC, authModeJWKS, authModeMTLS)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/auth"
+	"github.com/pgbytes/grpc-playground/authz"
+	"github.com/pgbytes/grpc-playground/echo/echoservice"
+	"github.com/pgbytes/grpc-playground/fault"
+	"github.com/pgbytes/grpc-playground/health"
+	"github.com/pgbytes/grpc-playground/logging"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"github.com/pgbytes/grpc-playground/testdata"
+	"github.com/pgbytes/grpc-playground/tlsconfig"
+	"github.com/pgbytes/grpc-playground/validate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpcreflection "google.golang.org/grpc/reflection"
+)
+
+type EchoServer struct{}
+
+func (e *EchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	if p, ok := auth.FromContext(ctx); ok {
+		slog.DebugContext(ctx, "echo requested", "subject", p.Subject, "scopes", p.Scopes)
+	}
+	return &echo.EchoResponse{
+		// the talk is returned as it is, never formatted into the response: its text is sensitive and the
+		// response is not, so the payload logs would show it in clear text.
+		Response:     "My Echo: " + req.GetMessage(),
+		MessageCount: 1,
+		Talk:         req.GetTalk(),
+	}, nil
+}
+
+// PartialEcho echoes the request like Echo, pruned to the fields of the read mask.
+func (e *EchoServer) PartialEcho(ctx context.Context, req *echo.PartialEchoRequest) (*echo.EchoResponse, error) {
+	return echoservice.PartialEcho(ctx, req, e.Echo)
+}
+
+// interceptorOptions installs the interceptors of the echo server on unary and streaming RPCs alike.
+// A nil engine disables authorization, a nil injector disables fault injection.
+func interceptorOptions(logger *slog.Logger, authenticator auth.Authenticator, engine *authz.Engine, injector *fault.Injector) []grpc.ServerOption {
+	// log every request, then check auth. The logging interceptor comes first to see the identity set by auth,
+	// and the status the typed errors of the handlers are converted to by rpcerror.
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor(logger), rpcerror.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(logging.StreamServerInterceptor(logger), rpcerror.StreamServerInterceptor()),
+	}
+	authOpts := []auth.Option{auth.WithExemptMethods(auth.HealthService, auth.ReflectionService, auth.ReflectionAlphaService)}
+	if engine != nil {
+		// methods the policy makes public need no credentials.
+		authOpts = append(authOpts, auth.WithExemptFunc(engine.IsPublic))
+	}
+	opts = append(opts, auth.ServerOptions(authenticator, authOpts...)...)
+	if engine != nil {
+		opts = append(opts, engine.ServerOptions()...)
+	}
+	// requests are validated once the caller is known to be allowed to make them.
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(validate.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(validate.StreamServerInterceptor()),
+	)
+	if injector != nil {
+		// faults are injected after auth, so only authenticated callers can request them.
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(injector.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(injector.StreamServerInterceptor()),
+		)
+	}
+	return opts
+}
+
+func main() {
+	logFormat := flag.String("log-format", logging.FormatText, "format of the logs: text or json")
+	logLevel := flag.String("log-level", "info", "minimum level of logged diagnostics: debug, info, warn or error")
+	var authCfg authConfig
+	authCfg.bindFlags(flag.CommandLine)
+	tlsCert := flag.String("tls-cert", testdata.Path("server1.pem"), "TLS certificate file")
+	tlsKey := flag.String("tls-key", testdata.Path("server1.key"), "TLS private key file")
+	clientCA := flag.String("client-ca", "", "CA file client certificates must be signed by, enables mutual TLS when set")
+	policyFile := flag.String("authz-policy", "", "YAML policy authorizing callers per method, reloaded when changed, disabled when empty")
+	policyReload := flag.Duration("authz-reload-interval", 5*time.Second, "interval the policy file is checked for changes at")
+	tlsReload := flag.Duration("tls-reload-interval", 30*time.Second, "interval the TLS certificate, key and client CA files are checked for changes at")
+	shutdownDrain := flag.Duration("shutdown-drain", 5*time.Second, "time NOT_SERVING is reported on shutdown before the server stops accepting calls")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "time pending calls may take to finish on shutdown before they are cancelled")
+	faultInjection := flag.Bool("fault-injection", false, "inject the faults requested by the x-fault-* metadata of authenticated calls, for testing clients only")
+	flag.Parse()
+
+	logger, err := logging.New(os.Stdout, *logFormat, *logLevel)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
+	if authCfg.mode == authModeMTLS && *clientCA == "" {
+		panic("auth mode mtls requires -client-ca")
+	}
+	authenticator, err := authCfg.newAuthenticator()
+	if err != nil {
+		panic(err)
+	}
+
+	lst, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		panic(err)
+	}
+
+	// create TLS creds, verifying client certificates when a client CA is configured.
+	// The files are reloaded when changed, so certificates can be rotated without restart.
+	certs, err := tlsconfig.NewReloader(*tlsCert, *tlsKey, *clientCA)
+	if err != nil {
+		panic(err)
+	}
+	go certs.Watch(context.Background(), *tlsReload)
+	var engine *authz.Engine
+	if *policyFile != "" {
+		policy, err := authz.LoadPolicy(*policyFile)
+		if err != nil {
+			panic(err)
+		}
+		engine = authz.NewEngine(policy)
+		go engine.WatchFile(context.Background(), *policyFile, *policyReload)
+	}
+	var injector *fault.Injector
+	if *faultInjection {
+		injector = fault.NewInjector(time.Now().UnixNano())
+		slog.Warn("fault injection enabled")
+	}
+	opts := append([]grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(certs.ServerConfig("h2"))), // enable TLS check.
+	}, interceptorOptions(logger, authenticator, engine, injector)...)
+	server := grpc.NewServer(opts...)
+
+	echoServer := &EchoServer{}
+	echo.RegisterEchoServiceServer(server, echoServer)
+	healthServer := health.NewServer(echo.EchoService_ServiceDesc.ServiceName)
+	healthServer.Register(server)
+	grpcreflection.Register(server)
+
+	// on SIGINT or SIGTERM report NOT_SERVING, then let pending calls finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		healthServer.GracefulStop(server, *shutdownDrain, *shutdownTimeout)
+		close(stopped)
+	}()
+
+	slog.Info("serving echo server", "addr", lst.Addr().String())
+	err = server.Serve(lst)
+	if err != nil {
+		panic(err)
+	}
+	<-stopped
+}
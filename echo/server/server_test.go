@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/auth"
+	"github.com/pgbytes/grpc-playground/echo/echoservice"
+	"github.com/pgbytes/grpc-playground/health"
+	"github.com/pgbytes/grpc-playground/logging"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// testToken authenticates the test client over plaintext connections.
+type testToken string
+
+func (t testToken) GetRequestMetadata(ctx context.Context, in ...string) (map[string]string, error) {
+	return map[string]string{auth.AuthorizationKey: "Bearer " + string(t)}, nil
+}
+
+func (t testToken) RequireTransportSecurity() bool {
+	return false
+}
+
+type EchoStreamTestSuite struct {
+	suite.Suite
+	echoServer *grpc.Server
+	addr       string
+	conn       *grpc.ClientConn
+	client     echo.EchoServiceClient
+}
+
+func (e *EchoStreamTestSuite) SetupSuite() {
+	t := e.T()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tokens, err := auth.NewStaticTokens([]auth.StaticToken{{Token: "test-token", Subject: "echo-test"}})
+	require.NoError(t, err)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := grpc.NewServer(interceptorOptions(logger, tokens, nil, nil)...)
+	echo.RegisterEchoServiceServer(server, &EchoServer{})
+	health.NewServer(echo.EchoService_ServiceDesc.ServiceName).Register(server)
+	t.Logf("serving echo server at %s", lst.Addr())
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	e.echoServer = server
+	e.addr = lst.Addr().String()
+
+	conn, err := grpc.Dial(e.addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithPerRPCCredentials(testToken("test-token")))
+	require.NoError(t, err)
+	e.conn = conn
+	e.client = echo.NewEchoServiceClient(conn)
+}
+
+func (e *EchoStreamTestSuite) TearDownSuite() {
+	e.T().Log("stopping echo server...")
+	e.echoServer.Stop()
+	err := e.conn.Close()
+	if err != nil {
+		e.T().Logf("error shutting down echo client: %+v", err)
+	}
+}
+
+func TestEchoStreamTestSuite(t *testing.T) {
+	suite.Run(t, new(EchoStreamTestSuite))
+}
+
+func (e *EchoStreamTestSuite) TestEchoServer_ServerStreamEcho() {
+	t := e.T()
+	start := time.Now()
+	stream, err := e.client.ServerStreamEcho(context.Background(), &echo.ServerStreamEchoRequest{
+		Request:  &echo.EchoRequest{Message: "again"},
+		Count:    3,
+		Interval: durationpb.New(20 * time.Millisecond),
+	})
+	require.NoError(t, err)
+	var responses []*echo.EchoResponse
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		responses = append(responses, resp)
+	}
+	require.Len(t, responses, 3)
+	for _, resp := range responses {
+		assert.Contains(t, resp.Response, "again")
+		assert.Equal(t, int32(1), resp.MessageCount)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond, "responses should be sent at the requested interval")
+}
+
+func (e *EchoStreamTestSuite) TestEchoServer_ServerStreamEchoInvalid() {
+	t := e.T()
+	testCases := []struct {
+		description string
+		request     *echo.ServerStreamEchoRequest
+		fields      []string
+	}{
+		{description: "zero count", request: &echo.ServerStreamEchoRequest{Count: 0}, fields: []string{"count"}},
+		{description: "count above limit", request: &echo.ServerStreamEchoRequest{Count: echoservice.MaxStreamEchoCount + 1}, fields: []string{"count"}},
+		{description: "negative interval", request: &echo.ServerStreamEchoRequest{Count: 1, Interval: durationpb.New(-time.Second)}, fields: []string{"interval"}},
+		{description: "interval above limit", request: &echo.ServerStreamEchoRequest{Count: 1, Interval: durationpb.New(echoservice.MaxStreamEchoInterval + time.Second)}, fields: []string{"interval"}},
+		{description: "zero count and negative interval", request: &echo.ServerStreamEchoRequest{Interval: durationpb.New(-time.Second)}, fields: []string{"count", "interval"}},
+		{description: "request without message", request: &echo.ServerStreamEchoRequest{Request: &echo.EchoRequest{}, Count: 1}, fields: []string{"request.message"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			stream, err := e.client.ServerStreamEcho(context.Background(), tc.request)
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			var validation *rpcerror.Validation
+			require.True(t, errors.As(rpcerror.FromError(err), &validation))
+			var fields []string
+			for _, violation := range validation.Violations {
+				fields = append(fields, violation.Field)
+			}
+			assert.Equal(t, tc.fields, fields)
+		})
+	}
+}
+
+func (e *EchoStreamTestSuite) TestEchoServer_ServerStreamEchoDeadline() {
+	t := e.T()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stream, err := e.client.ServerStreamEcho(ctx, &echo.ServerStreamEchoRequest{
+		Request:  &echo.EchoRequest{Message: "slow"},
+		Count:    10,
+		Interval: durationpb.New(time.Second),
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err, "first response is sent right away")
+	_, err = stream.Recv()
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func (e *EchoStreamTestSuite) TestEchoServer_ClientStreamEcho() {
+	t := e.T()
+	stream, err := e.client.ClientStreamEcho(context.Background())
+	require.NoError(t, err)
+	for _, msg := range []string{"one", "two", "three"} {
+		require.NoError(t, stream.Send(&echo.EchoRequest{Message: msg}))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, "My Echo: one two three", resp.Response)
+	assert.Equal(t, int32(3), resp.MessageCount)
+}
+
+func (e *EchoStreamTestSuite) TestEchoServer_BidiStreamEcho() {
+	t := e.T()
+	stream, err := e.client.BidiStreamEcho(context.Background())
+	require.NoError(t, err)
+	// every request is answered before the next one is sent
+	for _, msg := range []string{"ping", "pong"} {
+		require.NoError(t, stream.Send(&echo.EchoRequest{Message: msg}))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Contains(t, resp.Response, msg)
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func (e *EchoStreamTestSuite) TestEchoServer_PartialEcho() {
+	t := e.T()
+	request := &echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: "talk"}}
+	testCases := []struct {
+		description string
+		request     *echo.PartialEchoRequest
+		expected    *echo.EchoResponse
+		fields      []string
+	}{
+		{
+			description: "golden case: no read mask returns the full response",
+			request:     &echo.PartialEchoRequest{Request: request},
+			expected:    &echo.EchoResponse{Response: "My Echo: hello", MessageCount: 1, Talk: &echo.Message{Text: "talk"}},
+		},
+		{
+			description: "golden case: masked fields only",
+			request:     &echo.PartialEchoRequest{Request: request, ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"message_count", "talk.text"}}},
+			expected:    &echo.EchoResponse{MessageCount: 1, Talk: &echo.Message{Text: "talk"}},
+		},
+		{
+			description: "failure case: unknown field in read mask",
+			request:     &echo.PartialEchoRequest{Request: request, ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"talk.unknown"}}},
+			fields:      []string{"read_mask"},
+		},
+		{
+			description: "failure case: missing request",
+			request:     &echo.PartialEchoRequest{ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"response"}}},
+			fields:      []string{"request"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			resp, err := e.client.PartialEcho(context.Background(), tc.request)
+			if tc.fields == nil {
+				require.NoError(t, err)
+				assert.True(t, proto.Equal(tc.expected, resp), "expected %v, got %v", tc.expected, resp)
+				return
+			}
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			var validation *rpcerror.Validation
+			require.True(t, errors.As(rpcerror.FromError(err), &validation))
+			var fields []string
+			for _, violation := range validation.Violations {
+				fields = append(fields, violation.Field)
+			}
+			assert.Equal(t, tc.fields, fields)
+		})
+	}
+}
+
+// syncBuffer collects the logs written by the server go routines.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.String()
+}
+
+func TestEchoServer_PayloadLogsRedactTalk(t *testing.T) {
+	const secret = "the talk is a secret"
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tokens, err := auth.NewStaticTokens([]auth.StaticToken{{Token: "test-token", Subject: "echo-test"}})
+	require.NoError(t, err)
+	out := &syncBuffer{}
+	logger, err := logging.New(out, logging.FormatJSON, "debug")
+	require.NoError(t, err)
+	server := grpc.NewServer(interceptorOptions(logger, tokens, nil, nil)...)
+	echo.RegisterEchoServiceServer(server, &EchoServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithPerRPCCredentials(testToken("test-token")))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	client := echo.NewEchoServiceClient(conn)
+	request := &echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: secret}}
+
+	resp, err := client.Echo(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, secret, resp.GetTalk().GetText(), "the talk is echoed to the caller")
+	_, err = client.PartialEcho(context.Background(), &echo.PartialEchoRequest{Request: request})
+	require.NoError(t, err)
+	stream, err := client.BidiStreamEcho(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(request))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	logs := out.String()
+	assert.Contains(t, logs, "sent response", "payloads are logged at debug level")
+	assert.Contains(t, logs, "sent message", "payloads are logged at debug level")
+	assert.NotContains(t, logs, secret)
+}
+
+func (e *EchoStreamTestSuite) TestEchoServer_RequiresCredentials() {
+	t := e.T()
+	conn, err := grpc.Dial(e.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := echo.NewEchoServiceClient(conn)
+	ctx := context.Background()
+
+	t.Log("testing: unary calls without credentials are rejected")
+	_, err = client.Echo(ctx, &echo.EchoRequest{Message: "hello"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: server streams without credentials are rejected before the first response")
+	serverStream, err := client.ServerStreamEcho(ctx, &echo.ServerStreamEchoRequest{Request: &echo.EchoRequest{Message: "hello"}, Count: 1})
+	require.NoError(t, err)
+	_, err = serverStream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: client streams without credentials are rejected")
+	clientStream, err := client.ClientStreamEcho(ctx)
+	require.NoError(t, err)
+	_ = clientStream.Send(&echo.EchoRequest{Message: "hello"})
+	_, err = clientStream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: bidi streams without credentials are rejected")
+	bidiStream, err := client.BidiStreamEcho(ctx)
+	require.NoError(t, err)
+	_ = bidiStream.Send(&echo.EchoRequest{Message: "hello"})
+	_, err = bidiStream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: streams with invalid credentials are rejected")
+	invalid := metadata.AppendToOutgoingContext(ctx, auth.AuthorizationKey, "Bearer wrong-token")
+	bidiStream, err = client.BidiStreamEcho(invalid)
+	require.NoError(t, err)
+	_, err = bidiStream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: health checks are exempt from authentication")
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+	assert.NoError(t, health.Probe(ctx, conn, echo.EchoService_ServiceDesc.ServiceName))
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/echo/echoservice"
+)
+
+func (e *EchoServer) ServerStreamEcho(req *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer) error {
+	return echoservice.ServerStreamEcho(req, stream, e.Echo)
+}
+
+func (e *EchoServer) ClientStreamEcho(stream echo.EchoService_ClientStreamEchoServer) error {
+	return echoservice.ClientStreamEcho(stream, nil)
+}
+
+func (e *EchoServer) BidiStreamEcho(stream echo.EchoService_BidiStreamEchoServer) error {
+	return echoservice.BidiStreamEcho(stream, e.Echo)
+}
@@ -2,14 +2,25 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"flag"
+	"log/slog"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/echo/echoservice"
+	"github.com/pgbytes/grpc-playground/health"
+	"github.com/pgbytes/grpc-playground/logging"
+	"github.com/pgbytes/grpc-playground/rpcerror"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	grpcreflection "google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
 )
 
 var (
@@ -18,6 +29,9 @@ var (
 	errDefault          = status.Error(codes.Unimplemented, "error type handler not implemented")
 )
 
+// errorDomain identifies the service producing ErrorInfo and PreconditionFailure details.
+const errorDomain = "echo.grpc-playground.pgbytes.github.com"
+
 type EchoServer struct{}
 
 func (e *EchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
@@ -25,10 +39,37 @@ func (e *EchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.Ech
 		return nil, generateErrorDetail(req)
 	}
 	return &echo.EchoResponse{
-		Response: fmt.Sprintf("My Echo: %s: %+v", req.Message, req.Talk),
+		Response:     "My Echo: " + req.GetMessage(),
+		MessageCount: 1,
+		Talk:         req.GetTalk(),
 	}, nil
 }
 
+// PartialEcho echoes the request like Echo, pruned to the fields of the read mask.
+func (e *EchoServer) PartialEcho(ctx context.Context, req *echo.PartialEchoRequest) (*echo.EchoResponse, error) {
+	return echoservice.PartialEcho(ctx, req, e.Echo)
+}
+
+// ServerStreamEcho fails before sending anything when the request asks for an error.
+func (e *EchoServer) ServerStreamEcho(req *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer) error {
+	return echoservice.ServerStreamEcho(req, stream, e.Echo)
+}
+
+// ClientStreamEcho fails as soon as one of the requests asks for an error.
+func (e *EchoServer) ClientStreamEcho(stream echo.EchoService_ClientStreamEchoServer) error {
+	return echoservice.ClientStreamEcho(stream, func(req *echo.EchoRequest) error {
+		if req.GetErrorType() != echo.ErrorType_ERROR_TYPE_UNSPECIFIED {
+			return generateErrorDetail(req)
+		}
+		return nil
+	})
+}
+
+// BidiStreamEcho echoes every request until one asks for an error, which ends the stream mid-way.
+func (e *EchoServer) BidiStreamEcho(stream echo.EchoService_BidiStreamEchoServer) error {
+	return echoservice.BidiStreamEcho(stream, e.Echo)
+}
+
 func generateErrorDetail(req *echo.EchoRequest) error {
 	switch req.GetErrorType() {
 	case echo.ErrorType_ERROR_TYPE_UNAUTHENTICATED:
@@ -37,34 +78,153 @@ func generateErrorDetail(req *echo.EchoRequest) error {
 		return errPermissionDenied
 	case echo.ErrorType_ERROR_TYPE_BAD_REQUEST:
 		return badRequestError()
+	case echo.ErrorType_ERROR_TYPE_ERROR_INFO:
+		return errorInfoError()
+	case echo.ErrorType_ERROR_TYPE_RETRY_INFO:
+		return retryInfoError()
+	case echo.ErrorType_ERROR_TYPE_DEBUG_INFO:
+		return debugInfoError()
+	case echo.ErrorType_ERROR_TYPE_QUOTA_FAILURE:
+		return quotaFailureError()
+	case echo.ErrorType_ERROR_TYPE_PRECONDITION_FAILURE:
+		return preconditionFailureError()
+	case echo.ErrorType_ERROR_TYPE_RESOURCE_INFO:
+		return resourceInfoError()
+	case echo.ErrorType_ERROR_TYPE_HELP:
+		return helpError()
+	case echo.ErrorType_ERROR_TYPE_LOCALIZED_MESSAGE:
+		return localizedMessageError()
 	default:
 		return errDefault
 	}
 }
 
-func badRequestError() error {
-	st := status.New(codes.InvalidArgument, "bad request")
-	st, err := st.WithDetails(&errdetails.BadRequest_FieldViolation{Field: "email", Description: "invalid format"})
+// withDetails builds a status error carrying details the rpcerror package has no typed error for,
+// details that cannot be marshalled end up as an INTERNAL error.
+func withDetails(code codes.Code, msg string, details ...protoiface.MessageV1) error {
+	st, err := status.New(code, msg).WithDetails(details...)
 	if err != nil {
-		return err
+		return status.Errorf(codes.Internal, "attaching error details: %v", err)
 	}
 	return st.Err()
 }
 
+func badRequestError() error {
+	return &rpcerror.Validation{
+		Message: "bad request",
+		Violations: []rpcerror.FieldViolation{
+			{Field: "email", Description: "invalid format"},
+			{Field: "talk.text", Description: "must not be empty"},
+			{Field: "message", Description: "must be at most 256 characters long"},
+		},
+	}
+}
+
+func errorInfoError() error {
+	return &rpcerror.PermissionDenied{
+		Message:  "echo service disabled for the project",
+		Reason:   "SERVICE_DISABLED",
+		Domain:   errorDomain,
+		Metadata: map[string]string{"service": "echo.EchoService", "project": "playground"},
+	}
+}
+
+func retryInfoError() error {
+	return &rpcerror.Unavailable{Message: "echo backend is restarting", RetryDelay: 2 * time.Second}
+}
+
+func debugInfoError() error {
+	return withDetails(codes.Internal, "echo failed unexpectedly", &errdetails.DebugInfo{
+		StackEntries: []string{
+			"main.(*EchoServer).Echo(servererrors.go)",
+			"main.generateErrorDetail(servererrors.go)",
+		},
+		Detail: "simulated internal failure",
+	})
+}
+
+func quotaFailureError() error {
+	return &rpcerror.RateLimited{
+		Message:    "echo quota exceeded",
+		Violations: []rpcerror.QuotaViolation{{Subject: "project:playground", Description: "daily limit of 1000 echoes exceeded"}},
+	}
+}
+
+func preconditionFailureError() error {
+	return &rpcerror.FailedPrecondition{
+		Message:    "terms of service not accepted",
+		Violations: []rpcerror.PreconditionViolation{{Type: "TOS", Subject: errorDomain, Description: "terms of service not accepted"}},
+	}
+}
+
+func resourceInfoError() error {
+	return &rpcerror.NotFound{
+		Message:      "echo room not found",
+		ResourceType: "echo.Room",
+		ResourceName: "rooms/lobby",
+		Owner:        "project:playground",
+		Description:  "the room does not exist or has been deleted",
+	}
+}
+
+func helpError() error {
+	return withDetails(codes.Unimplemented, "echo feature not implemented yet", &errdetails.Help{
+		Links: []*errdetails.Help_Link{
+			{Description: "error model", Url: "https://cloud.google.com/apis/design/errors"},
+			{Description: "error details", Url: "https://github.com/googleapis/googleapis/blob/master/google/rpc/error_details.proto"},
+		},
+	})
+}
+
+func localizedMessageError() error {
+	return withDetails(codes.OutOfRange, "message count out of range", &errdetails.LocalizedMessage{
+		Locale:  "de-DE",
+		Message: "Die Anzahl der Nachrichten liegt außerhalb des gültigen Bereichs.",
+	})
+}
+
 func main() {
-	fmt.Println("welcome to grpc-playground for error details..!!")
+	logFormat := flag.String("log-format", logging.FormatText, "format of the logs: text or json")
+	logLevel := flag.String("log-level", "info", "minimum level of logged diagnostics: debug, info, warn or error")
+	shutdownDrain := flag.Duration("shutdown-drain", 5*time.Second, "time NOT_SERVING is reported on shutdown before the server stops accepting calls")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "time pending calls may take to finish on shutdown before they are cancelled")
+	flag.Parse()
+
+	logger, err := logging.New(os.Stdout, *logFormat, *logLevel)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
+	slog.Info("welcome to grpc-playground for error details..!!")
 	lst, err := net.Listen("tcp", ":8080")
 	if err != nil {
 		panic(err)
 	}
 
-	server := grpc.NewServer()
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor(logger), rpcerror.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(logging.StreamServerInterceptor(logger), rpcerror.StreamServerInterceptor()),
+	)
 	echoServer := &EchoServer{}
 	echo.RegisterEchoServiceServer(server, echoServer)
+	healthServer := health.NewServer(echo.EchoService_ServiceDesc.ServiceName)
+	healthServer.Register(server)
+	grpcreflection.Register(server)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		healthServer.GracefulStop(server, *shutdownDrain, *shutdownTimeout)
+		close(stopped)
+	}()
 
-	fmt.Println("Serving echo server at 8080")
+	slog.Info("serving echo server", "addr", lst.Addr().String())
 	err = server.Serve(lst)
 	if err != nil {
 		panic(err)
 	}
+	<-stopped
 }
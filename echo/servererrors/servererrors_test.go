@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/echo/echoservice"
+	"github.com/pgbytes/grpc-playground/reflection"
+	"github.com/pgbytes/grpc-playground/rpcerror"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -15,6 +20,9 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 type EchoTestSuite struct {
@@ -32,7 +40,10 @@ func (e *EchoTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	server := grpc.NewServer()
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(rpcerror.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(rpcerror.StreamServerInterceptor()),
+	)
 	echoServer := &EchoServer{}
 	echo.RegisterEchoServiceServer(server, echoServer)
 
@@ -126,8 +137,212 @@ func (e *EchoTestSuite) TestEchoServer_Echo() {
 	}
 }
 
+func (e *EchoTestSuite) TestEchoServer_Streams() {
+	t := e.T()
+	client := echo.NewEchoServiceClient(e.conn)
+	unauthenticated := &echo.EchoRequest{Message: "unauthenticated request", ErrorType: echo.ErrorType_ERROR_TYPE_UNAUTHENTICATED}
+
+	t.Log("testing: server stream fails before the first response")
+	serverStream, err := client.ServerStreamEcho(context.Background(), &echo.ServerStreamEchoRequest{Request: unauthenticated, Count: 3})
+	require.NoError(t, err)
+	_, err = serverStream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	t.Log("testing: server streams are bounded in count and interval")
+	serverStream, err = client.ServerStreamEcho(context.Background(), &echo.ServerStreamEchoRequest{
+		Request:  &echo.EchoRequest{Message: "forever"},
+		Count:    echoservice.MaxStreamEchoCount + 1,
+		Interval: durationpb.New(echoservice.MaxStreamEchoInterval + time.Second),
+	})
+	require.NoError(t, err)
+	_, err = serverStream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	var validation *rpcerror.Validation
+	require.True(t, errors.As(rpcerror.FromError(err), &validation))
+	require.Len(t, validation.Violations, 2)
+	assert.Equal(t, "count", validation.Violations[0].Field)
+	assert.Equal(t, "interval", validation.Violations[1].Field)
+
+	t.Log("testing: client stream fails on the first bad request")
+	clientStream, err := client.ClientStreamEcho(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, clientStream.Send(&echo.EchoRequest{Message: "fine"}))
+	require.NoError(t, clientStream.Send(&echo.EchoRequest{Message: "bad request", ErrorType: echo.ErrorType_ERROR_TYPE_BAD_REQUEST}))
+	_, err = clientStream.CloseAndRecv()
+	statusErr, _ := status.FromError(err)
+	expectedStatusErr, _ := status.FromError(badRequestError())
+	assertBadRequest(t, expectedStatusErr, statusErr)
+
+	t.Log("testing: bidi stream fails mid-stream")
+	bidiStream, err := client.BidiStreamEcho(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, bidiStream.Send(&echo.EchoRequest{Message: "fine"}))
+	resp, err := bidiStream.Recv()
+	require.NoError(t, err)
+	assert.Contains(t, resp.Response, "fine")
+	require.NoError(t, bidiStream.Send(&echo.EchoRequest{Message: "denied", ErrorType: echo.ErrorType_ERROR_TYPE_PERMISSION_DENIED}))
+	_, err = bidiStream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func (e *EchoTestSuite) TestEchoServer_PartialEcho() {
+	t := e.T()
+	client := echo.NewEchoServiceClient(e.conn)
+
+	t.Log("testing: the response is pruned to the read mask")
+	resp, err := client.PartialEcho(context.Background(), &echo.PartialEchoRequest{
+		Request:  &echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: "talk"}},
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"message_count"}},
+	})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(&echo.EchoResponse{MessageCount: 1}, resp), "expected only the message count, got %v", resp)
+
+	t.Log("testing: requests without request are rejected, this server has no validate interceptor")
+	_, err = client.PartialEcho(context.Background(), &echo.PartialEchoRequest{ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"unknown"}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	var validation *rpcerror.Validation
+	require.True(t, errors.As(rpcerror.FromError(err), &validation))
+	var fields []string
+	for _, violation := range validation.Violations {
+		fields = append(fields, violation.Field)
+	}
+	assert.Equal(t, []string{"request", "read_mask"}, fields)
+
+	t.Log("testing: the server is still serving")
+	_, err = client.PartialEcho(context.Background(), &echo.PartialEchoRequest{Request: &echo.EchoRequest{Message: "again"}})
+	assert.NoError(t, err)
+}
+
+func (e *EchoTestSuite) TestEchoServer_ErrorDetails() {
+	t := e.T()
+	client := echo.NewEchoServiceClient(e.conn)
+	testCases := []struct {
+		description  string
+		errorType    echo.ErrorType
+		code         codes.Code
+		assertDetail func(t *testing.T, detail interface{})
+	}{
+		{
+			description: "bad request: several field violations",
+			errorType:   echo.ErrorType_ERROR_TYPE_BAD_REQUEST,
+			code:        codes.InvalidArgument,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				badRequest, ok := detail.(*errdetails.BadRequest)
+				require.Truef(t, ok, "expected errdetails.BadRequest, got %T", detail)
+				require.Len(t, badRequest.GetFieldViolations(), 3)
+				assert.Equal(t, "email", badRequest.GetFieldViolations()[0].GetField())
+				assert.Equal(t, "talk.text", badRequest.GetFieldViolations()[1].GetField())
+				assert.Equal(t, "message", badRequest.GetFieldViolations()[2].GetField())
+			},
+		},
+		{
+			description: "error info: reason, domain and metadata",
+			errorType:   echo.ErrorType_ERROR_TYPE_ERROR_INFO,
+			code:        codes.PermissionDenied,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				info, ok := detail.(*errdetails.ErrorInfo)
+				require.Truef(t, ok, "expected errdetails.ErrorInfo, got %T", detail)
+				assert.Equal(t, "SERVICE_DISABLED", info.GetReason())
+				assert.Equal(t, errorDomain, info.GetDomain())
+				assert.Equal(t, "echo.EchoService", info.GetMetadata()["service"])
+			},
+		},
+		{
+			description: "retry info: retry delay",
+			errorType:   echo.ErrorType_ERROR_TYPE_RETRY_INFO,
+			code:        codes.Unavailable,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				retry, ok := detail.(*errdetails.RetryInfo)
+				require.Truef(t, ok, "expected errdetails.RetryInfo, got %T", detail)
+				assert.True(t, proto.Equal(durationpb.New(2*time.Second), retry.GetRetryDelay()))
+			},
+		},
+		{
+			description: "debug info: stack entries and detail",
+			errorType:   echo.ErrorType_ERROR_TYPE_DEBUG_INFO,
+			code:        codes.Internal,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				debug, ok := detail.(*errdetails.DebugInfo)
+				require.Truef(t, ok, "expected errdetails.DebugInfo, got %T", detail)
+				assert.Len(t, debug.GetStackEntries(), 2)
+				assert.Equal(t, "simulated internal failure", debug.GetDetail())
+			},
+		},
+		{
+			description: "quota failure: violated quota",
+			errorType:   echo.ErrorType_ERROR_TYPE_QUOTA_FAILURE,
+			code:        codes.ResourceExhausted,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				quota, ok := detail.(*errdetails.QuotaFailure)
+				require.Truef(t, ok, "expected errdetails.QuotaFailure, got %T", detail)
+				require.Len(t, quota.GetViolations(), 1)
+				assert.Equal(t, "project:playground", quota.GetViolations()[0].GetSubject())
+			},
+		},
+		{
+			description: "precondition failure: violated precondition",
+			errorType:   echo.ErrorType_ERROR_TYPE_PRECONDITION_FAILURE,
+			code:        codes.FailedPrecondition,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				precondition, ok := detail.(*errdetails.PreconditionFailure)
+				require.Truef(t, ok, "expected errdetails.PreconditionFailure, got %T", detail)
+				require.Len(t, precondition.GetViolations(), 1)
+				assert.Equal(t, "TOS", precondition.GetViolations()[0].GetType())
+				assert.Equal(t, errorDomain, precondition.GetViolations()[0].GetSubject())
+			},
+		},
+		{
+			description: "resource info: missing resource",
+			errorType:   echo.ErrorType_ERROR_TYPE_RESOURCE_INFO,
+			code:        codes.NotFound,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				resource, ok := detail.(*errdetails.ResourceInfo)
+				require.Truef(t, ok, "expected errdetails.ResourceInfo, got %T", detail)
+				assert.Equal(t, "echo.Room", resource.GetResourceType())
+				assert.Equal(t, "rooms/lobby", resource.GetResourceName())
+				assert.Equal(t, "project:playground", resource.GetOwner())
+			},
+		},
+		{
+			description: "help: documentation links",
+			errorType:   echo.ErrorType_ERROR_TYPE_HELP,
+			code:        codes.Unimplemented,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				help, ok := detail.(*errdetails.Help)
+				require.Truef(t, ok, "expected errdetails.Help, got %T", detail)
+				require.Len(t, help.GetLinks(), 2)
+				for _, link := range help.GetLinks() {
+					assert.Contains(t, link.GetUrl(), "https://")
+				}
+			},
+		},
+		{
+			description: "localized message: locale and message",
+			errorType:   echo.ErrorType_ERROR_TYPE_LOCALIZED_MESSAGE,
+			code:        codes.OutOfRange,
+			assertDetail: func(t *testing.T, detail interface{}) {
+				localized, ok := detail.(*errdetails.LocalizedMessage)
+				require.Truef(t, ok, "expected errdetails.LocalizedMessage, got %T", detail)
+				assert.Equal(t, "de-DE", localized.GetLocale())
+				assert.NotEmpty(t, localized.GetMessage())
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("testing: %s", tc.description)
+		_, err := client.Echo(context.Background(), &echo.EchoRequest{Message: tc.description, ErrorType: tc.errorType})
+		statusErr, isStatus := status.FromError(err)
+		require.Truef(t, isStatus, "response error should be from the status package if it is a grpc response")
+		assert.Equal(t, tc.code, statusErr.Code())
+		// every error type attaches exactly one detail, decoded back into its errdetails type by the status package
+		require.Len(t, statusErr.Details(), 1)
+		tc.assertDetail(t, statusErr.Details()[0])
+	}
+}
+
 func assertBadRequest(t *testing.T, expected, actual *status.Status) {
-	// we assume in base of status code INVALID_ARGUMENT, error details will always have the message type errdetails.BadRequest_FieldViolation
+	// we assume in base of status code INVALID_ARGUMENT, error details will always have the message type errdetails.BadRequest
+	// listing the individual field violations
 	// https://github.com/googleapis/googleapis/blob/master/google/rpc/error_details.proto#L169
 
 	// ensure error type
@@ -137,43 +352,48 @@ func assertBadRequest(t *testing.T, expected, actual *status.Status) {
 	// ensure error details type
 	t.Log("ensuring error details type by reflection")
 	for _, detail := range expected.Details() {
-		require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest_FieldViolation")
+		require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest")
 	}
 	for _, detail := range actual.Details() {
-		require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest_FieldViolation")
+		require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest")
 	}
 
 	// ensure type using type switch
 	t.Log("ensuring error details type by type switch")
 	for _, detail := range expected.Details() {
 		switch detail.(type) {
-		case *errdetails.BadRequest_FieldViolation:
+		case *errdetails.BadRequest:
 			// nothing to do test is fine, just for sake of completeness add a require statement
-			require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest_FieldViolation")
+			require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest")
 		default:
-			require.Failf(t, "wrong error detail type: %s, expected was: errdetails.BadRequest_FieldViolation", reflect.TypeOf(detail).Elem().String())
+			require.Failf(t, "wrong error detail type: %s, expected was: errdetails.BadRequest", reflect.TypeOf(detail).Elem().String())
 		}
 	}
 	for _, detail := range actual.Details() {
 		switch detail.(type) {
-		case *errdetails.BadRequest_FieldViolation:
+		case *errdetails.BadRequest:
 			// nothing to do test is fine, just for sake of completeness add a require statement
-			require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest_FieldViolation")
+			require.Equal(t, reflect.TypeOf(detail).Elem().String(), "errdetails.BadRequest")
 		default:
-			require.Failf(t, "wrong error detail type: %s, expected was: errdetails.BadRequest_FieldViolation", reflect.TypeOf(detail).Elem().String())
+			require.Failf(t, "wrong error detail type: %s, expected was: errdetails.BadRequest", reflect.TypeOf(detail).Elem().String())
 		}
 	}
 
 	// ensure error details type by type casting
 	t.Log("ensuring error details type by type casting")
 	for _, detail := range expected.Details() {
-		fv, ok := detail.(*errdetails.BadRequest_FieldViolation)
+		fv, ok := detail.(*errdetails.BadRequest)
 		require.True(t, ok)
 		require.NotNil(t, &fv)
 	}
 	for _, detail := range actual.Details() {
-		fv, ok := detail.(*errdetails.BadRequest_FieldViolation)
+		fv, ok := detail.(*errdetails.BadRequest)
 		require.True(t, ok)
 		require.NotNil(t, &fv)
 	}
+
+	// ensure all field violations made it to the client, a diff names the fields that did not
+	require.Len(t, actual.Details(), len(expected.Details()))
+	changes := reflection.Diff(expected.Proto(), actual.Proto())
+	assert.Empty(t, changes, "status differs from the expected one:\n%s", changes)
 }
@@ -0,0 +1,166 @@
+// Package fault injects latency and failures into RPCs as requested by the caller's metadata.
+// It is meant for testing client retries, timeouts and circuit breakers against a local server,
+// never enable it on a server reachable by untrusted callers.
+//
+// The faults are requested with these metadata keys:
+//
+//	x-fault-delay           delay before the handler runs, e.g. 250ms
+//	x-fault-error-code      status code to fail with, e.g. UNAVAILABLE or 14
+//	x-fault-error-percent   percentage of calls failing with the code, defaults to 100 when a code is set
+//	x-fault-abort-after     abort streams after sending this many messages, with x-fault-error-code or ABORTED,
+//	                        unary calls after their handler ran
+//	x-fault-response-bytes  pad every response to at least this many bytes, at most MaxResponseBytes
+//	x-fault-hang            block until the deadline of the call when true
+package fault
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DelayKey         = "x-fault-delay"
+	ErrorCodeKey     = "x-fault-error-code"
+	ErrorPercentKey  = "x-fault-error-percent"
+	AbortAfterKey    = "x-fault-abort-after"
+	ResponseBytesKey = "x-fault-response-bytes"
+	HangKey          = "x-fault-hang"
+)
+
+// MaxResponseBytes is the largest padding callers may request, the default maximum message size gRPC clients
+// receive. It keeps callers from making the server allocate arbitrarily large responses.
+const MaxResponseBytes = 4 * 1024 * 1024
+
+// Spec describes the faults to inject into a single call.
+type Spec struct {
+	Delay time.Duration
+	// ErrorCode fails ErrorPercent of the calls, codes.OK disables failing.
+	ErrorCode    codes.Code
+	ErrorPercent float64
+	// AbortAfter aborts streams after sending that many messages, 0 disables aborting.
+	// Unary calls are aborted instead of sending their response.
+	AbortAfter int
+	// ResponseBytes pads every response to at least that many bytes, 0 disables padding.
+	ResponseBytes int
+	// Hang blocks the call until its deadline is exceeded or it is canceled.
+	Hang bool
+}
+
+// IsZero reports whether the spec injects no fault at all.
+func (s Spec) IsZero() bool {
+	return s == Spec{}
+}
+
+// AppendToOutgoingContext requests the faults of the spec for calls made with the returned context.
+func (s Spec) AppendToOutgoingContext(ctx context.Context) context.Context {
+	var kv []string
+	if s.Delay > 0 {
+		kv = append(kv, DelayKey, s.Delay.String())
+	}
+	if s.ErrorCode != codes.OK {
+		kv = append(kv, ErrorCodeKey, strconv.Itoa(int(s.ErrorCode)))
+	}
+	if s.ErrorPercent > 0 {
+		kv = append(kv, ErrorPercentKey, strconv.FormatFloat(s.ErrorPercent, 'f', -1, 64))
+	}
+	if s.AbortAfter > 0 {
+		kv = append(kv, AbortAfterKey, strconv.Itoa(s.AbortAfter))
+	}
+	if s.ResponseBytes > 0 {
+		kv = append(kv, ResponseBytesKey, strconv.Itoa(s.ResponseBytes))
+	}
+	if s.Hang {
+		kv = append(kv, HangKey, "true")
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// FromIncomingContext parses the faults requested by the caller. Malformed values are reported as INVALID_ARGUMENT.
+func FromIncomingContext(ctx context.Context) (Spec, error) {
+	spec := Spec{}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return spec, nil
+	}
+	value := func(key string) (string, bool) {
+		values := md.Get(key)
+		if len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	}
+
+	var err error
+	if v, ok := value(DelayKey); ok {
+		spec.Delay, err = time.ParseDuration(v)
+		if err != nil || spec.Delay < 0 {
+			return Spec{}, invalid(DelayKey, v)
+		}
+	}
+	if v, ok := value(ErrorCodeKey); ok {
+		spec.ErrorCode, err = parseCode(v)
+		if err != nil {
+			return Spec{}, invalid(ErrorCodeKey, v)
+		}
+	}
+	if v, ok := value(ErrorPercentKey); ok {
+		spec.ErrorPercent, err = strconv.ParseFloat(v, 64)
+		if err != nil || spec.ErrorPercent < 0 || spec.ErrorPercent > 100 {
+			return Spec{}, invalid(ErrorPercentKey, v)
+		}
+	}
+	if v, ok := value(AbortAfterKey); ok {
+		spec.AbortAfter, err = strconv.Atoi(v)
+		if err != nil || spec.AbortAfter < 0 {
+			return Spec{}, invalid(AbortAfterKey, v)
+		}
+	}
+	if v, ok := value(ResponseBytesKey); ok {
+		spec.ResponseBytes, err = strconv.Atoi(v)
+		if err != nil || spec.ResponseBytes < 0 || spec.ResponseBytes > MaxResponseBytes {
+			return Spec{}, invalid(ResponseBytesKey, v)
+		}
+	}
+	if v, ok := value(HangKey); ok {
+		spec.Hang, err = strconv.ParseBool(v)
+		if err != nil {
+			return Spec{}, invalid(HangKey, v)
+		}
+	}
+
+	// a code without percentage fails every call, a percentage without code fails with UNAVAILABLE
+	_, percentGiven := value(ErrorPercentKey)
+	if spec.ErrorCode != codes.OK && !percentGiven {
+		spec.ErrorPercent = 100
+	}
+	if spec.ErrorCode == codes.OK && spec.ErrorPercent > 0 {
+		spec.ErrorCode = codes.Unavailable
+	}
+	return spec, nil
+}
+
+func invalid(key, value string) error {
+	return status.Errorf(codes.InvalidArgument, "invalid fault injection metadata %s: %q", key, value)
+}
+
+// parseCode accepts status codes by name, e.g. UNAVAILABLE, or by number.
+func parseCode(v string) (codes.Code, error) {
+	if n, err := strconv.ParseUint(v, 10, 32); err == nil {
+		if n > uint64(codes.Unauthenticated) {
+			return 0, fmt.Errorf("unknown status code %d", n)
+		}
+		return codes.Code(n), nil
+	}
+	var code codes.Code
+	err := code.UnmarshalJSON([]byte(strconv.Quote(v)))
+	return code, err
+}
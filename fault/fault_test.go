@@ -0,0 +1,243 @@
+package fault
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeEchoServer struct {
+	echo.UnimplementedEchoServiceServer
+	// sent is the last response returned by Echo
+	sent atomic.Pointer[echo.EchoResponse]
+}
+
+func (f *fakeEchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	if req.Message == "missing" {
+		return nil, status.Error(codes.NotFound, "nothing to echo")
+	}
+	resp := &echo.EchoResponse{Response: req.Message, MessageCount: 1}
+	f.sent.Store(resp)
+	return resp, nil
+}
+
+// BidiStreamEcho sends three responses, ignoring failed sends.
+func (f *fakeEchoServer) BidiStreamEcho(stream echo.EchoService_BidiStreamEchoServer) error {
+	for i := 0; i < 3; i++ {
+		_ = stream.Send(&echo.EchoResponse{Response: "ignored", MessageCount: 1})
+	}
+	return nil
+}
+
+func (f *fakeEchoServer) ServerStreamEcho(req *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer) error {
+	for i := int32(0); i < req.Count; i++ {
+		if err := stream.Send(&echo.EchoResponse{Response: req.GetRequest().GetMessage(), MessageCount: 1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type FaultTestSuite struct {
+	suite.Suite
+	echo   *fakeEchoServer
+	server *grpc.Server
+	conn   *grpc.ClientConn
+	client echo.EchoServiceClient
+}
+
+func (f *FaultTestSuite) SetupSuite() {
+	t := f.T()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	injector := NewInjector(42)
+	f.server = grpc.NewServer(
+		grpc.UnaryInterceptor(injector.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(injector.StreamServerInterceptor()),
+	)
+	f.echo = &fakeEchoServer{}
+	echo.RegisterEchoServiceServer(f.server, f.echo)
+	go func() {
+		_ = f.server.Serve(lst)
+	}()
+
+	f.conn, err = grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	f.client = echo.NewEchoServiceClient(f.conn)
+}
+
+func (f *FaultTestSuite) TearDownSuite() {
+	f.server.Stop()
+	_ = f.conn.Close()
+}
+
+func TestFaultTestSuite(t *testing.T) {
+	suite.Run(t, new(FaultTestSuite))
+}
+
+func (f *FaultTestSuite) TestNoFault() {
+	resp, err := f.client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	require.NoError(f.T(), err)
+	assert.Equal(f.T(), "hello", resp.Response)
+}
+
+func (f *FaultTestSuite) TestDelay() {
+	t := f.T()
+	ctx := Spec{Delay: 50 * time.Millisecond}.AppendToOutgoingContext(context.Background())
+	start := time.Now()
+	_, err := f.client.Echo(ctx, &echo.EchoRequest{Message: "slow"})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	t.Log("delay longer than the deadline")
+	ctx, cancel := context.WithTimeout(Spec{Delay: time.Minute}.AppendToOutgoingContext(context.Background()), 50*time.Millisecond)
+	defer cancel()
+	_, err = f.client.Echo(ctx, &echo.EchoRequest{Message: "too slow"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func (f *FaultTestSuite) TestHang() {
+	ctx, cancel := context.WithTimeout(Spec{Hang: true}.AppendToOutgoingContext(context.Background()), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := f.client.Echo(ctx, &echo.EchoRequest{Message: "hang"})
+	assert.Equal(f.T(), codes.DeadlineExceeded, status.Code(err))
+	assert.GreaterOrEqual(f.T(), time.Since(start), 50*time.Millisecond)
+}
+
+func (f *FaultTestSuite) TestErrorPercentage() {
+	t := f.T()
+	testCases := []struct {
+		description  string
+		spec         Spec
+		expectedCode codes.Code
+		minFailures  int
+		maxFailures  int
+	}{
+		{description: "every call fails", spec: Spec{ErrorCode: codes.ResourceExhausted}, expectedCode: codes.ResourceExhausted, minFailures: 100, maxFailures: 100},
+		{description: "no call fails", spec: Spec{ErrorCode: codes.Internal, ErrorPercent: 0.0001}, expectedCode: codes.Internal, minFailures: 0, maxFailures: 2},
+		{description: "about half of the calls fail", spec: Spec{ErrorPercent: 50}, expectedCode: codes.Unavailable, minFailures: 30, maxFailures: 70},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx := tc.spec.AppendToOutgoingContext(context.Background())
+			failures := 0
+			for i := 0; i < 100; i++ {
+				_, err := f.client.Echo(ctx, &echo.EchoRequest{Message: "maybe"})
+				if err != nil {
+					require.Equal(t, tc.expectedCode, status.Code(err))
+					failures++
+				}
+			}
+			assert.GreaterOrEqual(t, failures, tc.minFailures)
+			assert.LessOrEqual(t, failures, tc.maxFailures)
+		})
+	}
+}
+
+func (f *FaultTestSuite) TestOversizedResponse() {
+	t := f.T()
+	ctx := Spec{ResponseBytes: 64 * 1024}.AppendToOutgoingContext(context.Background())
+	resp, err := f.client.Echo(ctx, &echo.EchoRequest{Message: "big"})
+	require.NoError(t, err)
+	assert.Equal(t, "big", resp.Response, "known fields are untouched")
+	assert.GreaterOrEqual(t, proto.Size(resp), 64*1024)
+	assert.Empty(t, f.echo.sent.Load().ProtoReflect().GetUnknown(), "the response of the handler is not padded itself")
+
+	t.Log("response larger than the client accepts")
+	ctx = Spec{ResponseBytes: 2 * 1024 * 1024}.AppendToOutgoingContext(context.Background())
+	_, err = f.client.Echo(ctx, &echo.EchoRequest{Message: "huge"}, grpc.MaxCallRecvMsgSize(1024*1024))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func (f *FaultTestSuite) TestAbortMidStream() {
+	t := f.T()
+	ctx := Spec{AbortAfter: 2, ErrorCode: codes.Unavailable}.AppendToOutgoingContext(context.Background())
+	stream, err := f.client.ServerStreamEcho(ctx, &echo.ServerStreamEchoRequest{Request: &echo.EchoRequest{Message: "part"}, Count: 5})
+	require.NoError(t, err)
+	received := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			require.Fail(t, "stream should have been aborted")
+		}
+		if err != nil {
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+			break
+		}
+		received++
+	}
+	assert.Equal(t, 2, received)
+
+	t.Log("streams are aborted when the handler ignores the failed send")
+	bidi, err := f.client.BidiStreamEcho(ctx)
+	require.NoError(t, err)
+	received = 0
+	for {
+		_, err := bidi.Recv()
+		if err != nil {
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+			break
+		}
+		received++
+	}
+	assert.Equal(t, 2, received)
+}
+
+func (f *FaultTestSuite) TestAbortUnary() {
+	t := f.T()
+	ctx := Spec{AbortAfter: 1, ErrorCode: codes.Unavailable}.AppendToOutgoingContext(context.Background())
+	_, err := f.client.Echo(ctx, &echo.EchoRequest{Message: "handled"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "handled", f.echo.sent.Load().GetResponse(), "the handler ran before the call was aborted")
+
+	t.Log("the error of the handler is kept")
+	_, err = f.client.Echo(ctx, &echo.EchoRequest{Message: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestFromIncomingContext(t *testing.T) {
+	testCases := []struct {
+		description  string
+		md           metadata.MD
+		expectedSpec Spec
+		expectedCode codes.Code
+	}{
+		{description: "no metadata", md: metadata.MD{}, expectedSpec: Spec{}},
+		{description: "code by name", md: metadata.Pairs(ErrorCodeKey, "UNAVAILABLE"), expectedSpec: Spec{ErrorCode: codes.Unavailable, ErrorPercent: 100}},
+		{description: "code by number", md: metadata.Pairs(ErrorCodeKey, "8"), expectedSpec: Spec{ErrorCode: codes.ResourceExhausted, ErrorPercent: 100}},
+		{description: "percentage defaults to unavailable", md: metadata.Pairs(ErrorPercentKey, "25"), expectedSpec: Spec{ErrorCode: codes.Unavailable, ErrorPercent: 25}},
+		{description: "all faults", md: metadata.Pairs(DelayKey, "1s", AbortAfterKey, "3", ResponseBytesKey, "100", HangKey, "true"), expectedSpec: Spec{Delay: time.Second, AbortAfter: 3, ResponseBytes: 100, Hang: true}},
+		{description: "invalid delay", md: metadata.Pairs(DelayKey, "soon"), expectedCode: codes.InvalidArgument},
+		{description: "negative delay", md: metadata.Pairs(DelayKey, "-1s"), expectedCode: codes.InvalidArgument},
+		{description: "unknown code", md: metadata.Pairs(ErrorCodeKey, "EXPLODED"), expectedCode: codes.InvalidArgument},
+		{description: "code out of range", md: metadata.Pairs(ErrorCodeKey, "42"), expectedCode: codes.InvalidArgument},
+		{description: "percentage out of range", md: metadata.Pairs(ErrorPercentKey, "101"), expectedCode: codes.InvalidArgument},
+		{description: "invalid hang", md: metadata.Pairs(HangKey, "forever"), expectedCode: codes.InvalidArgument},
+		{description: "largest response bytes", md: metadata.Pairs(ResponseBytesKey, strconv.Itoa(MaxResponseBytes)), expectedSpec: Spec{ResponseBytes: MaxResponseBytes}},
+		{description: "response bytes above limit", md: metadata.Pairs(ResponseBytesKey, "2000000000"), expectedCode: codes.InvalidArgument},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			spec, err := FromIncomingContext(metadata.NewIncomingContext(context.Background(), tc.md))
+			require.Equal(t, tc.expectedCode, status.Code(err))
+			require.Equal(t, tc.expectedSpec, spec)
+		})
+	}
+}
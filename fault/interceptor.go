@@ -0,0 +1,162 @@
+package fault
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// paddingFieldNumber is the field the padding of oversized responses is encoded in.
+// Receivers do not know the field and keep it as unknown field, so any message can be padded.
+const paddingFieldNumber = 536870911
+
+// Injector injects the faults requested by callers into the RPCs of a server.
+type Injector struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// NewInjector creates an injector deciding which calls fail from the given random seed.
+func NewInjector(seed int64) *Injector {
+	return &Injector{rand: rand.New(rand.NewSource(seed))}
+}
+
+// fails decides whether a call fails given the failure percentage.
+func (i *Injector) fails(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.rand.Float64()*100 < percent
+}
+
+// before injects the faults happening before the handler runs: delays, hangs and failures.
+func (i *Injector) before(ctx context.Context, spec Spec) error {
+	if spec.Delay > 0 {
+		select {
+		case <-time.After(spec.Delay):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if spec.Hang {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	// with abort-after the error code is the status streams are aborted with instead
+	if spec.ErrorCode != codes.OK && spec.AbortAfter == 0 && i.fails(spec.ErrorPercent) {
+		return status.Errorf(spec.ErrorCode, "injected fault: %s", spec.ErrorCode)
+	}
+	return nil
+}
+
+// pad returns a copy of msg grown to at least size bytes on the wire by appending an unknown bytes field.
+// msg itself is left as it is, handlers may keep using their responses after sending them.
+func pad(msg interface{}, size int) interface{} {
+	m, ok := msg.(proto.Message)
+	if !ok || size <= 0 {
+		return msg
+	}
+	current := proto.Size(m)
+	if current >= size {
+		return msg
+	}
+	// the tag and length prefix of the padding count towards the size as well
+	tag := protowire.SizeTag(paddingFieldNumber)
+	length := size - current - tag - protowire.SizeVarint(uint64(size))
+	if length < 0 {
+		length = 0
+	}
+	for current+tag+protowire.SizeBytes(length) < size {
+		length++
+	}
+	padding := make([]byte, length)
+	padded := proto.Clone(m)
+	reflect := padded.ProtoReflect()
+	unknown := protowire.AppendTag(reflect.GetUnknown(), paddingFieldNumber, protowire.BytesType)
+	reflect.SetUnknown(protowire.AppendBytes(unknown, padding))
+	return padded
+}
+
+// abortError is the status calls are aborted with after sent messages.
+func abortError(spec Spec, sent int) error {
+	code := spec.ErrorCode
+	if code == codes.OK {
+		code = codes.Aborted
+	}
+	return status.Errorf(code, "injected fault: aborted after %d messages", sent)
+}
+
+// UnaryServerInterceptor injects the faults requested by the metadata of unary calls.
+// Unary calls have a single response, abort-after fails them once the handler ran instead of sending it,
+// unless the handler failed itself.
+func (i *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		spec, err := FromIncomingContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if spec.IsZero() {
+			return handler(ctx, req)
+		}
+		if err := i.before(ctx, spec); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if spec.AbortAfter > 0 {
+			return nil, abortError(spec, 0)
+		}
+		return pad(resp, spec.ResponseBytes), nil
+	}
+}
+
+// StreamServerInterceptor injects the faults requested by the metadata of streaming calls.
+func (i *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		spec, err := FromIncomingContext(ss.Context())
+		if err != nil {
+			return err
+		}
+		if spec.IsZero() {
+			return handler(srv, ss)
+		}
+		if err := i.before(ss.Context(), spec); err != nil {
+			return err
+		}
+		stream := &faultStream{ServerStream: ss, spec: spec}
+		if err := handler(srv, stream); err != nil {
+			return err
+		}
+		// the handler may ignore the failed send, the stream is aborted all the same.
+		return stream.aborted
+	}
+}
+
+// faultStream pads the messages sent and aborts the stream after the requested number of messages.
+type faultStream struct {
+	grpc.ServerStream
+	spec Spec
+	sent int
+	// aborted is the error the stream was aborted with, nil until then.
+	aborted error
+}
+
+func (f *faultStream) SendMsg(m interface{}) error {
+	if f.spec.AbortAfter > 0 && f.sent >= f.spec.AbortAfter {
+		f.aborted = abortError(f.spec, f.sent)
+		return f.aborted
+	}
+	f.sent++
+	return f.ServerStream.SendMsg(pad(m, f.spec.ResponseBytes))
+}
@@ -0,0 +1,193 @@
+// Package health serves the standard grpc.health.v1.Health service of the playground servers.
+//
+// Every service registered with the health server is SERVING unless one of its dependencies fails its check,
+// the server as a whole (the empty service name) is SERVING while all its services are. During graceful
+// shutdown every service reports NOT_SERVING, so load balancers stop routing new calls before the server stops.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// OverallService is the service name reporting the status of the server as a whole.
+const OverallService = ""
+
+// ErrNotServing is returned by Probe for services that answer health checks but are not SERVING.
+var ErrNotServing = errors.New("not serving")
+
+// Check reports whether a dependency is healthy, returning the reason it is not.
+type Check func(ctx context.Context) error
+
+// dependency is a check affecting the status of services.
+type dependency struct {
+	name     string
+	check    Check
+	services []string
+}
+
+// Server is a health service reporting the status of services from the checks of their dependencies.
+type Server struct {
+	grpc *health.Server
+
+	services []string
+	mu       sync.Mutex
+	deps     []dependency
+	shutdown bool
+	// status is the last status set per service, to log changes only
+	status map[string]grpc_health_v1.HealthCheckResponse_ServingStatus
+}
+
+// NewServer creates a health server reporting the services and the server as a whole as SERVING.
+func NewServer(services ...string) *Server {
+	s := &Server{
+		grpc:     health.NewServer(),
+		services: services,
+		status:   map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{},
+	}
+	for _, service := range append([]string{OverallService}, services...) {
+		s.grpc.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_SERVING)
+		s.status[service] = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return s
+}
+
+// Register registers the health service on a gRPC server.
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	grpc_health_v1.RegisterHealthServer(registrar, s.grpc)
+}
+
+// AddDependency makes the services NOT_SERVING while check fails, all services of the server when none are given.
+func (s *Server) AddDependency(name string, check Check, services ...string) {
+	if len(services) == 0 {
+		services = s.services
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deps = append(s.deps, dependency{name: name, check: check, services: services})
+}
+
+// CheckDependencies runs the checks of all dependencies and updates the status of the services,
+// every check being given at most timeout. The checks run without holding the lock, so a hanging
+// dependency does not block Shutdown.
+func (s *Server) CheckDependencies(ctx context.Context, timeout time.Duration) {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return
+	}
+	deps := append([]dependency(nil), s.deps...)
+	s.mu.Unlock()
+
+	failing := map[string]string{}
+	for _, dep := range deps {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := dep.check(checkCtx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		slog.WarnContext(ctx, "health check failed", "dependency", dep.name, "error", err)
+		for _, service := range dep.services {
+			failing[service] = dep.name
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		// the server shut down while the checks ran, NOT_SERVING stays for good.
+		return
+	}
+	overall := grpc_health_v1.HealthCheckResponse_SERVING
+	for _, service := range s.services {
+		status := grpc_health_v1.HealthCheckResponse_SERVING
+		if _, ok := failing[service]; ok {
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+			overall = status
+		}
+		s.setStatus(service, status, failing[service])
+	}
+	s.setStatus(OverallService, overall, "")
+}
+
+// setStatus updates the status of the service, logging changes.
+func (s *Server) setStatus(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus, dependency string) {
+	s.grpc.SetServingStatus(service, status)
+	if s.status[service] == status {
+		return
+	}
+	s.status[service] = status
+	if dependency != "" {
+		slog.Warn("service health changed", "service", service, "status", status.String(), "dependency", dependency)
+	} else {
+		slog.Info("service health changed", "service", service, "status", status.String())
+	}
+}
+
+// Run checks the dependencies right away and then every interval, until ctx is done.
+func (s *Server) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.CheckDependencies(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown reports all services NOT_SERVING for good, later checks do not change the status anymore.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	s.grpc.Shutdown()
+}
+
+// GracefulStop reports all services NOT_SERVING, gives health checking clients drain to notice it
+// and then stops the server gracefully. Calls still pending after timeout are cancelled.
+func (s *Server) GracefulStop(server *grpc.Server, drain, timeout time.Duration) {
+	slog.Info("shutting down, reporting NOT_SERVING", "drain", drain.String())
+	s.Shutdown()
+	time.Sleep(drain)
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("server stopped")
+	case <-time.After(timeout):
+		slog.Warn("calls still pending after shutdown timeout, cancelling them", "timeout", timeout.String())
+		server.Stop()
+		<-done
+	}
+}
+
+// Probe asks the health service of the connection for the status of service, the server as a whole when empty.
+// It fails unless the service is SERVING.
+func Probe(ctx context.Context, conn grpc.ClientConnInterface, service string) error {
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		if service == OverallService {
+			return fmt.Errorf("%w: server reports %s", ErrNotServing, resp.Status)
+		}
+		return fmt.Errorf("%w: service %s reports %s", ErrNotServing, service, resp.Status)
+	}
+	return nil
+}
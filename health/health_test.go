@@ -0,0 +1,149 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	echoService = "grpc_playground.echo.EchoService"
+	chatService = "grpc_playground.chat.ChatService"
+)
+
+// startServer serves the health server, returning a connection to it.
+func startServer(t *testing.T, h *Server) (*grpc.Server, *grpc.ClientConn) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	h.Register(server)
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return server, conn
+}
+
+func TestServer_Dependencies(t *testing.T) {
+	h := NewServer(echoService, chatService)
+	var historyFailing atomic.Bool
+	historyFailing.Store(true)
+	h.AddDependency("history", func(context.Context) error {
+		if historyFailing.Load() {
+			return errors.New("disk full")
+		}
+		return nil
+	}, chatService)
+	h.AddDependency("always healthy", func(context.Context) error { return nil })
+	_, conn := startServer(t, h)
+	ctx := context.Background()
+
+	t.Log("testing: all services are SERVING before dependencies are checked")
+	for _, service := range []string{OverallService, echoService, chatService} {
+		assert.NoError(t, Probe(ctx, conn, service))
+	}
+
+	t.Log("testing: a failing dependency makes its services and the server NOT_SERVING")
+	h.CheckDependencies(ctx, time.Second)
+	assert.NoError(t, Probe(ctx, conn, echoService))
+	assert.ErrorIs(t, Probe(ctx, conn, chatService), ErrNotServing)
+	assert.ErrorIs(t, Probe(ctx, conn, OverallService), ErrNotServing)
+
+	t.Log("testing: services recover with their dependencies")
+	historyFailing.Store(false)
+	h.CheckDependencies(ctx, time.Second)
+	for _, service := range []string{OverallService, echoService, chatService} {
+		assert.NoError(t, Probe(ctx, conn, service))
+	}
+
+	t.Log("testing: unknown services cannot be probed")
+	err := Probe(ctx, conn, "grpc_playground.Unknown")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotServing))
+}
+
+func TestServer_GracefulStop(t *testing.T) {
+	h := NewServer(echoService)
+	h.AddDependency("healthy", func(context.Context) error { return nil })
+	server, conn := startServer(t, h)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch, err := grpc_health_v1.NewHealthClient(conn).Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: echoService})
+	require.NoError(t, err)
+	resp, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+
+	t.Log("testing: watchers see NOT_SERVING while the server drains")
+	stopped := make(chan struct{})
+	go func() {
+		h.GracefulStop(server, 200*time.Millisecond, time.Second)
+		close(stopped)
+	}()
+	resp, err = watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, resp.Status)
+	assert.ErrorIs(t, Probe(ctx, conn, echoService), ErrNotServing)
+
+	t.Log("testing: checks do not bring services back during shutdown")
+	h.CheckDependencies(ctx, time.Second)
+	assert.ErrorIs(t, Probe(ctx, conn, OverallService), ErrNotServing)
+
+	t.Log("testing: pending calls are cancelled after the timeout")
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		t.Fatal("server did not stop, the open watch kept it running")
+	}
+}
+
+func TestServer_HangingDependency(t *testing.T) {
+	h := NewServer(echoService)
+	entered, release := make(chan struct{}), make(chan struct{})
+	// the check ignores its context, as a dependency stuck in a call without deadline would
+	h.AddDependency("hanging", func(context.Context) error {
+		close(entered)
+		<-release
+		return errors.New("timed out")
+	})
+	_, conn := startServer(t, h)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	checked := make(chan struct{})
+	go func() {
+		h.CheckDependencies(ctx, time.Millisecond)
+		close(checked)
+	}()
+	<-entered
+
+	t.Log("testing: shutdown does not wait for a hanging check")
+	shutdown := make(chan struct{})
+	go func() {
+		h.Shutdown()
+		close(shutdown)
+	}()
+	select {
+	case <-shutdown:
+	case <-ctx.Done():
+		t.Fatal("shutdown blocked by the hanging check")
+	}
+
+	t.Log("testing: the hanging check does not publish its result after shutdown")
+	close(release)
+	<-checked
+	assert.ErrorIs(t, Probe(ctx, conn, echoService), ErrNotServing)
+}
@@ -0,0 +1,65 @@
+// Command healthcheck probes the grpc.health.v1.Health service of a server, for use as liveness or readiness probe.
+// It exits with 0 when the service is SERVING, 1 when it is not and 2 when the server cannot be checked.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/health"
+	"github.com/pgbytes/grpc-playground/testdata"
+	"github.com/pgbytes/grpc-playground/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	exitNotServing = 1
+	exitError      = 2
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address of the server")
+	service := flag.String("service", health.OverallService, "service to check, e.g. grpc_playground.echo.EchoService, the whole server when empty")
+	timeout := flag.Duration("timeout", 5*time.Second, "time the check may take, including connecting")
+	plaintext := flag.Bool("plaintext", false, "connect without TLS")
+	caFile := flag.String("ca", testdata.Path("ca.pem"), "CA file the server certificate is verified against")
+	serverName := flag.String("server-name", "echo.test.youtube.com", "name the server certificate is verified for")
+	certFile := flag.String("cert", "", "client certificate file presented for mutual TLS")
+	keyFile := flag.String("key", "", "private key file of the client certificate")
+	flag.Parse()
+
+	creds := insecure.NewCredentials()
+	if !*plaintext {
+		tlsCfg, err := tlsconfig.Client(*caFile, *serverName, *certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, *addr, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to %s: %v\n", *addr, err)
+		os.Exit(exitError)
+	}
+	defer conn.Close()
+
+	err = health.Probe(ctx, conn, *service)
+	if errors.Is(err, health.ErrNotServing) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitNotServing)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	fmt.Println("SERVING")
+}
@@ -0,0 +1,197 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/reflection"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// RequestIDKey is the metadata key the request ID is read from and returned in.
+const RequestIDKey = "x-request-id"
+
+type rpcInfoKey struct{}
+
+// rpcInfo collects fields of an RPC set by handlers and inner interceptors, the logging interceptor reads them when the RPC finishes.
+type rpcInfo struct {
+	lock      sync.Mutex
+	requestID string
+	identity  string
+}
+
+// RequestID returns the request ID of the RPC, empty outside of the logging interceptors.
+func RequestID(ctx context.Context) string {
+	info, ok := ctx.Value(rpcInfoKey{}).(*rpcInfo)
+	if !ok {
+		return ""
+	}
+	return info.requestID
+}
+
+// SetIdentity records the authenticated identity of the caller, authentication interceptors call it once they verified the caller.
+func SetIdentity(ctx context.Context, identity string) {
+	info, ok := ctx.Value(rpcInfoKey{}).(*rpcInfo)
+	if !ok {
+		return
+	}
+	info.lock.Lock()
+	info.identity = identity
+	info.lock.Unlock()
+}
+
+func (r *rpcInfo) getIdentity() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.identity
+}
+
+// newRPCContext attaches the request ID, taken from the incoming metadata or generated, to ctx and returns it to the caller.
+func newRPCContext(ctx context.Context) (context.Context, *rpcInfo) {
+	info := &rpcInfo{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(RequestIDKey)) > 0 {
+		info.requestID = md.Get(RequestIDKey)[0]
+	} else {
+		id := make([]byte, 8)
+		_, _ = rand.Read(id)
+		info.requestID = hex.EncodeToString(id)
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, info.requestID))
+	return context.WithValue(ctx, rpcInfoKey{}, info), info
+}
+
+// levelFor logs successful RPCs as info, client mistakes as warnings and server failures as errors.
+func levelFor(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.ResourceExhausted, codes.FailedPrecondition, codes.Aborted, codes.OutOfRange:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+func logRPC(ctx context.Context, logger *slog.Logger, kind, method string, info *rpcInfo, start time.Time, err error) {
+	code := status.Code(err)
+	attrs := []slog.Attr{
+		slog.String("kind", kind),
+		slog.String("method", method),
+		slog.String("request_id", info.requestID),
+		slog.String("code", code.String()),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("peer", p.Addr.String()))
+	}
+	if identity := info.getIdentity(); identity != "" {
+		attrs = append(attrs, slog.String("identity", identity))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	logger.LogAttrs(ctx, levelFor(code), "finished rpc", attrs...)
+}
+
+// logPayload logs a message received or sent by an RPC at debug level. Fields marked as sensitive in the proto
+// are masked, the payload is rendered as JSON.
+func logPayload(ctx context.Context, logger *slog.Logger, msg, method string, info *rpcInfo, payload interface{}) {
+	m, ok := payload.(proto.Message)
+	if !ok {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("request_id", info.requestID),
+	}
+	b, err := protojson.Marshal(reflection.Redact(m, reflection.MaskSensitive))
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else {
+		// protojson varies its whitespace on purpose, compacting keeps the logs stable.
+		var compact bytes.Buffer
+		_ = json.Compact(&compact, b)
+		attrs = append(attrs, slog.Any("payload", json.RawMessage(compact.Bytes())))
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// UnaryServerInterceptor logs every unary RPC once it finished. At debug level the request and the response
+// are logged as well, with their sensitive fields masked.
+// Register it first, so the identity set by authentication interceptors after it is logged as well.
+func UnaryServerInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, rpc := newRPCContext(ctx)
+		debug := logger.Enabled(ctx, slog.LevelDebug)
+		if debug {
+			logPayload(ctx, logger, "received request", info.FullMethod, rpc, req)
+		}
+		resp, err := handler(ctx, req)
+		if debug && err == nil {
+			logPayload(ctx, logger, "sent response", info.FullMethod, rpc, resp)
+		}
+		logRPC(ctx, logger, "unary", info.FullMethod, rpc, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor logs every streaming RPC once it finished. At debug level every message received
+// and sent is logged as well, with its sensitive fields masked.
+func StreamServerInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, rpc := newRPCContext(ss.Context())
+		var stream grpc.ServerStream = &contextStream{ServerStream: ss, ctx: ctx}
+		if logger.Enabled(ctx, slog.LevelDebug) {
+			stream = &payloadStream{ServerStream: stream, logger: logger, method: info.FullMethod, info: rpc}
+		}
+		err := handler(srv, stream)
+		logRPC(ctx, logger, "stream", info.FullMethod, rpc, start, err)
+		return err
+	}
+}
+
+// payloadStream logs the messages of a server stream.
+type payloadStream struct {
+	grpc.ServerStream
+	logger *slog.Logger
+	method string
+	info   *rpcInfo
+}
+
+func (p *payloadStream) RecvMsg(m interface{}) error {
+	if err := p.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	logPayload(p.Context(), p.logger, "received message", p.method, p.info, m)
+	return nil
+}
+
+func (p *payloadStream) SendMsg(m interface{}) error {
+	logPayload(p.Context(), p.logger, "sent message", p.method, p.info, m)
+	return p.ServerStream.SendMsg(m)
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (c *contextStream) Context() context.Context {
+	return c.ctx
+}
@@ -0,0 +1,242 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeEchoServer struct {
+	echo.UnimplementedEchoServiceServer
+}
+
+func (f *fakeEchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	if req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty message")
+	}
+	return &echo.EchoResponse{Response: req.Message + " " + RequestID(ctx)}, nil
+}
+
+type fakeChatServer struct{}
+
+func (f *fakeChatServer) Chat(stream chat.ChatService_ChatServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// syncBuffer is written by the server go routines and read by the test.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(s.buf.String()), "\n") {
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	s.buf.Reset()
+	return records
+}
+
+func identityUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	SetIdentity(ctx, "alice")
+	return handler(ctx, req)
+}
+
+func identityStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	SetIdentity(ss.Context(), "bob")
+	return handler(srv, ss)
+}
+
+func startServer(t *testing.T, level string) (*syncBuffer, *grpc.ClientConn) {
+	out := &syncBuffer{}
+	logger, err := New(out, FormatJSON, level)
+	require.NoError(t, err)
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(logger), identityUnary),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(logger), identityStream),
+	)
+	echo.RegisterEchoServiceServer(server, &fakeEchoServer{})
+	chat.RegisterChatServiceServer(server, &fakeChatServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return out, conn
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	out, conn := startServer(t, "info")
+	client := echo.NewEchoServiceClient(conn)
+
+	testCases := []struct {
+		description       string
+		request           *echo.EchoRequest
+		requestID         string
+		expectedCode      string
+		expectedLevel     string
+		expectedRequestID string
+	}{
+		{
+			description:       "golden case: request id from metadata",
+			request:           &echo.EchoRequest{Message: "hello"},
+			requestID:         "req-42",
+			expectedCode:      "OK",
+			expectedLevel:     "INFO",
+			expectedRequestID: "req-42",
+		},
+		{
+			description:   "generated request id",
+			request:       &echo.EchoRequest{Message: "hello"},
+			expectedCode:  "OK",
+			expectedLevel: "INFO",
+		},
+		{
+			description:       "client error is logged as warning",
+			request:           &echo.EchoRequest{},
+			requestID:         "req-43",
+			expectedCode:      "InvalidArgument",
+			expectedLevel:     "WARN",
+			expectedRequestID: "req-43",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.requestID != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, RequestIDKey, tc.requestID)
+			}
+			var header metadata.MD
+			_, _ = client.Echo(ctx, tc.request, grpc.Header(&header))
+
+			records := out.records(t)
+			require.Len(t, records, 1)
+			record := records[0]
+			require.Equal(t, "finished rpc", record["msg"])
+			require.Equal(t, tc.expectedLevel, record["level"])
+			require.Equal(t, "unary", record["kind"])
+			require.Equal(t, "/grpc_playground.echo.EchoService/Echo", record["method"])
+			require.Equal(t, tc.expectedCode, record["code"])
+			require.Equal(t, "alice", record["identity"])
+			require.Contains(t, record["peer"], "127.0.0.1:")
+			require.Contains(t, record, "latency")
+			require.NotEmpty(t, record["request_id"])
+			if tc.expectedRequestID != "" {
+				require.Equal(t, tc.expectedRequestID, record["request_id"])
+			}
+			require.Equal(t, []string{record["request_id"].(string)}, header.Get(RequestIDKey), "request id is returned to the caller")
+		})
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	out, conn := startServer(t, "info")
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RequestIDKey, "stream-1")
+	stream, err := chat.NewChatServiceClient(conn).Chat(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&chat.ChatMessage{User: "bob", Message: "hi"}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	records := out.records(t)
+	require.Len(t, records, 1)
+	record := records[0]
+	require.Equal(t, "stream", record["kind"])
+	require.Equal(t, "/grpc_playground.chat.ChatService/Chat", record["method"])
+	require.Equal(t, "OK", record["code"])
+	require.Equal(t, "stream-1", record["request_id"])
+	require.Equal(t, "bob", record["identity"])
+}
+
+func TestPayloadLogging(t *testing.T) {
+	out, conn := startServer(t, "debug")
+
+	t.Log("testing: unary requests and responses are logged with sensitive fields masked")
+	_, err := echo.NewEchoServiceClient(conn).Echo(context.Background(), &echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: "secret"}})
+	require.NoError(t, err)
+	records := out.records(t)
+	require.Len(t, records, 3)
+	require.Equal(t, "received request", records[0]["msg"])
+	require.Equal(t, "DEBUG", records[0]["level"])
+	require.Equal(t, "/grpc_playground.echo.EchoService/Echo", records[0]["method"])
+	require.Equal(t, map[string]interface{}{"message": "hello", "talk": map[string]interface{}{"text": "[REDACTED]"}}, records[0]["payload"])
+	require.Equal(t, "sent response", records[1]["msg"])
+	require.Contains(t, records[1]["payload"], "response")
+	require.Equal(t, "finished rpc", records[2]["msg"])
+
+	t.Log("testing: stream messages are logged with sensitive fields masked")
+	stream, err := chat.NewChatServiceClient(conn).Chat(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&chat.ChatMessage{User: "bob", Message: "hi"}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+	records = out.records(t)
+	require.Len(t, records, 3)
+	for i, msg := range []string{"received message", "sent message"} {
+		require.Equal(t, msg, records[i]["msg"])
+		require.Equal(t, map[string]interface{}{"user": "bob", "message": "[REDACTED]"}, records[i]["payload"])
+	}
+	require.Equal(t, "finished rpc", records[2]["msg"])
+}
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(&out, FormatText, "warn")
+	require.NoError(t, err)
+	logger.Info("dropped")
+	logger.Warn("kept", "user", "alice")
+	require.NotContains(t, out.String(), "dropped")
+	require.Contains(t, out.String(), "level=WARN msg=kept user=alice")
+
+	_, err = New(&out, "xml", "info")
+	require.Error(t, err)
+	_, err = New(&out, FormatJSON, "chatty")
+	require.Error(t, err)
+}
@@ -0,0 +1,42 @@
+// Package logging provides structured, leveled logging for the playground servers
+// and gRPC interceptors logging every RPC with its method, peer, identity, status code, latency and request ID.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses one of debug, info, warn or error.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	if err != nil {
+		return 0, fmt.Errorf("unknown log level %q, must be one of: debug, info, warn, error", name)
+	}
+	return level, nil
+}
+
+// New creates a logger writing records of at least level to w, formatted as text or JSON.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, must be one of: %s, %s", format, FormatText, FormatJSON)
+	}
+}
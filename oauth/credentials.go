@@ -0,0 +1,325 @@
+// Package oauth implements the OAuth2 client credentials grant (RFC 6749, section 4.4) for gRPC clients,
+// and a small token server issuing the JWTs verified by the HMAC authenticator of the auth package.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grantTypeClientCredentials = "client_credentials"
+	tokenTypeBearer            = "bearer"
+
+	// defaultRefreshBefore is how long before their expiry tokens are refreshed by default.
+	defaultRefreshBefore = 30 * time.Second
+	// fetchTimeout bounds a token request, which is shared by all callers and outlives the one starting it.
+	fetchTimeout = 30 * time.Second
+	// maxTokenResponseBytes limits the size of token endpoint responses read.
+	maxTokenResponseBytes = 1 << 20
+)
+
+// Config describes the client and the token endpoint it gets access tokens from.
+type Config struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested with every token, the server grants its defaults when empty.
+	Scopes []string
+	// Audience is sent as the audience parameter when set, as expected by some servers.
+	Audience string
+	// RefreshBefore is how long before its expiry a token is replaced, 30s when zero. Tokens are replaced
+	// after half their lifetime at the latest, so short-lived tokens are not fetched for every call.
+	RefreshBefore time.Duration
+	// HTTPClient calls the token endpoint, http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// Token is an access token issued by the token endpoint.
+type Token struct {
+	AccessToken string
+	// Expiry is when the token expires, zero when the server did not tell.
+	Expiry time.Time
+	// issued is when the token was requested, zero when unknown.
+	issued time.Time
+}
+
+// Error is an error response of the token endpoint (RFC 6749, section 5.2).
+type Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("token endpoint returned %d: %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("token endpoint returned %d: %s: %s", e.StatusCode, e.Code, e.Description)
+}
+
+// ClientCredentials are per RPC credentials sending access tokens of the client credentials grant.
+// Tokens are cached and replaced shortly before they expire, concurrent calls share one token request.
+type ClientCredentials struct {
+	cfg Config
+	now func() time.Time
+
+	mu    sync.Mutex
+	token *Token
+	// refresh is the pending token request, nil when there is none.
+	refresh *refresh
+}
+
+// refresh is a token request shared by the callers waiting for its result.
+type refresh struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// NewClientCredentials creates credentials fetching tokens as configured.
+func NewClientCredentials(cfg Config) *ClientCredentials {
+	if cfg.RefreshBefore == 0 {
+		cfg.RefreshBefore = defaultRefreshBefore
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &ClientCredentials{cfg: cfg, now: time.Now}
+}
+
+// Token returns the cached token, fetching a new one when there is none or it is about to expire.
+// A token that is about to expire but still valid is kept when fetching its replacement fails.
+// Callers wait for the pending token request until their ctx is done, the request itself is not
+// cancelled with them but bounded by the fetch timeout.
+func (c *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	c.mu.Lock()
+	if c.token != nil && (c.token.Expiry.IsZero() || c.now().Add(c.refreshBefore(c.token)).Before(c.token.Expiry)) {
+		token := c.token
+		c.mu.Unlock()
+		return token, nil
+	}
+	r := c.refresh
+	if r == nil {
+		r = &refresh{done: make(chan struct{})}
+		c.refresh = r
+		go c.runRefresh(context.WithoutCancel(ctx), r)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.token, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refreshBefore returns how long before its expiry the token is replaced, RefreshBefore but at most half
+// the lifetime of the token.
+func (c *ClientCredentials) refreshBefore(token *Token) time.Duration {
+	if half := token.Expiry.Sub(token.issued) / 2; !token.issued.IsZero() && half < c.cfg.RefreshBefore {
+		return half
+	}
+	return c.cfg.RefreshBefore
+}
+
+// runRefresh fetches a token for the callers of r and caches it.
+func (c *ClientCredentials) runRefresh(ctx context.Context, r *refresh) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	token, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh = nil
+	switch {
+	case err == nil:
+		c.token = token
+	case c.token != nil && c.now().Before(c.token.Expiry):
+		slog.WarnContext(ctx, "refreshing access token failed, using the current token until it expires", "expiry", c.token.Expiry, "error", err)
+		token, err = c.token, nil
+	}
+	r.token, r.err = token, err
+	close(r.done)
+}
+
+// Invalidate drops the cached token if it is still accessToken, so the next call fetches a new one.
+func (c *ClientCredentials) Invalidate(accessToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != nil && c.token.AccessToken == accessToken {
+		c.token = nil
+	}
+}
+
+// fetch requests a new token from the token endpoint, authenticating the client with HTTP basic auth.
+func (c *ClientCredentials) fetch(ctx context.Context) (*Token, error) {
+	form := url.Values{"grant_type": {grantTypeClientCredentials}}
+	if len(c.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.cfg.Scopes, " "))
+	}
+	if c.cfg.Audience != "" {
+		form.Set("audience", c.cfg.Audience)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	requested := c.now()
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		tokenErr := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, tokenErr) != nil || tokenErr.Code == "" {
+			tokenErr.Code = http.StatusText(resp.StatusCode)
+		}
+		return nil, tokenErr
+	}
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("parsing token response: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return nil, errors.New("token response without access_token")
+	}
+	if !strings.EqualFold(payload.TokenType, tokenTypeBearer) {
+		return nil, fmt.Errorf("unsupported token type %q", payload.TokenType)
+	}
+	token := &Token{AccessToken: payload.AccessToken, issued: requested}
+	if payload.ExpiresIn > 0 {
+		// count from the request, so the token is never considered valid for longer than the server intended
+		token.Expiry = requested.Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// GetRequestMetadata sends the current access token as bearer token, implementing credentials.PerRPCCredentials.
+func (c *ClientCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := c.Token(ctx)
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	if sent, ok := ctx.Value(sentTokenKey{}).(*sentToken); ok {
+		sent.Store(token)
+	}
+	return map[string]string{"authorization": "Bearer " + token.AccessToken}, nil
+}
+
+// sentTokenKey is the context key of the sentToken of a call.
+type sentTokenKey struct{}
+
+// sentToken records the token GetRequestMetadata attached to a call, so the interceptors invalidate the token
+// the server rejected, not one that replaced it in the cache meanwhile.
+type sentToken struct {
+	atomic.Pointer[Token]
+}
+
+// withSentToken returns a context recording the token sent with the call made with it.
+func withSentToken(ctx context.Context) (context.Context, *sentToken) {
+	sent := &sentToken{}
+	return context.WithValue(ctx, sentTokenKey{}, sent), sent
+}
+
+// invalidate drops the token sent from the cache, reporting whether one was sent.
+func (s *sentToken) invalidate(c *ClientCredentials) bool {
+	token := s.Load()
+	if token == nil {
+		return false
+	}
+	c.Invalidate(token.AccessToken)
+	return true
+}
+
+// tokenError fails a call the access token could not be got for.
+func tokenError(err error) error {
+	return status.Errorf(codes.Unauthenticated, "getting access token: %v", err)
+}
+
+// RequireTransportSecurity does not allow sending tokens over plaintext connections.
+func (c *ClientCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// UnaryClientInterceptor retries calls rejected as UNAUTHENTICATED once with a new token,
+// e.g. when the token was revoked or the server's clock is ahead of the client's.
+func (c *ClientCredentials) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		sentCtx, sent := withSentToken(ctx)
+		err := invoker(sentCtx, method, req, reply, cc, opts...)
+		// calls failing before a token was sent, e.g. since none could be got, are not retried
+		if status.Code(err) != codes.Unauthenticated || !sent.invalidate(c) {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor drops the token of streams rejected as UNAUTHENTICATED, so the next call gets a new one.
+// Streams are not retried since the messages already sent cannot be replayed.
+func (c *ClientCredentials) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, sent := withSentToken(ctx)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			if status.Code(err) == codes.Unauthenticated {
+				sent.invalidate(c)
+			}
+			return nil, err
+		}
+		return &clientStream{ClientStream: stream, invalidate: func() { sent.invalidate(c) }}, nil
+	}
+}
+
+// DialOptions installs the credentials and their interceptors on a client connection.
+func (c *ClientCredentials) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithPerRPCCredentials(c),
+		grpc.WithChainUnaryInterceptor(c.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(c.StreamClientInterceptor()),
+	}
+}
+
+// clientStream invalidates the token of the stream when it ends as UNAUTHENTICATED.
+type clientStream struct {
+	grpc.ClientStream
+	invalidate func()
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if status.Code(err) == codes.Unauthenticated {
+		s.invalidate()
+	}
+	return err
+}
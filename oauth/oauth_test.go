@@ -0,0 +1,441 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/auth"
+	"github.com/pgbytes/grpc-playground/testpki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestTokenServer(t *testing.T, ttl time.Duration) *TokenServer {
+	server, err := NewTokenServer(ServerConfig{
+		Secret:   testSecret,
+		Issuer:   "playground",
+		Audience: "echo",
+		TTL:      ttl,
+		Clients: []Client{
+			{ID: "echo-client", Secret: "echo-client-secret", Scopes: []string{"echo", "admin"}},
+			{ID: "client:with/special", Secret: "s3cret&more", Scopes: []string{"echo"}},
+		},
+	})
+	require.NoError(t, err)
+	return server
+}
+
+// countingEndpoint serves the token server, counting the token requests.
+func countingEndpoint(t *testing.T, handler http.Handler) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(endpoint.Close)
+	return endpoint, &requests
+}
+
+func TestTokenServer(t *testing.T) {
+	endpoint := httptest.NewServer(newTestTokenServer(t, time.Minute))
+	defer endpoint.Close()
+	authenticator, err := auth.NewHMACAuthenticator(testSecret, auth.JWTOptions{Issuer: "playground", Audience: "echo"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		description string
+		cfg         Config
+		subject     string
+		scopes      []string
+		errorCode   string
+	}{
+		{description: "all allowed scopes by default", cfg: Config{ClientID: "echo-client", ClientSecret: "echo-client-secret"}, subject: "echo-client", scopes: []string{"echo", "admin"}},
+		{description: "requested scopes", cfg: Config{ClientID: "echo-client", ClientSecret: "echo-client-secret", Scopes: []string{"echo"}}, subject: "echo-client", scopes: []string{"echo"}},
+		{description: "encoded credentials", cfg: Config{ClientID: "client:with/special", ClientSecret: "s3cret&more"}, subject: "client:with/special", scopes: []string{"echo"}},
+		{description: "wrong secret", cfg: Config{ClientID: "echo-client", ClientSecret: "guess"}, errorCode: ErrInvalidClient},
+		{description: "unknown client", cfg: Config{ClientID: "intruder", ClientSecret: "echo-client-secret"}, errorCode: ErrInvalidClient},
+		{description: "scope not allowed", cfg: Config{ClientID: "client:with/special", ClientSecret: "s3cret&more", Scopes: []string{"admin"}}, errorCode: ErrInvalidScope},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tc.cfg.TokenURL = endpoint.URL
+			token, err := NewClientCredentials(tc.cfg).Token(context.Background())
+			if tc.errorCode != "" {
+				var tokenErr *Error
+				require.True(t, errors.As(err, &tokenErr), "unexpected error: %v", err)
+				assert.Equal(t, tc.errorCode, tokenErr.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), token.Expiry, 5*time.Second)
+			ctx := contextWithToken(token.AccessToken)
+			principal, err := authenticator.Authenticate(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.subject, principal.Subject)
+			assert.Equal(t, tc.scopes, principal.Scopes)
+		})
+	}
+
+	t.Log("testing: form credentials, unsupported grants and methods")
+	resp, err := http.PostForm(endpoint.URL, url.Values{"grant_type": {"client_credentials"}, "client_id": {"echo-client"}, "client_secret": {"echo-client-secret"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	resp, err = http.PostForm(endpoint.URL, url.Values{"grant_type": {"password"}, "client_id": {"echo-client"}, "client_secret": {"echo-client-secret"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = http.Get(endpoint.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+// contextWithToken is the incoming context of a call presenting the bearer token.
+func contextWithToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(auth.AuthorizationKey, "Bearer "+token))
+}
+
+func TestNewTokenServer_Invalid(t *testing.T) {
+	testCases := []struct {
+		description string
+		cfg         ServerConfig
+	}{
+		{description: "short secret", cfg: ServerConfig{Secret: []byte("short"), TTL: time.Minute}},
+		{description: "no TTL", cfg: ServerConfig{Secret: testSecret}},
+		{description: "client without secret", cfg: ServerConfig{Secret: testSecret, TTL: time.Minute, Clients: []Client{{ID: "a"}}}},
+		{description: "duplicate client", cfg: ServerConfig{Secret: testSecret, TTL: time.Minute, Clients: []Client{{ID: "a", Secret: "x"}, {ID: "a", Secret: "y"}}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := NewTokenServer(tc.cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestClientCredentials_Token(t *testing.T) {
+	tokenServer := newTestTokenServer(t, time.Minute)
+	var failing atomic.Bool
+	endpoint, requests := countingEndpoint(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		tokenServer.ServeHTTP(w, r)
+	}))
+	creds := NewClientCredentials(Config{TokenURL: endpoint.URL, ClientID: "echo-client", ClientSecret: "echo-client-secret", RefreshBefore: 10 * time.Second})
+	now := time.Now()
+	creds.now = func() time.Time { return now }
+
+	t.Log("testing: concurrent callers share one cached token")
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := creds.Token(context.Background())
+			if assert.NoError(t, err) {
+				tokens[i] = token.AccessToken
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), requests.Load())
+	for _, token := range tokens {
+		assert.Equal(t, tokens[0], token)
+	}
+
+	t.Log("testing: the token is refreshed before it expires")
+	now = now.Add(45 * time.Second)
+	cached, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, tokens[0], cached.AccessToken)
+	assert.Equal(t, int32(1), requests.Load())
+	now = now.Add(6 * time.Second)
+	refreshed, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+	assert.NotEqual(t, tokens[0], refreshed.AccessToken)
+
+	t.Log("testing: a failed refresh keeps the token until it expires")
+	failing.Store(true)
+	now = now.Add(55 * time.Second)
+	kept, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, refreshed.AccessToken, kept.AccessToken)
+	now = now.Add(10 * time.Second)
+	_, err = creds.Token(context.Background())
+	var tokenErr *Error
+	require.True(t, errors.As(err, &tokenErr), "unexpected error: %v", err)
+	assert.Equal(t, http.StatusServiceUnavailable, tokenErr.StatusCode)
+
+	t.Log("testing: invalidated tokens are replaced")
+	failing.Store(false)
+	current, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	creds.Invalidate("some other token")
+	same, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, current.AccessToken, same.AccessToken)
+	creds.Invalidate(current.AccessToken)
+	replaced, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, current.AccessToken, replaced.AccessToken)
+}
+
+func TestClientCredentials_ShortLivedTokens(t *testing.T) {
+	endpoint, requests := countingEndpoint(t, newTestTokenServer(t, 20*time.Second))
+	creds := NewClientCredentials(Config{TokenURL: endpoint.URL, ClientID: "echo-client", ClientSecret: "echo-client-secret"})
+	now := time.Now()
+	creds.now = func() time.Time { return now }
+
+	t.Log("testing: tokens living shorter than the refresh margin are cached for half their lifetime")
+	first, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	now = now.Add(9 * time.Second)
+	cached, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, first.AccessToken, cached.AccessToken)
+	assert.Equal(t, int32(1), requests.Load())
+	now = now.Add(2 * time.Second)
+	_, err = creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestClientCredentials_RetryInvalidatesSentToken(t *testing.T) {
+	endpoint, requests := countingEndpoint(t, newTestTokenServer(t, time.Minute))
+	creds := NewClientCredentials(Config{TokenURL: endpoint.URL, ClientID: "echo-client", ClientSecret: "echo-client-secret"})
+	stale, err := creds.Token(context.Background())
+	require.NoError(t, err)
+
+	// the cached token is replaced after the call started but before its metadata is got,
+	// the server rejects that replacement.
+	var rejected string
+	var sent []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if rejected == "" {
+			creds.Invalidate(stale.AccessToken)
+		}
+		md, err := creds.GetRequestMetadata(ctx)
+		if err != nil {
+			return err
+		}
+		token := strings.TrimPrefix(md["authorization"], "Bearer ")
+		sent = append(sent, token)
+		if rejected == "" {
+			rejected = token
+		}
+		if token == rejected {
+			return status.Error(codes.Unauthenticated, "token revoked")
+		}
+		return nil
+	}
+	err = creds.UnaryClientInterceptor()(context.Background(), "/grpc_playground.echo.EchoService/Echo", nil, nil, nil, invoker)
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	assert.NotEqual(t, stale.AccessToken, sent[0])
+	assert.NotEqual(t, sent[0], sent[1], "the retry sends a new token instead of the rejected one")
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestClientCredentials_SlowEndpoint(t *testing.T) {
+	tokenServer := newTestTokenServer(t, time.Minute)
+	release := make(chan struct{})
+	endpoint, requests := countingEndpoint(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		tokenServer.ServeHTTP(w, r)
+	}))
+	creds := NewClientCredentials(Config{TokenURL: endpoint.URL, ClientID: "echo-client", ClientSecret: "echo-client-secret"})
+
+	t.Log("testing: callers stop waiting for a slow token request when their context is done")
+	waiting := make(chan *Token)
+	go func() {
+		token, err := creds.Token(context.Background())
+		assert.NoError(t, err)
+		waiting <- token
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := creds.Token(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	t.Log("testing: the token request outlives the callers giving up and is shared by the others")
+	close(release)
+	token := <-waiting
+	require.NotNil(t, token)
+	cached, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, token.AccessToken, cached.AccessToken)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestClientCredentials_InvalidResponses(t *testing.T) {
+	testCases := []struct {
+		description string
+		statusCode  int
+		body        string
+	}{
+		{description: "not JSON", statusCode: http.StatusOK, body: "<html>"},
+		{description: "without access token", statusCode: http.StatusOK, body: `{"token_type":"Bearer","expires_in":60}`},
+		{description: "not a bearer token", statusCode: http.StatusOK, body: `{"access_token":"abc","token_type":"mac","expires_in":60}`},
+		{description: "error without JSON", statusCode: http.StatusBadGateway, body: "bad gateway"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCode)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer endpoint.Close()
+			_, err := NewClientCredentials(Config{TokenURL: endpoint.URL}).Token(context.Background())
+			assert.Error(t, err)
+		})
+	}
+}
+
+// revokingAuthenticator rejects revoked tokens before verifying them with the wrapped authenticator.
+type revokingAuthenticator struct {
+	auth.Authenticator
+	mu      sync.Mutex
+	revoked map[string]bool
+	// revokeNext revokes the next token presented, whatever it is.
+	revokeNext bool
+}
+
+func (r *revokingAuthenticator) revoke(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked[token] = true
+}
+
+func (r *revokingAuthenticator) Authenticate(ctx context.Context) (*auth.Principal, error) {
+	token, err := auth.BearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.revokeNext {
+		r.revokeNext = false
+		r.revoked[token] = true
+	}
+	if r.revoked[token] {
+		return nil, status.Error(codes.Unauthenticated, "token revoked")
+	}
+	return r.Authenticator.Authenticate(ctx)
+}
+
+type subjectEchoServer struct {
+	echo.UnimplementedEchoServiceServer
+}
+
+func (s *subjectEchoServer) Echo(ctx context.Context, _ *echo.EchoRequest) (*echo.EchoResponse, error) {
+	p, _ := auth.FromContext(ctx)
+	return &echo.EchoResponse{Response: p.Subject}, nil
+}
+
+func (s *subjectEchoServer) ServerStreamEcho(_ *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer) error {
+	p, _ := auth.FromContext(stream.Context())
+	return stream.Send(&echo.EchoResponse{Response: p.Subject})
+}
+
+func TestClientCredentials_GRPC(t *testing.T) {
+	endpoint, requests := countingEndpoint(t, newTestTokenServer(t, time.Minute))
+	hmac, err := auth.NewHMACAuthenticator(testSecret, auth.JWTOptions{Issuer: "playground", Audience: "echo"})
+	require.NoError(t, err)
+	authenticator := &revokingAuthenticator{Authenticator: hmac, revoked: map[string]bool{}}
+
+	ca := testpki.NewCA(t)
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	opts := append([]grpc.ServerOption{grpc.Creds(credentials.NewTLS(ca.Server(t).ServerConfig(nil)))}, auth.ServerOptions(authenticator)...)
+	server := grpc.NewServer(opts...)
+	echo.RegisterEchoServiceServer(server, &subjectEchoServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	defer server.Stop()
+
+	creds := NewClientCredentials(Config{TokenURL: endpoint.URL, ClientID: "echo-client", ClientSecret: "echo-client-secret", Scopes: []string{"echo"}})
+	dialOpts := append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(ca.ClientConfig("localhost", nil)))}, creds.DialOptions()...)
+	conn, err := grpc.Dial(lst.Addr().String(), dialOpts...)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := echo.NewEchoServiceClient(conn)
+
+	t.Log("testing: calls send the cached access token")
+	for i := 0; i < 3; i++ {
+		resp, err := client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+		require.NoError(t, err)
+		assert.Equal(t, "echo-client", resp.Response)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	t.Log("testing: unary calls rejected as UNAUTHENTICATED are retried once with a new token")
+	token, err := creds.Token(context.Background())
+	require.NoError(t, err)
+	authenticator.revoke(token.AccessToken)
+	_, err = client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	t.Log("testing: streams rejected as UNAUTHENTICATED drop their token without retry")
+	token, err = creds.Token(context.Background())
+	require.NoError(t, err)
+	authenticator.revoke(token.AccessToken)
+	stream, err := client.ServerStreamEcho(context.Background(), &echo.ServerStreamEchoRequest{Request: &echo.EchoRequest{Message: "hello"}, Count: 1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, int32(2), requests.Load())
+	stream, err = client.ServerStreamEcho(context.Background(), &echo.ServerStreamEchoRequest{Request: &echo.EchoRequest{Message: "hello"}, Count: 1})
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "echo-client", resp.Response)
+	assert.Equal(t, int32(3), requests.Load())
+
+	t.Log("testing: the retry replaces the token sent, even when none was cached before the call")
+	cold := NewClientCredentials(Config{TokenURL: endpoint.URL, ClientID: "echo-client", ClientSecret: "echo-client-secret", Scopes: []string{"echo"}})
+	dialOpts = append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(ca.ClientConfig("localhost", nil)))}, cold.DialOptions()...)
+	coldConn, err := grpc.Dial(lst.Addr().String(), dialOpts...)
+	require.NoError(t, err)
+	defer coldConn.Close()
+	authenticator.mu.Lock()
+	authenticator.revokeNext = true
+	authenticator.mu.Unlock()
+	_, err = echo.NewEchoServiceClient(coldConn).Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), requests.Load())
+
+	t.Log("testing: a token endpoint rejecting the client fails calls as UNAUTHENTICATED")
+	rejected := NewClientCredentials(Config{TokenURL: endpoint.URL, ClientID: "echo-client", ClientSecret: "guess"})
+	dialOpts = append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(ca.ClientConfig("localhost", nil)))}, rejected.DialOptions()...)
+	rejectedConn, err := grpc.Dial(lst.Addr().String(), dialOpts...)
+	require.NoError(t, err)
+	defer rejectedConn.Close()
+	_, err = echo.NewEchoServiceClient(rejectedConn).Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.True(t, strings.Contains(status.Convert(err).Message(), ErrInvalidClient))
+}
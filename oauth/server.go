@@ -0,0 +1,213 @@
+package oauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// minSecretBytes matches the minimum secret length of the HMAC authenticator of the auth package.
+const minSecretBytes = 32
+
+// Error codes of token endpoint responses (RFC 6749, section 5.2).
+const (
+	ErrInvalidRequest       = "invalid_request"
+	ErrInvalidClient        = "invalid_client"
+	ErrUnsupportedGrantType = "unsupported_grant_type"
+	ErrInvalidScope         = "invalid_scope"
+)
+
+// Client is a client registered with the token server.
+type Client struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+	// Scopes are the scopes the client may request, all of them are granted when it requests none.
+	Scopes []string `yaml:"scopes"`
+}
+
+// ServerConfig configures the tokens issued by the token server.
+type ServerConfig struct {
+	// Secret signs the tokens with HS256, it must be the secret of the authenticator verifying them.
+	Secret []byte
+	// Issuer and Audience are set as iss and aud claims when not empty.
+	Issuer   string
+	Audience string
+	// TTL is the lifetime of issued tokens.
+	TTL     time.Duration
+	Clients []Client
+}
+
+// TokenServer is a token endpoint issuing JWTs to registered clients with the client credentials grant.
+// It is meant for tests and demos: clients and the signing secret are fixed at creation.
+type TokenServer struct {
+	cfg     ServerConfig
+	clients map[string]Client
+	now     func() time.Time
+}
+
+// NewTokenServer creates a token server, validating the configuration.
+func NewTokenServer(cfg ServerConfig) (*TokenServer, error) {
+	if len(cfg.Secret) < minSecretBytes {
+		return nil, fmt.Errorf("token secret must be at least %d bytes long, got %d", minSecretBytes, len(cfg.Secret))
+	}
+	if cfg.TTL <= 0 {
+		return nil, fmt.Errorf("token TTL must be positive, got %s", cfg.TTL)
+	}
+	clients := map[string]Client{}
+	for i, c := range cfg.Clients {
+		if c.ID == "" || c.Secret == "" {
+			return nil, fmt.Errorf("client %d: id and secret must not be empty", i)
+		}
+		if _, ok := clients[c.ID]; ok {
+			return nil, fmt.Errorf("client %d: duplicate client %q", i, c.ID)
+		}
+		clients[c.ID] = c
+	}
+	return &TokenServer{cfg: cfg, clients: clients, now: time.Now}, nil
+}
+
+// LoadClients reads the registered clients from a YAML file of the form
+//
+//	clients:
+//	  - id: echo-client
+//	    secret: echo-client-secret
+//	    scopes: [echo]
+func LoadClients(path string) ([]Client, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading clients file: %w", err)
+	}
+	var file struct {
+		Clients []Client `yaml:"clients"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing clients file %s: %w", path, err)
+	}
+	return file.Clients, nil
+}
+
+// ServeHTTP handles token requests of the client credentials grant. Clients authenticate with HTTP basic auth,
+// or the client_id and client_secret form parameters.
+func (s *TokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, ErrInvalidRequest, "token requests must be POST")
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "invalid form")
+		return
+	}
+	client, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeError(w, http.StatusUnauthorized, ErrInvalidClient, "client authentication failed")
+		return
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != grantTypeClientCredentials {
+		writeError(w, http.StatusBadRequest, ErrUnsupportedGrantType, fmt.Sprintf("grant type %q not supported", grantType))
+		return
+	}
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !contains(client.Scopes, scope) {
+			writeError(w, http.StatusBadRequest, ErrInvalidScope, fmt.Sprintf("scope %q not allowed", scope))
+			return
+		}
+	}
+	token, err := s.issue(client, scopes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "issuing token failed", "client", client.ID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	// tokens must not be cached by intermediaries (RFC 6749, section 5.1)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int64(s.cfg.TTL / time.Second),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+// authenticate returns the registered client matching the credentials of the request.
+func (s *TokenServer) authenticate(r *http.Request) (Client, bool) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// basic auth credentials are form encoded (RFC 6749, section 2.3.1)
+		var err1, err2 error
+		id, err1 = url.QueryUnescape(id)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return Client{}, false
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, ok := s.clients[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1 {
+		return Client{}, false
+	}
+	return client, true
+}
+
+// issue signs a JWT for the client with the granted scopes.
+func (s *TokenServer) issue(client Client, scopes []string) (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	now := s.now()
+	claims := jwt.MapClaims{
+		"sub":   client.ID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(s.cfg.TTL).Unix(),
+		"jti":   hex.EncodeToString(id),
+		"scope": strings.Join(scopes, " "),
+	}
+	if s.cfg.Issuer != "" {
+		claims["iss"] = s.cfg.Issuer
+	}
+	if s.cfg.Audience != "" {
+		claims["aud"] = s.cfg.Audience
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.cfg.Secret)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+// Command tokenserver runs a local OAuth2 token endpoint for demos, issuing HS256 JWTs
+// to the clients of a YAML file with the client credentials grant.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/logging"
+	"github.com/pgbytes/grpc-playground/oauth"
+	"github.com/pgbytes/grpc-playground/testdata"
+)
+
+const tokenPath = "/token"
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "address the token endpoint listens on")
+	clientsFile := flag.String("clients", testdata.Path("oauth-clients.yaml"), "YAML file of the registered clients")
+	secretFile := flag.String("secret-file", testdata.Path("jwt-secret"), "file holding the HS256 secret tokens are signed with")
+	issuer := flag.String("issuer", "", "iss claim of issued tokens, none when empty")
+	audience := flag.String("audience", "", "aud claim of issued tokens, none when empty")
+	ttl := flag.Duration("ttl", 5*time.Minute, "lifetime of issued tokens")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file, plain HTTP when empty")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	logFormat := flag.String("log-format", logging.FormatText, "format of the logs: text or json")
+	flag.Parse()
+
+	logger, err := logging.New(os.Stdout, *logFormat, "info")
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
+	secret, err := os.ReadFile(*secretFile)
+	if err != nil {
+		panic(err)
+	}
+	clients, err := oauth.LoadClients(*clientsFile)
+	if err != nil {
+		panic(err)
+	}
+	tokenServer, err := oauth.NewTokenServer(oauth.ServerConfig{
+		Secret:   []byte(strings.TrimSpace(string(secret))),
+		Issuer:   *issuer,
+		Audience: *audience,
+		TTL:      *ttl,
+		Clients:  clients,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(tokenPath, tokenServer)
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("serving token endpoint", "addr", *addr, "path", tokenPath, "clients", len(clients))
+	if *tlsCert != "" {
+		err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		slog.Warn("serving client secrets and tokens over plain HTTP, for local demos only")
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,142 @@
+package reflection
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ErrWrongMessage is returned by accessors for messages of another type than they were built for.
+var ErrWrongMessage = fmt.Errorf("message is not of the accessor type")
+
+// Accessor reads and writes the field at a path of the messages of one type. The path is resolved to field
+// descriptors once, when the accessor is built, so accessing the field repeats no lookups by name.
+// Accessors are immutable and safe for concurrent use.
+type Accessor struct {
+	md    protoreflect.MessageDescriptor
+	path  string
+	steps []step
+}
+
+// NewAccessor builds an accessor of the field at path, as described by GetField, in messages of md.
+func NewAccessor(md protoreflect.MessageDescriptor, path string) (*Accessor, error) {
+	steps, err := compilePath(md, path)
+	if err != nil {
+		return nil, err
+	}
+	return &Accessor{md: md, path: path, steps: steps}, nil
+}
+
+// accessorKey identifies a cached accessor. Descriptors are compared by identity: dynamic and generated
+// messages of the same name have distinct descriptors, whose field descriptors cannot be mixed.
+type accessorKey struct {
+	md   protoreflect.MessageDescriptor
+	path string
+}
+
+// maxCachedAccessors bounds the accessors cached by AccessorFor. Paths may come from requests, e.g. field masks
+// or grpcurl input, so the cache must not grow with every path callers make up.
+const maxCachedAccessors = 1024
+
+// accessorCache holds the accessors built by AccessorFor.
+type accessorCache struct {
+	mu      sync.RWMutex
+	entries map[accessorKey]*Accessor
+}
+
+// accessors caches the accessors built by AccessorFor.
+var accessors = accessorCache{entries: map[accessorKey]*Accessor{}}
+
+// AccessorFor returns the accessor of the field at path in messages of md, building it on first use
+// and returning the same accessor afterwards. Paths failing to resolve are not cached, and once
+// maxCachedAccessors are cached an arbitrary one is evicted for every new one.
+func AccessorFor(md protoreflect.MessageDescriptor, path string) (*Accessor, error) {
+	key := accessorKey{md: md, path: path}
+	accessors.mu.RLock()
+	accessor, ok := accessors.entries[key]
+	accessors.mu.RUnlock()
+	if ok {
+		return accessor, nil
+	}
+
+	accessors.mu.Lock()
+	defer accessors.mu.Unlock()
+	// another goroutine may have built it meanwhile, callers share one accessor.
+	if accessor, ok := accessors.entries[key]; ok {
+		return accessor, nil
+	}
+	accessor, err := NewAccessor(md, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(accessors.entries) >= maxCachedAccessors {
+		// map iteration starts at a random entry, which is evicted.
+		for evicted := range accessors.entries {
+			delete(accessors.entries, evicted)
+			break
+		}
+	}
+	accessors.entries[key] = accessor
+	return accessor, nil
+}
+
+// Path returns the field path of the accessor.
+func (a *Accessor) Path() string {
+	return a.path
+}
+
+// message returns the reflection of msg, unless it is of another type than the accessor.
+func (a *Accessor) message(msg proto.Message) (protoreflect.Message, error) {
+	m := msg.ProtoReflect()
+	if m.Descriptor() != a.md {
+		return nil, fmt.Errorf("%w: %s accesses %s, not %s", ErrWrongMessage, a.path, a.md.FullName(), m.Descriptor().FullName())
+	}
+	return m, nil
+}
+
+// Get returns the value of the field in msg and whether it is set. Messages of another type have no value.
+func (a *Accessor) Get(msg proto.Message) (protoreflect.Value, bool) {
+	m, err := a.message(msg)
+	if err != nil {
+		return protoreflect.Value{}, false
+	}
+	v, _, found := get(m, a.steps)
+	return v, found
+}
+
+// Extract returns the value of the field in msg formatted as string, and whether it is set.
+// Enums are formatted by name, bytes as base64 and messages as JSON.
+func (a *Accessor) Extract(msg proto.Message) (string, bool) {
+	m, err := a.message(msg)
+	if err != nil {
+		return "", false
+	}
+	v, fd, found := get(m, a.steps)
+	if !found {
+		return "", false
+	}
+	return formatValue(fd, v)
+}
+
+// Set sets the field in msg to value, as described by SetField.
+func (a *Accessor) Set(msg proto.Message, value protoreflect.Value) error {
+	m, err := a.message(msg)
+	if err != nil {
+		return err
+	}
+	return set(m, a.steps, setValue(value))
+}
+
+// SetString sets the field in msg to value converted to the kind of the field, as described by SetByProtoReflection.
+func (a *Accessor) SetString(msg proto.Message, value string) error {
+	m, err := a.message(msg)
+	if err != nil {
+		return err
+	}
+	return set(m, a.steps, func(fd protoreflect.FieldDescriptor, empty protoreflect.Value) (protoreflect.Value, error) {
+		return parseValue(fd, empty, value, protoregistry.GlobalTypes)
+	})
+}
@@ -0,0 +1,216 @@
+package reflection
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestAccessor(t *testing.T) {
+	md := (&echo.EchoRequest{}).ProtoReflect().Descriptor()
+	accessor, err := NewAccessor(md, "talk.text")
+	require.NoError(t, err)
+	assert.Equal(t, "talk.text", accessor.Path())
+
+	t.Log("testing: fields are read and written through the accessor")
+	req := &echo.EchoRequest{}
+	_, found := accessor.Get(req)
+	assert.False(t, found)
+	require.NoError(t, accessor.SetString(req, "hello"))
+	assert.Equal(t, "hello", req.GetTalk().GetText())
+	require.NoError(t, accessor.Set(req, protoreflect.ValueOfString("world")))
+	v, found := accessor.Get(req)
+	assert.True(t, found)
+	assert.Equal(t, "world", v.String())
+	text, found := accessor.Extract(req)
+	assert.True(t, found)
+	assert.Equal(t, "world", text)
+
+	t.Log("testing: messages of another type are rejected")
+	other := &echo.ServerStreamEchoRequest{}
+	_, found = accessor.Get(other)
+	assert.False(t, found)
+	_, found = accessor.Extract(other)
+	assert.False(t, found)
+	assert.ErrorIs(t, accessor.Set(other, protoreflect.ValueOfString("x")), ErrWrongMessage)
+	assert.ErrorIs(t, accessor.SetString(other, "x"), ErrWrongMessage)
+
+	t.Log("testing: dynamic messages of the same name have their own descriptor")
+	dynamic := dynamicpb.NewMessage(dynamicDescriptor(t, md))
+	assert.ErrorIs(t, accessor.SetString(dynamic, "x"), ErrWrongMessage)
+	require.NoError(t, SetByProtoReflection(dynamic, "talk.text", "dynamic"))
+	text, found = ExtractByProtoReflection(dynamic, "talk.text")
+	assert.True(t, found)
+	assert.Equal(t, "dynamic", text)
+
+	t.Log("testing: invalid paths fail to build")
+	_, err = NewAccessor(md, "talk.unknown")
+	assert.ErrorIs(t, err, ErrFieldNotFound)
+}
+
+// dynamicDescriptor rebuilds md from its proto, as a descriptor distinct from the generated one.
+func dynamicDescriptor(t *testing.T, md protoreflect.MessageDescriptor) protoreflect.MessageDescriptor {
+	registry, err := NewRegistryFromSet(echoDescriptorSet(true))
+	require.NoError(t, err)
+	dynamic, err := registry.FindMessage(string(md.FullName()))
+	require.NoError(t, err)
+	require.NotEqual(t, md, dynamic)
+	return dynamic
+}
+
+func TestAccessorFor(t *testing.T) {
+	md := (&echo.EchoRequest{}).ProtoReflect().Descriptor()
+
+	t.Log("testing: accessors are built once and shared by concurrent callers")
+	const goroutines = 16
+	built := make([]*Accessor, goroutines)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			accessor, err := AccessorFor(md, "talk.text")
+			assert.NoError(t, err)
+			built[i] = accessor
+			req := &echo.EchoRequest{}
+			assert.NoError(t, accessor.SetString(req, "concurrent"))
+			text, _ := accessor.Extract(req)
+			assert.Equal(t, "concurrent", text)
+		}(i)
+	}
+	wg.Wait()
+	for _, accessor := range built {
+		assert.Same(t, built[0], accessor)
+	}
+
+	t.Log("testing: accessors are cached per descriptor and path")
+	message, err := AccessorFor(md, "message")
+	require.NoError(t, err)
+	assert.NotSame(t, built[0], message)
+	dynamic, err := AccessorFor(dynamicDescriptor(t, md), "talk.text")
+	require.NoError(t, err)
+	assert.NotSame(t, built[0], dynamic)
+
+	t.Log("testing: paths failing to resolve keep failing and are not cached")
+	for i := 0; i < 2; i++ {
+		_, err := AccessorFor(md, "talk.unknown")
+		assert.ErrorIs(t, err, ErrFieldNotFound)
+	}
+	accessors.mu.RLock()
+	_, cached := accessors.entries[accessorKey{md: md, path: "talk.unknown"}]
+	accessors.mu.RUnlock()
+	assert.False(t, cached)
+
+	t.Log("testing: the cache is bounded")
+	kinds := kindsDescriptor(t)
+	for i := 0; i < 2*maxCachedAccessors; i++ {
+		_, err := AccessorFor(kinds, fmt.Sprintf("tags[%d]", i))
+		require.NoError(t, err)
+	}
+	accessors.mu.RLock()
+	assert.LessOrEqual(t, len(accessors.entries), maxCachedAccessors)
+	accessors.mu.RUnlock()
+}
+
+// extractByProtoReflectionLookup reads a string field by looking up every field of the path by name,
+// as proto reflection does without accessors.
+func extractByProtoReflectionLookup(msg proto.Message, names []protoreflect.Name) (string, bool) {
+	m := msg.ProtoReflect()
+	for i, name := range names {
+		fd := m.Descriptor().Fields().ByName(name)
+		if fd == nil || !m.Has(fd) {
+			return "", false
+		}
+		if i == len(names)-1 {
+			return m.Get(fd).String(), true
+		}
+		m = m.Get(fd).Message()
+	}
+	return "", false
+}
+
+// extractByGoReflection reads a string field through the Go struct fields of the path.
+func extractByGoReflection(req interface{}, fields []string) (string, bool) {
+	v := reflect.ValueOf(req)
+	for _, field := range fields {
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return "", false
+		}
+		v = v.Elem().FieldByName(field)
+		if !v.IsValid() {
+			return "", false
+		}
+	}
+	if v.Kind() != reflect.String || v.String() == "" {
+		return "", false
+	}
+	return v.String(), true
+}
+
+func BenchmarkFieldAccess(b *testing.B) {
+	request := &echo.EchoRequest{Message: "3sm5akzqp2u0", Talk: &echo.Message{Text: "3sm5akzqp2u0"}}
+	for _, bc := range []struct {
+		description string
+		path        string
+		names       []protoreflect.Name
+		goFields    []string
+	}{
+		{description: "flat", path: "message", names: []protoreflect.Name{"message"}, goFields: []string{"Message"}},
+		{description: "nested", path: "talk.text", names: []protoreflect.Name{"talk", "text"}, goFields: []string{"Talk", "Text"}},
+	} {
+		accessor, err := NewAccessor(request.ProtoReflect().Descriptor(), bc.path)
+		require.NoError(b, err)
+		b.Run(bc.description+"/accessor", func(b *testing.B) {
+			var actual protoreflect.Value
+			for i := 0; i < b.N; i++ {
+				actual, _ = accessor.Get(request)
+			}
+			benchMarkResultProto = actual.String()
+		})
+		b.Run(bc.description+"/cached_accessor_lookup", func(b *testing.B) {
+			var actual string
+			var found bool
+			for i := 0; i < b.N; i++ {
+				actual, found = ExtractByProtoReflection(request, bc.path)
+			}
+			benchMarkResultProto = actual
+			benchMarkResultProtoBool = found
+		})
+		b.Run(bc.description+"/proto_reflect", func(b *testing.B) {
+			var actual string
+			var found bool
+			for i := 0; i < b.N; i++ {
+				actual, found = extractByProtoReflectionLookup(request, bc.names)
+			}
+			benchMarkResultProto = actual
+			benchMarkResultProtoBool = found
+		})
+		b.Run(bc.description+"/uncached_path", func(b *testing.B) {
+			var actual string
+			var found bool
+			for i := 0; i < b.N; i++ {
+				a, _ := NewAccessor(request.ProtoReflect().Descriptor(), bc.path)
+				actual, found = a.Extract(request)
+			}
+			benchMarkResultProto = actual
+			benchMarkResultProtoBool = found
+		})
+		b.Run(bc.description+"/go_reflect", func(b *testing.B) {
+			var actual string
+			var found bool
+			for i := 0; i < b.N; i++ {
+				actual, found = extractByGoReflection(request, bc.goFields)
+			}
+			benchMarkResultProto = actual
+			benchMarkResultProtoBool = found
+		})
+	}
+}
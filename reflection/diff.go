@@ -0,0 +1,471 @@
+package reflection
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ChangeKind tells how a field differs between two messages.
+type ChangeKind int
+
+const (
+	// Added fields are only set in the second message.
+	Added ChangeKind = iota
+	// Removed fields are only set in the first message.
+	Removed
+	// Changed fields are set to different values in both messages.
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// Change is a field differing between two messages. Path is a field path as accepted by GetField, e.g. talk.text,
+// values[0] or labels["key"], empty for the messages themselves. Unknown fields are named by their number.
+// Old and New are the formatted values, Old is empty for added fields and New for removed ones.
+type Change struct {
+	Kind ChangeKind
+	Path string
+	Old  string
+	New  string
+}
+
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "(message)"
+	}
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", path, c.New)
+	case Removed:
+		return fmt.Sprintf("- %s: %s", path, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", path, c.Old, c.New)
+	}
+}
+
+// Changes are the differences between two messages, one per line when formatted, so test failures show them readably.
+type Changes []Change
+
+func (c Changes) String() string {
+	lines := make([]string, 0, len(c))
+	for _, change := range c {
+		lines = append(lines, change.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffOption customizes Diff.
+type DiffOption func(*differ)
+
+// IgnorePaths skips the fields at paths, and everything nested in them. Paths are written as the paths of
+// changes; [*] matches any list index or map key, e.g. field_violations[*].description.
+func IgnorePaths(paths ...string) DiffOption {
+	return func(d *differ) {
+		for _, path := range paths {
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(path), `\[\*\]`, `\[[^\]]*\]`)
+			d.ignored = append(d.ignored, regexp.MustCompile("^"+pattern+"$"))
+		}
+	}
+}
+
+// IgnoreUnknown skips the unknown fields of the messages.
+func IgnoreUnknown() DiffOption {
+	return func(d *differ) {
+		d.ignoreUnknown = true
+	}
+}
+
+type differ struct {
+	ignored       []*regexp.Regexp
+	ignoreUnknown bool
+	changes       Changes
+}
+
+// Diff compares a and b field by field and returns the fields added, removed or changed from a to b, in field
+// number order. Lists are compared by index and maps by key, Any messages of types linked into the binary are
+// compared by their contents. Messages of different types are reported as a single change. No changes means
+// the messages are equal, as far as the options do not ignore fields.
+func Diff(a, b proto.Message, opts ...DiffOption) Changes {
+	d := &differ{}
+	for _, opt := range opts {
+		opt(d)
+	}
+	ma, mb := reflectMessage(a), reflectMessage(b)
+	switch {
+	case ma == nil && mb == nil:
+	case ma == nil:
+		d.add(Added, "", "", formatMessage(mb))
+	case mb == nil:
+		d.add(Removed, "", formatMessage(ma), "")
+	case ma.Descriptor().FullName() != mb.Descriptor().FullName():
+		d.add(Changed, "", string(ma.Descriptor().FullName()), string(mb.Descriptor().FullName()))
+	default:
+		d.diffMessage("", ma, mb)
+	}
+	return d.changes
+}
+
+// reflectMessage returns the reflection of msg, nil for nil messages.
+func reflectMessage(msg proto.Message) protoreflect.Message {
+	if msg == nil {
+		return nil
+	}
+	m := msg.ProtoReflect()
+	if !m.IsValid() {
+		return nil
+	}
+	return m
+}
+
+func (d *differ) add(kind ChangeKind, path, before, after string) {
+	d.changes = append(d.changes, Change{Kind: kind, Path: path, Old: before, New: after})
+}
+
+func (d *differ) isIgnored(path string) bool {
+	for _, ignored := range d.ignored {
+		if ignored.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *differ) diffMessage(prefix string, a, b protoreflect.Message) {
+	if a.Descriptor().FullName() == anyFullName && d.diffAny(prefix, a, b) {
+		return
+	}
+	// the fields set in either message, extensions included, in field number order. The descriptors of both sides
+	// are kept: dynamic and generated messages of the same type have distinct descriptors that cannot be mixed.
+	fieldsA, fieldsB := setFields(a), setFields(b)
+	numbers := make([]protoreflect.FieldNumber, 0, len(fieldsA)+len(fieldsB))
+	for number := range fieldsA {
+		numbers = append(numbers, number)
+	}
+	for number := range fieldsB {
+		if _, ok := fieldsA[number]; !ok {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	for _, number := range numbers {
+		fdA, hasA := fieldsA[number]
+		fdB, hasB := fieldsB[number]
+		fd := fdA
+		if !hasA {
+			fd = fdB
+		}
+		path := joinPath(prefix, fieldName(fd))
+		if d.isIgnored(path) {
+			continue
+		}
+		var va, vb protoreflect.Value
+		if hasA {
+			va = a.Get(fdA)
+		}
+		if hasB {
+			vb = b.Get(fdB)
+		}
+		switch {
+		case fd.IsList():
+			var la, lb protoreflect.List
+			if hasA {
+				la = va.List()
+			}
+			if hasB {
+				lb = vb.List()
+			}
+			d.diffList(path, fd, la, lb)
+		case fd.IsMap():
+			var ma, mb protoreflect.Map
+			if hasA {
+				ma = va.Map()
+			}
+			if hasB {
+				mb = vb.Map()
+			}
+			d.diffMap(path, fd, ma, mb)
+		default:
+			d.diffValue(path, fd, hasA, hasB, va, vb)
+		}
+	}
+	if !d.ignoreUnknown {
+		d.diffUnknown(prefix, a.GetUnknown(), b.GetUnknown())
+	}
+}
+
+// setFields returns the fields set in m by number.
+func setFields(m protoreflect.Message) map[protoreflect.FieldNumber]protoreflect.FieldDescriptor {
+	fields := map[protoreflect.FieldNumber]protoreflect.FieldDescriptor{}
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields[fd.Number()] = fd
+		return true
+	})
+	return fields
+}
+
+// diffValue compares singular values of fd, hasA and hasB tell whether they are set.
+func (d *differ) diffValue(path string, fd protoreflect.FieldDescriptor, hasA, hasB bool, a, b protoreflect.Value) {
+	switch {
+	case hasA && !hasB:
+		d.add(Removed, path, formatDiffValue(fd, a), "")
+	case !hasA && hasB:
+		d.add(Added, path, "", formatDiffValue(fd, b))
+	case fd.Message() != nil:
+		d.diffMessage(path, a.Message(), b.Message())
+	case !equalScalars(a, b):
+		d.add(Changed, path, formatDiffValue(fd, a), formatDiffValue(fd, b))
+	}
+}
+
+// diffList compares lists by index, a nil list is empty.
+func (d *differ) diffList(path string, fd protoreflect.FieldDescriptor, a, b protoreflect.List) {
+	lenA, lenB := 0, 0
+	if a != nil {
+		lenA = a.Len()
+	}
+	if b != nil {
+		lenB = b.Len()
+	}
+	for i := 0; i < lenA || i < lenB; i++ {
+		elemPath := fmt.Sprintf("%s[%d]", path, i)
+		if d.isIgnored(elemPath) {
+			continue
+		}
+		var va, vb protoreflect.Value
+		if i < lenA {
+			va = a.Get(i)
+		}
+		if i < lenB {
+			vb = b.Get(i)
+		}
+		d.diffValue(elemPath, fd, i < lenA, i < lenB, va, vb)
+	}
+}
+
+// diffMap compares maps by key, a nil map is empty.
+func (d *differ) diffMap(path string, fd protoreflect.FieldDescriptor, a, b protoreflect.Map) {
+	// maps are ranged in random order, the keys are sorted to report changes in a stable order.
+	keys := map[string]protoreflect.MapKey{}
+	collect := func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys[formatMapKey(k)] = k
+		return true
+	}
+	for _, m := range []protoreflect.Map{a, b} {
+		if m != nil {
+			m.Range(collect)
+		}
+	}
+	formatted := make([]string, 0, len(keys))
+	for key := range keys {
+		formatted = append(formatted, key)
+	}
+	sort.Strings(formatted)
+	for _, key := range formatted {
+		entryPath := path + "[" + key + "]"
+		if d.isIgnored(entryPath) {
+			continue
+		}
+		k := keys[key]
+		var va, vb protoreflect.Value
+		hasA, hasB := a != nil && a.Has(k), b != nil && b.Has(k)
+		if hasA {
+			va = a.Get(k)
+		}
+		if hasB {
+			vb = b.Get(k)
+		}
+		d.diffValue(entryPath, fd.MapValue(), hasA, hasB, va, vb)
+	}
+}
+
+// diffAny compares the contents of Any messages of the same, linked in, type. It returns false for Any messages
+// it cannot unpack, which are compared by their fields instead.
+func (d *differ) diffAny(path string, a, b protoreflect.Message) bool {
+	fields := a.Descriptor().Fields()
+	typeURL, value := fields.ByName("type_url"), fields.ByName("value")
+	if typeURL == nil || value == nil || a.Get(typeURL).String() != b.Get(typeURL).String() {
+		return false
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(a.Get(typeURL).String())
+	if err != nil {
+		return false
+	}
+	ua, ub := mt.New(), mt.New()
+	if proto.Unmarshal(a.Get(value).Bytes(), ua.Interface()) != nil || proto.Unmarshal(b.Get(value).Bytes(), ub.Interface()) != nil {
+		return false
+	}
+	d.diffMessage(path, ua, ub)
+	if !d.ignoreUnknown {
+		d.diffUnknown(path, a.GetUnknown(), b.GetUnknown())
+	}
+	return true
+}
+
+// diffUnknown compares the unknown fields of two messages by field number.
+func (d *differ) diffUnknown(prefix string, a, b protoreflect.RawFields) {
+	if bytes.Equal(a, b) {
+		return
+	}
+	fa, fb := unknownFields(a), unknownFields(b)
+	numbers := map[protowire.Number]bool{}
+	for number := range fa {
+		numbers[number] = true
+	}
+	for number := range fb {
+		numbers[number] = true
+	}
+	sorted := make([]protowire.Number, 0, len(numbers))
+	for number := range numbers {
+		sorted = append(sorted, number)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, number := range sorted {
+		path := joinPath(prefix, strconv.Itoa(int(number)))
+		if d.isIgnored(path) {
+			continue
+		}
+		va, hasA := fa[number]
+		vb, hasB := fb[number]
+		switch {
+		case !hasB:
+			d.add(Removed, path, strings.Join(va, ", "), "")
+		case !hasA:
+			d.add(Added, path, "", strings.Join(vb, ", "))
+		case strings.Join(va, ", ") != strings.Join(vb, ", "):
+			d.add(Changed, path, strings.Join(va, ", "), strings.Join(vb, ", "))
+		}
+	}
+}
+
+// unknownFields decodes raw fields into their formatted values by field number. Malformed fields end the decoding,
+// the rest is reported as raw bytes under field number 0.
+func unknownFields(raw protoreflect.RawFields) map[protowire.Number][]string {
+	fields := map[protowire.Number][]string{}
+	for len(raw) > 0 {
+		number, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			break
+		}
+		m := protowire.ConsumeFieldValue(number, typ, raw[n:])
+		if m < 0 {
+			break
+		}
+		fields[number] = append(fields[number], formatUnknown(typ, raw[n:n+m]))
+		raw = raw[n+m:]
+	}
+	if len(raw) > 0 {
+		fields[0] = append(fields[0], base64.StdEncoding.EncodeToString(raw))
+	}
+	return fields
+}
+
+// formatUnknown formats the value of an unknown field, which carries only its wire type.
+func formatUnknown(typ protowire.Type, b []byte) string {
+	switch typ {
+	case protowire.VarintType:
+		v, _ := protowire.ConsumeVarint(b)
+		return strconv.FormatUint(v, 10)
+	case protowire.Fixed32Type:
+		v, _ := protowire.ConsumeFixed32(b)
+		return strconv.FormatUint(uint64(v), 10)
+	case protowire.Fixed64Type:
+		v, _ := protowire.ConsumeFixed64(b)
+		return strconv.FormatUint(v, 10)
+	case protowire.BytesType:
+		v, _ := protowire.ConsumeBytes(b)
+		if utf8.Valid(v) {
+			return strconv.Quote(string(v))
+		}
+		return base64.StdEncoding.EncodeToString(v)
+	default:
+		return base64.StdEncoding.EncodeToString(b)
+	}
+}
+
+// equalScalars compares non message values, NaN equals NaN.
+func equalScalars(a, b protoreflect.Value) bool {
+	switch va := a.Interface().(type) {
+	case []byte:
+		return bytes.Equal(va, b.Bytes())
+	case float32:
+		vb := float32(b.Float())
+		return va == vb || math.IsNaN(float64(va)) && math.IsNaN(float64(vb))
+	case float64:
+		return va == b.Float() || math.IsNaN(va) && math.IsNaN(b.Float())
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
+
+// formatDiffValue formats a singular value of fd, strings quoted.
+func formatDiffValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if fd.Message() != nil {
+		return formatMessage(v.Message())
+	}
+	if fd.Kind() == protoreflect.StringKind {
+		return strconv.Quote(v.String())
+	}
+	s, _ := formatValue(fd, v)
+	return s
+}
+
+// formatMessage formats a message as compact JSON, or by its type when it cannot be encoded, e.g. holding an
+// Any of an unknown type.
+func formatMessage(m protoreflect.Message) string {
+	b, err := protojson.Marshal(m.Interface())
+	if err != nil {
+		return "<" + string(m.Descriptor().FullName()) + ">"
+	}
+	// protojson varies its whitespace on purpose, compacting keeps the changes stable.
+	var compact bytes.Buffer
+	_ = json.Compact(&compact, b)
+	return compact.String()
+}
+
+// fieldName names a field in paths, extensions by their full name in parentheses.
+func fieldName(fd protoreflect.FieldDescriptor) string {
+	if fd.IsExtension() {
+		return "(" + string(fd.FullName()) + ")"
+	}
+	return string(fd.Name())
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// formatMapKey formats a map key as in field paths, strings are quoted.
+func formatMapKey(k protoreflect.MapKey) string {
+	if s, ok := k.Interface().(string); ok {
+		return strconv.Quote(s)
+	}
+	return k.String()
+}
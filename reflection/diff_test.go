@@ -0,0 +1,181 @@
+package reflection
+
+import (
+	"testing"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestDiff(t *testing.T) {
+	md := kindsDescriptor(t)
+	registry := NewRegistry(protoregistry.GlobalFiles)
+	const base = `{
+		"stringValue": "a", "int32Value": 1, "bytesValue": "AQI=", "color": "COLOR_RED", "floatValue": "NaN",
+		"tags": ["x", "y"], "byNumber": {"1": "one", "2": "two"}, "byName": {"k": {"boolValue": true}},
+		"child": {"stringValue": "b", "child": {"int64Value": "2"}}, "children": [{"stringValue": "c"}],
+		"text": "choice"
+	}`
+	testCases := []struct {
+		description string
+		other       string
+		opts        []DiffOption
+		expected    []string
+	}{
+		{
+			description: "golden case: equal messages",
+			other:       base,
+		},
+		{
+			description: "golden case: scalars",
+			other: `{
+				"stringValue": "changed", "bytesValue": "AQM=", "color": "COLOR_UNSPECIFIED", "floatValue": "NaN", "boolValue": true,
+				"tags": ["x", "y"], "byNumber": {"1": "one", "2": "two"}, "byName": {"k": {"boolValue": true}},
+				"child": {"stringValue": "b", "child": {"int64Value": "2"}}, "children": [{"stringValue": "c"}],
+				"text": "choice"
+			}`,
+			expected: []string{
+				`+ bool_value: true`,
+				`- int32_value: 1`,
+				`~ string_value: "a" -> "changed"`,
+				`~ bytes_value: AQI= -> AQM=`,
+				`- color: COLOR_RED`,
+			},
+		},
+		{
+			description: "golden case: lists and maps",
+			other: `{
+				"stringValue": "a", "int32Value": 1, "bytesValue": "AQI=", "color": "COLOR_RED", "floatValue": "NaN",
+				"tags": ["x", "z", "w"], "byNumber": {"2": "zwei", "3": "three"}, "byName": {"k": {}, "l": {"stringValue": "new"}},
+				"child": {"stringValue": "b", "child": {"int64Value": "2"}},
+				"text": "choice"
+			}`,
+			expected: []string{
+				`~ tags[1]: "y" -> "z"`,
+				`+ tags[2]: "w"`,
+				`- children[0]: {"stringValue":"c"}`,
+				`- by_name["k"].bool_value: true`,
+				`+ by_name["l"]: {"stringValue":"new"}`,
+				`- by_number[1]: "one"`,
+				`~ by_number[2]: "two" -> "zwei"`,
+				`+ by_number[3]: "three"`,
+			},
+		},
+		{
+			description: "golden case: nested messages and oneofs",
+			other: `{
+				"stringValue": "a", "int32Value": 1, "bytesValue": "AQI=", "color": "COLOR_RED", "floatValue": "NaN",
+				"tags": ["x", "y"], "byNumber": {"1": "one", "2": "two"}, "byName": {"k": {"boolValue": true}},
+				"child": {"stringValue": "b", "child": {"int64Value": "3", "tags": ["t"]}}, "children": [{"stringValue": "c"}],
+				"nested": {"stringValue": "d"}
+			}`,
+			expected: []string{
+				`~ child.child.int64_value: 2 -> 3`,
+				`+ child.child.tags[0]: "t"`,
+				`- text: "choice"`,
+				`+ nested: {"stringValue":"d"}`,
+			},
+		},
+		{
+			description: "golden case: ignored paths",
+			other: `{
+				"stringValue": "changed", "int32Value": 1, "bytesValue": "AQI=", "color": "COLOR_RED", "floatValue": "NaN",
+				"tags": ["x", "z"], "byNumber": {"1": "eins", "2": "zwei"}, "byName": {"k": {"boolValue": true}},
+				"child": {"stringValue": "b", "child": {"int64Value": "3"}}, "children": [{"stringValue": "c"}],
+				"text": "choice"
+			}`,
+			opts:     []DiffOption{IgnorePaths("string_value", "tags[1]", "by_number[*]", "child.child")},
+			expected: nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			a, err := registry.NewMessageFromJSON(md, []byte(base))
+			require.NoError(t, err)
+			b, err := registry.NewMessageFromJSON(md, []byte(tc.other))
+			require.NoError(t, err)
+			var actual []string
+			for _, change := range Diff(a, b, tc.opts...) {
+				actual = append(actual, change.String())
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestDiff_Any(t *testing.T) {
+	badRequest := func(description string) *status.Status {
+		st, err := status.New(codes.InvalidArgument, "invalid request").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "email", Description: description}},
+		})
+		require.NoError(t, err)
+		return st
+	}
+
+	t.Log("testing: Any messages are compared by their contents")
+	changes := Diff(badRequest("invalid").Proto(), badRequest("missing").Proto())
+	assert.Equal(t, Changes{{
+		Kind: Changed,
+		Path: "details[0].field_violations[0].description",
+		Old:  `"invalid"`,
+		New:  `"missing"`,
+	}}, changes)
+	assert.Empty(t, Diff(badRequest("invalid").Proto(), badRequest("missing").Proto(), IgnorePaths("details[*].field_violations[*].description")))
+
+	t.Log("testing: Any messages of different types are compared by their fields")
+	other, err := anypb.New(durationpb.New(1))
+	require.NoError(t, err)
+	mismatched := badRequest("invalid").Proto()
+	mismatched.Details[0] = other
+	changes = Diff(badRequest("invalid").Proto(), mismatched)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "~ details[0].type_url: \"type.googleapis.com/google.rpc.BadRequest\" -> \"type.googleapis.com/google.protobuf.Duration\"", changes[0].String())
+	assert.Equal(t, "details[0].value", changes[1].Path)
+}
+
+func TestDiff_Unknown(t *testing.T) {
+	withUnknown := func(text string, number protowire.Number) *echo.EchoRequest {
+		req := &echo.EchoRequest{Message: "hello"}
+		var raw []byte
+		raw = protowire.AppendTag(raw, number, protowire.BytesType)
+		raw = protowire.AppendString(raw, text)
+		raw = protowire.AppendTag(raw, 101, protowire.VarintType)
+		raw = protowire.AppendVarint(raw, 7)
+		req.ProtoReflect().SetUnknown(raw)
+		return req
+	}
+
+	changes := Diff(withUnknown("a", 100), withUnknown("b", 100))
+	assert.Equal(t, `~ 100: "a" -> "b"`, changes.String())
+	changes = Diff(withUnknown("a", 100), withUnknown("a", 102))
+	assert.Equal(t, "- 100: \"a\"\n+ 102: \"a\"", changes.String())
+	assert.Empty(t, Diff(withUnknown("a", 100), withUnknown("b", 102), IgnoreUnknown()))
+	assert.Empty(t, Diff(withUnknown("a", 100), withUnknown("b", 100), IgnorePaths("100")))
+}
+
+func TestDiff_Messages(t *testing.T) {
+	req := &echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: "hi"}}
+
+	t.Log("testing: generated and dynamic messages of the same type are compared")
+	dynamic, err := NewRegistry(protoregistry.GlobalFiles).NewMessageFromJSON(dynamicDescriptor(t, req.ProtoReflect().Descriptor()), []byte(`{"message": "hello", "talk": {"text": "ho"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, `~ talk.text: "hi" -> "ho"`, Diff(req, dynamic).String())
+
+	t.Log("testing: messages of different types")
+	assert.Equal(t, "~ (message): grpc_playground.echo.EchoRequest -> grpc_playground.echo.EchoResponse", Diff(req, &echo.EchoResponse{}).String())
+
+	t.Log("testing: nil messages")
+	assert.Empty(t, Diff(nil, (*echo.EchoRequest)(nil)))
+	assert.Equal(t, `+ (message): {"message":"hello","talk":{"text":"hi"}}`, Diff(nil, req).String())
+	assert.Equal(t, `- (message): {"message":"hello","talk":{"text":"hi"}}`, Diff(req, (*echo.EchoRequest)(nil)).String())
+	assert.Empty(t, Diff(req, proto.Clone(req)))
+}
@@ -0,0 +1,66 @@
+package reflection
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const jsonIndent = "  "
+
+// NewMessageFromJSON builds a dynamic message of md from its JSON encoding.
+func (r *Registry) NewMessageFromJSON(md protoreflect.MessageDescriptor, data []byte) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(md)
+	if err := (protojson.UnmarshalOptions{Resolver: r}).Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", md.FullName(), err)
+	}
+	return msg, nil
+}
+
+// NewMessageFromAssignments builds a dynamic message of md from assignments of the form path=value, e.g.
+// talk.text=hello, with paths as described by SetField. Values are converted to the kind of the field:
+// enums by name or number, bytes from base64 and messages from JSON. Assignments to a repeated field append to it.
+func (r *Registry) NewMessageFromAssignments(md protoreflect.MessageDescriptor, assignments ...string) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(md)
+	for _, assignment := range assignments {
+		path, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return nil, fmt.Errorf("assignment %q is not of the form path=value", assignment)
+		}
+		if err := r.assign(msg, path, value); err != nil {
+			return nil, fmt.Errorf("assigning %s: %w", path, err)
+		}
+	}
+	return msg, nil
+}
+
+// assign sets the field at path of msg to value.
+func (r *Registry) assign(msg *dynamicpb.Message, path, value string) error {
+	a, err := AccessorFor(msg.Descriptor(), path)
+	if err != nil {
+		return err
+	}
+	return set(msg, a.steps, func(fd protoreflect.FieldDescriptor, empty protoreflect.Value) (protoreflect.Value, error) {
+		return parseValue(fd, empty, value, r)
+	})
+}
+
+// FormatJSON renders a message as indented JSON. protojson varies its whitespace on purpose,
+// the output is indented by encoding/json to keep it stable.
+func (r *Registry) FormatJSON(msg proto.Message) ([]byte, error) {
+	b, err := protojson.MarshalOptions{Resolver: r}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", jsonIndent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
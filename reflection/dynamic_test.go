@@ -0,0 +1,448 @@
+package reflection
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// echoDescriptorSet returns the descriptor set of echo.proto, with its imports when includeImports is set.
+func echoDescriptorSet(includeImports bool) *descriptorpb.FileDescriptorSet {
+	set := &descriptorpb.FileDescriptorSet{}
+	if includeImports {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(durationpb.File_google_protobuf_duration_proto))
+	}
+	set.File = append(set.File, protodesc.ToFileDescriptorProto(echo.File_echo_echo_proto))
+	return set
+}
+
+// writeSet writes the descriptor set to a file of dir named name, as JSON when it ends in .json.
+func writeSet(t *testing.T, dir, name string, set *descriptorpb.FileDescriptorSet) string {
+	var b []byte
+	var err error
+	if strings.HasSuffix(name, ".json") {
+		b, err = protojson.Marshal(set)
+	} else {
+		b, err = proto.Marshal(set)
+	}
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+	return path
+}
+
+func TestLoadFileDescriptorSet(t *testing.T) {
+	dir := t.TempDir()
+	missingImport := echoDescriptorSet(false)
+	missingImport.File[0].Dependency = append(missingImport.File[0].Dependency, "grpc_playground/missing.proto")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "garbage.bin"), []byte("not a descriptor set"), 0o600))
+	testCases := []struct {
+		description   string
+		path          string
+		expectedError string
+	}{
+		{
+			description: "golden case: binary set with imports",
+			path:        writeSet(t, dir, "image.bin", echoDescriptorSet(true)),
+		},
+		{
+			description: "golden case: JSON set",
+			path:        writeSet(t, dir, "image.json", echoDescriptorSet(true)),
+		},
+		{
+			description: "golden case: imports missing from the set are linked in",
+			path:        writeSet(t, dir, "exclude-imports.bin", echoDescriptorSet(false)),
+		},
+		{
+			description:   "failure case: import neither in the set nor linked in",
+			path:          writeSet(t, dir, "missing-import.bin", missingImport),
+			expectedError: "import grpc_playground/missing.proto is neither in the descriptor set nor linked in",
+		},
+		{
+			description:   "failure case: file is no descriptor set",
+			path:          filepath.Join(dir, "garbage.bin"),
+			expectedError: "decoding descriptor set",
+		},
+		{
+			description:   "failure case: file does not exist",
+			path:          filepath.Join(dir, "missing.bin"),
+			expectedError: "reading descriptor set",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			registry, err := LoadFileDescriptorSet(tc.path)
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			method, err := registry.FindMethod("grpc_playground.echo.EchoService/ServerStreamEcho")
+			require.NoError(t, err)
+			assert.True(t, method.IsStreamingServer())
+			assert.Equal(t, protoreflect.FullName("google.protobuf.Duration"), method.Input().Fields().ByName("interval").Message().FullName())
+		})
+	}
+}
+
+func TestRegistry_AddFiles(t *testing.T) {
+	var fetched []string
+	fetch := func(path string) ([]*descriptorpb.FileDescriptorProto, error) {
+		fetched = append(fetched, path)
+		fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(fd)}, nil
+	}
+	registry := NewRegistry(&protoregistry.Files{})
+
+	t.Log("testing: imports missing from the files are fetched")
+	require.NoError(t, registry.AddFiles(echoDescriptorSet(false).GetFile(), fetch))
+	assert.Contains(t, fetched, "google/protobuf/duration.proto")
+	method, err := registry.FindMethod("grpc_playground.echo.EchoService/ServerStreamEcho")
+	require.NoError(t, err)
+	assert.Equal(t, protoreflect.FullName("google.protobuf.Duration"), method.Input().Fields().ByName("interval").Message().FullName())
+
+	t.Log("testing: registered files are neither fetched nor added again")
+	fetched = nil
+	require.NoError(t, registry.AddFiles(echoDescriptorSet(true).GetFile(), fetch))
+	assert.Empty(t, fetched)
+
+	t.Log("testing: fetch errors are returned")
+	missingImport := echoDescriptorSet(false)
+	missingImport.File[0].Name = proto.String("grpc_playground/other.proto")
+	missingImport.File[0].Package = proto.String("grpc_playground.other")
+	missingImport.File[0].Dependency = append(missingImport.File[0].Dependency, "grpc_playground/missing.proto")
+	err = registry.AddFiles(missingImport.GetFile(), fetch)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resolving file grpc_playground/missing.proto")
+
+	t.Log("testing: import cycles fail")
+	cycle := []*descriptorpb.FileDescriptorProto{
+		{Name: proto.String("cycle/a.proto"), Dependency: []string{"cycle/b.proto"}},
+		{Name: proto.String("cycle/b.proto"), Dependency: []string{"cycle/a.proto"}},
+	}
+	err = NewRegistry(&protoregistry.Files{}).AddFiles(cycle, fetch)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "import cycle through cycle/a.proto")
+
+	t.Log("testing: files the fetch does not return fail")
+	err = NewRegistry(&protoregistry.Files{}).AddFiles(echoDescriptorSet(false).GetFile(),
+		func(string) ([]*descriptorpb.FileDescriptorProto, error) { return nil, nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "file google/protobuf/duration.proto was not returned")
+}
+
+func TestRegistry_FindMethod(t *testing.T) {
+	registry, err := NewRegistryFromSet(echoDescriptorSet(true))
+	require.NoError(t, err)
+	for _, name := range []string{
+		"grpc_playground.echo.EchoService/Echo",
+		"/grpc_playground.echo.EchoService/Echo",
+		"grpc_playground.echo.EchoService.Echo",
+	} {
+		method, err := registry.FindMethod(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, "/grpc_playground.echo.EchoService/Echo", FullMethod(method))
+	}
+	_, err = registry.FindMethod("grpc_playground.echo.EchoService/Unknown")
+	assert.Error(t, err)
+	_, err = registry.FindMethod("grpc_playground.echo.EchoRequest")
+	assert.EqualError(t, err, "grpc_playground.echo.EchoRequest is not a method")
+}
+
+func TestRegistry_NewMessage(t *testing.T) {
+	registry, err := NewRegistryFromSet(echoDescriptorSet(true))
+	require.NoError(t, err)
+	testCases := []struct {
+		description   string
+		message       string
+		json          string
+		assignments   []string
+		expected      proto.Message
+		expectedError string
+	}{
+		{
+			description: "golden case: message from JSON",
+			message:     "grpc_playground.echo.EchoRequest",
+			json:        `{"message": "hello", "talk": {"text": "world"}, "errorType": "ERROR_TYPE_HELP"}`,
+			expected:    &echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: "world"}, ErrorType: echo.ErrorType_ERROR_TYPE_HELP},
+		},
+		{
+			description: "golden case: message from assignments of nested fields and enum names",
+			message:     "grpc_playground.echo.EchoRequest",
+			assignments: []string{"message=hello", "talk.text=world", "errorType=ERROR_TYPE_HELP"},
+			expected:    &echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: "world"}, ErrorType: echo.ErrorType_ERROR_TYPE_HELP},
+		},
+		{
+			description: "golden case: assignments of numbers, JSON names and messages as JSON",
+			message:     "grpc_playground.echo.ServerStreamEchoRequest",
+			assignments: []string{"count=3", `request={"message": "hi"}`, "request.errorType=2", `interval="1.5s"`},
+			expected: &echo.ServerStreamEchoRequest{
+				Count:    3,
+				Interval: durationpb.New(1500 * time.Millisecond),
+				Request:  &echo.EchoRequest{Message: "hi", ErrorType: echo.ErrorType_ERROR_TYPE_BAD_REQUEST},
+			},
+		},
+		{
+			description: "golden case: assignment by proto name",
+			message:     "grpc_playground.echo.EchoResponse",
+			assignments: []string{"message_count=7"},
+			expected:    &echo.EchoResponse{MessageCount: 7},
+		},
+		{
+			description:   "failure case: unknown JSON field",
+			message:       "grpc_playground.echo.EchoRequest",
+			json:          `{"text": "hello"}`,
+			expectedError: "decoding grpc_playground.echo.EchoRequest",
+		},
+		{
+			description:   "failure case: assignment without value",
+			message:       "grpc_playground.echo.EchoRequest",
+			assignments:   []string{"message"},
+			expectedError: `assignment "message" is not of the form path=value`,
+		},
+		{
+			description:   "failure case: unknown field",
+			message:       "grpc_playground.echo.EchoRequest",
+			assignments:   []string{"talk.txt=hello"},
+			expectedError: "grpc_playground.echo.Message has no field txt",
+		},
+		{
+			description:   "failure case: path through scalar field",
+			message:       "grpc_playground.echo.EchoRequest",
+			assignments:   []string{"message.text=hello"},
+			expectedError: "grpc_playground.echo.EchoRequest.message is not a message",
+		},
+		{
+			description:   "failure case: value not of the field kind",
+			message:       "grpc_playground.echo.EchoResponse",
+			assignments:   []string{"message_count=many"},
+			expectedError: "assigning message_count",
+		},
+		{
+			description:   "failure case: unknown enum value",
+			message:       "grpc_playground.echo.EchoRequest",
+			assignments:   []string{"errorType=ERROR_TYPE_TEAPOT"},
+			expectedError: "grpc_playground.echo.ErrorType has no value ERROR_TYPE_TEAPOT",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			md, err := registry.FindMessage(tc.message)
+			require.NoError(t, err)
+			var msg *dynamicpb.Message
+			if tc.json != "" {
+				msg, err = registry.NewMessageFromJSON(md, []byte(tc.json))
+			} else {
+				msg, err = registry.NewMessageFromAssignments(md, tc.assignments...)
+			}
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			// the dynamic message converts to the generated one through its encoding
+			b, err := proto.Marshal(msg)
+			require.NoError(t, err)
+			actual := tc.expected.ProtoReflect().New().Interface()
+			require.NoError(t, proto.Unmarshal(b, actual))
+			assert.True(t, proto.Equal(tc.expected, actual), "expected %v, got %v", tc.expected, actual)
+		})
+	}
+}
+
+func TestRegistry_FormatJSON(t *testing.T) {
+	registry, err := NewRegistryFromSet(echoDescriptorSet(true))
+	require.NoError(t, err)
+
+	t.Log("testing: dynamic messages are rendered as indented JSON")
+	md, err := registry.FindMessage("grpc_playground.echo.ServerStreamEchoRequest")
+	require.NoError(t, err)
+	msg, err := registry.NewMessageFromAssignments(md, "request.message=hi", "count=2", `interval="0.250s"`)
+	require.NoError(t, err)
+	out, err := registry.FormatJSON(msg)
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"request\": {\n    \"message\": \"hi\"\n  },\n  \"count\": 2,\n  \"interval\": \"0.250s\"\n}", string(out))
+
+	t.Log("testing: Any payloads are resolved by the registry")
+	payload, err := anypb.New(&echo.Message{Text: "inside"})
+	require.NoError(t, err)
+	out, err = registry.FormatJSON(payload)
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"@type\": \"type.googleapis.com/grpc_playground.echo.Message\",\n  \"text\": \"inside\"\n}", string(out))
+	anyMD := payload.ProtoReflect().Descriptor()
+	decoded, err := registry.NewMessageFromJSON(anyMD, out)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(payload, toAny(t, decoded)))
+
+	t.Log("testing: Any payloads of unknown types cannot be rendered")
+	unknown := &anypb.Any{TypeUrl: "type.googleapis.com/grpc_playground.Unknown"}
+	_, err = registry.FormatJSON(unknown)
+	assert.Error(t, err)
+}
+
+// toAny converts a dynamic Any message to the generated one.
+func toAny(t *testing.T, msg proto.Message) *anypb.Any {
+	b, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	a := &anypb.Any{}
+	require.NoError(t, proto.Unmarshal(b, a))
+	return a
+}
+
+// echoServer echoes requests, failing those without message.
+type echoServer struct {
+	echo.UnimplementedEchoServiceServer
+}
+
+func (echoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	if req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "message is empty")
+	}
+	return &echo.EchoResponse{Response: req.Message + req.GetTalk().GetText(), MessageCount: 1}, nil
+}
+
+func (echoServer) ClientStreamEcho(stream echo.EchoService_ClientStreamEchoServer) error {
+	var messages []string
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&echo.EchoResponse{Response: strings.Join(messages, " "), MessageCount: int32(len(messages))})
+		} else if err != nil {
+			return err
+		}
+		messages = append(messages, req.Message)
+	}
+}
+
+func (echoServer) BidiStreamEcho(stream echo.EchoService_BidiStreamEchoServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.Send(&echo.EchoResponse{Response: req.Message, MessageCount: 1}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	echo.RegisterEchoServiceServer(server, echoServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	defer server.Stop()
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	registry, err := NewRegistryFromSet(echoDescriptorSet(true))
+	require.NoError(t, err)
+	unary, err := registry.FindMethod("grpc_playground.echo.EchoService/Echo")
+	require.NoError(t, err)
+	clientStream, err := registry.FindMethod("grpc_playground.echo.EchoService/ClientStreamEcho")
+	require.NoError(t, err)
+	bidi, err := registry.FindMethod("grpc_playground.echo.EchoService/BidiStreamEcho")
+	require.NoError(t, err)
+
+	t.Log("testing: unary call with a dynamic request")
+	req, err := registry.NewMessageFromAssignments(unary.Input(), "message=hello", "talk.text= world")
+	require.NoError(t, err)
+	resp, err := Invoke(ctx, conn, unary, req)
+	require.NoError(t, err)
+	out, err := registry.FormatJSON(resp)
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"response\": \"hello world\",\n  \"messageCount\": 1\n}", string(out))
+
+	t.Log("testing: status of failed calls is returned")
+	_, err = Invoke(ctx, conn, unary, dynamicpb.NewMessage(unary.Input()))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	t.Log("testing: streaming methods cannot be invoked as unary")
+	_, err = Invoke(ctx, conn, clientStream, req)
+	assert.EqualError(t, err, "grpc_playground.echo.EchoService.ClientStreamEcho is a streaming method")
+
+	t.Log("testing: client streaming call with several requests")
+	var requests []proto.Message
+	for _, message := range []string{"one", "two", "three"} {
+		req, err := registry.NewMessageFromAssignments(clientStream.Input(), "message="+message)
+		require.NoError(t, err)
+		requests = append(requests, req)
+	}
+	var responses []*dynamicpb.Message
+	err = InvokeStream(ctx, conn, clientStream, requests, func(resp *dynamicpb.Message) error {
+		responses = append(responses, resp)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	assert.Equal(t, "one two three", responses[0].Get(clientStream.Output().Fields().ByName("response")).String())
+
+	t.Log("testing: bidi responses beyond the flow control window are received while sending")
+	var bidiRequests []proto.Message
+	large, err := registry.NewMessageFromAssignments(bidi.Input(), "message="+strings.Repeat("x", 1024))
+	require.NoError(t, err)
+	for i := 0; i < 2048; i++ {
+		bidiRequests = append(bidiRequests, large)
+	}
+	received := 0
+	err = InvokeStream(ctx, conn, bidi, bidiRequests, func(*dynamicpb.Message) error {
+		received++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(bidiRequests), received)
+
+	t.Log("testing: errors of out end the call")
+	stop := errors.New("stop")
+	err = InvokeStream(ctx, conn, bidi, bidiRequests, func(*dynamicpb.Message) error { return stop })
+	assert.ErrorIs(t, err, stop)
+
+	t.Log("testing: unary methods take a single request")
+	err = InvokeStream(ctx, conn, unary, requests, func(*dynamicpb.Message) error { return nil })
+	assert.EqualError(t, err, "grpc_playground.echo.EchoService.Echo takes a single request, got 3")
+
+	t.Log("testing: pre-encoded requests and raw responses pass the codec as they are")
+	encoded, err := proto.Marshal(&echo.EchoRequest{Message: "raw"})
+	require.NoError(t, err)
+	var raw []byte
+	require.NoError(t, conn.Invoke(ctx, FullMethod(unary), encoded, &raw, grpc.ForceCodec(Codec)))
+	decoded := &echo.EchoResponse{}
+	require.NoError(t, proto.Unmarshal(raw, decoded))
+	assert.Equal(t, "raw", decoded.Response)
+}
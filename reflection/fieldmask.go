@@ -0,0 +1,162 @@
+package reflection
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// maskTree is a field mask resolved against a message descriptor. Fields without subtree are masked as a whole,
+// fields with a subtree only in the masked fields of their message.
+type maskTree map[protoreflect.FieldDescriptor]maskTree
+
+// ValidateFieldMask checks that every path of mask names a field of md. Paths are dot-separated proto field
+// names, e.g. talk.text; all but their last field must be singular messages, as field masks cannot reach
+// into repeated fields or maps.
+func ValidateFieldMask(md protoreflect.MessageDescriptor, mask *fieldmaskpb.FieldMask) error {
+	_, err := compileFieldMask(md, mask)
+	return err
+}
+
+// compileFieldMask resolves the paths of mask into a tree. A path covering another, e.g. talk and talk.text,
+// masks the field as a whole.
+func compileFieldMask(md protoreflect.MessageDescriptor, mask *fieldmaskpb.FieldMask) (maskTree, error) {
+	tree := maskTree{}
+	for _, path := range mask.GetPaths() {
+		node, current := tree, md
+		names := strings.Split(path, ".")
+		for i, name := range names {
+			if name == "" {
+				return nil, fmt.Errorf("%w: %q has an empty field name", ErrInvalidPath, path)
+			}
+			fd := current.Fields().ByName(protoreflect.Name(name))
+			if fd == nil {
+				return nil, fmt.Errorf("%w: %s has no field %s", ErrFieldNotFound, current.FullName(), name)
+			}
+			last := i == len(names)-1
+			if !last && (fd.Message() == nil || fd.IsList() || fd.IsMap()) {
+				return nil, fmt.Errorf("%w: %s is not a singular message, %q cannot reach into it", ErrInvalidPath, fd.FullName(), path)
+			}
+			sub, seen := node[fd]
+			switch {
+			case seen && sub == nil:
+				// the field is already masked as a whole, deeper paths add nothing.
+			case last:
+				node[fd] = nil
+			case !seen:
+				node[fd] = maskTree{}
+			}
+			if last || node[fd] == nil {
+				break
+			}
+			node, current = node[fd], fd.Message()
+		}
+	}
+	return tree, nil
+}
+
+// PruneFieldMask clears every field of msg not covered by mask, in nested messages as well. A nil or empty mask,
+// the default of optional read masks, keeps every field.
+func PruneFieldMask(msg proto.Message, mask *fieldmaskpb.FieldMask) error {
+	m := msg.ProtoReflect()
+	tree, err := compileFieldMask(m.Descriptor(), mask)
+	if err != nil {
+		return err
+	}
+	if len(tree) > 0 {
+		prune(m, tree)
+	}
+	return nil
+}
+
+func prune(m protoreflect.Message, tree maskTree) {
+	// fields are cleared after ranging over them, changing a message while ranging over it is undefined.
+	var cleared []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		sub, masked := tree[fd]
+		switch {
+		case !masked:
+			cleared = append(cleared, fd)
+		case sub != nil:
+			prune(v.Message(), sub)
+		}
+		return true
+	})
+	for _, fd := range cleared {
+		m.Clear(fd)
+	}
+}
+
+// MergeFieldMask replaces the fields of dst covered by mask with those of src, as an update mask does: fields
+// unset in src are cleared in dst, repeated fields and maps are replaced rather than appended to.
+// Values are copied, dst shares no memory with src. A nil or empty mask merges every field.
+// dst and src must be messages of the same type.
+func MergeFieldMask(dst, src proto.Message, mask *fieldmaskpb.FieldMask) error {
+	d, s := dst.ProtoReflect(), src.ProtoReflect()
+	if d.Descriptor() != s.Descriptor() {
+		return fmt.Errorf("%w: merging %s into %s", ErrWrongMessage, s.Descriptor().FullName(), d.Descriptor().FullName())
+	}
+	tree, err := compileFieldMask(d.Descriptor(), mask)
+	if err != nil {
+		return err
+	}
+	if len(tree) == 0 {
+		proto.Merge(dst, src)
+		return nil
+	}
+	merge(d, s, tree)
+	return nil
+}
+
+func merge(dst, src protoreflect.Message, tree maskTree) {
+	for fd, sub := range tree {
+		switch {
+		case sub != nil:
+			if !src.Has(fd) && !dst.Has(fd) {
+				continue
+			}
+			merge(dst.Mutable(fd).Message(), src.Get(fd).Message(), sub)
+		case src.Has(fd):
+			dst.Set(fd, copyValue(dst, fd, src.Get(fd)))
+		default:
+			dst.Clear(fd)
+		}
+	}
+}
+
+// copyValue deep copies the value v of the field fd into a value for m.
+func copyValue(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) protoreflect.Value {
+	switch {
+	case fd.IsList():
+		list := m.NewField(fd).List()
+		src := v.List()
+		for i := 0; i < src.Len(); i++ {
+			list.Append(copyScalar(src.Get(i)))
+		}
+		return protoreflect.ValueOfList(list)
+	case fd.IsMap():
+		entries := m.NewField(fd).Map()
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			entries.Set(k, copyScalar(v))
+			return true
+		})
+		return protoreflect.ValueOfMap(entries)
+	default:
+		return copyScalar(v)
+	}
+}
+
+// copyScalar deep copies a singular value, messages and bytes are cloned.
+func copyScalar(v protoreflect.Value) protoreflect.Value {
+	switch value := v.Interface().(type) {
+	case protoreflect.Message:
+		return protoreflect.ValueOfMessage(proto.Clone(value.Interface()).ProtoReflect())
+	case []byte:
+		return protoreflect.ValueOfBytes(append([]byte(nil), value...))
+	default:
+		return v
+	}
+}
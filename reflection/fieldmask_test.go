@@ -0,0 +1,184 @@
+package reflection
+
+import (
+	"testing"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestValidateFieldMask(t *testing.T) {
+	md := kindsDescriptor(t)
+	testCases := []struct {
+		description string
+		paths       []string
+		expectedErr error
+	}{
+		{description: "golden case: no paths", paths: nil},
+		{description: "golden case: top level fields", paths: []string{"string_value", "tags", "by_name", "text"}},
+		{description: "golden case: nested fields", paths: []string{"child.child.string_value", "child", "nested.color"}},
+		{description: "failure case: unknown field", paths: []string{"string_value", "unknown"}, expectedErr: ErrFieldNotFound},
+		{description: "failure case: unknown nested field", paths: []string{"child.unknown"}, expectedErr: ErrFieldNotFound},
+		{description: "failure case: json name", paths: []string{"stringValue"}, expectedErr: ErrFieldNotFound},
+		{description: "failure case: empty path", paths: []string{""}, expectedErr: ErrInvalidPath},
+		{description: "failure case: empty field name", paths: []string{"child..text"}, expectedErr: ErrInvalidPath},
+		{description: "failure case: through a scalar", paths: []string{"string_value.text"}, expectedErr: ErrInvalidPath},
+		{description: "failure case: through a repeated field", paths: []string{"children.text"}, expectedErr: ErrInvalidPath},
+		{description: "failure case: through a map", paths: []string{"by_name.text"}, expectedErr: ErrInvalidPath},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := ValidateFieldMask(md, &fieldmaskpb.FieldMask{Paths: tc.paths})
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPruneFieldMask(t *testing.T) {
+	md := kindsDescriptor(t)
+	registry := NewRegistry(protoregistry.GlobalFiles)
+	const input = `{
+		"stringValue": "a", "int32Value": 1, "tags": ["x"], "byName": {"k": {"stringValue": "v"}},
+		"child": {"stringValue": "b", "boolValue": true, "child": {"stringValue": "c", "int32Value": 2}},
+		"nested": {"stringValue": "d"}
+	}`
+	testCases := []struct {
+		description string
+		paths       []string
+		expected    string
+	}{
+		{
+			description: "golden case: no paths keep every field",
+			expected:    input,
+		},
+		{
+			description: "golden case: top level fields",
+			paths:       []string{"string_value", "tags", "by_name"},
+			expected:    `{"stringValue": "a", "tags": ["x"], "byName": {"k": {"stringValue": "v"}}}`,
+		},
+		{
+			description: "golden case: nested fields",
+			paths:       []string{"child.child.int32_value", "child.bool_value", "nested.string_value"},
+			expected:    `{"child": {"boolValue": true, "child": {"int32Value": 2}}, "nested": {"stringValue": "d"}}`,
+		},
+		{
+			description: "golden case: whole messages cover their fields",
+			paths:       []string{"child.child.int32_value", "child"},
+			expected:    `{"child": {"stringValue": "b", "boolValue": true, "child": {"stringValue": "c", "int32Value": 2}}}`,
+		},
+		{
+			description: "golden case: unset fields stay unset",
+			paths:       []string{"double_value", "children", "text"},
+			expected:    `{}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			msg, err := registry.NewMessageFromJSON(md, []byte(input))
+			require.NoError(t, err)
+			require.NoError(t, PruneFieldMask(msg, &fieldmaskpb.FieldMask{Paths: tc.paths}))
+			actual, err := registry.FormatJSON(msg)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(actual))
+		})
+	}
+
+	t.Log("testing: invalid masks leave the message as it is")
+	msg := &echo.EchoResponse{Response: "hello", MessageCount: 1}
+	assert.ErrorIs(t, PruneFieldMask(msg, &fieldmaskpb.FieldMask{Paths: []string{"unknown"}}), ErrFieldNotFound)
+	assert.Equal(t, "hello", msg.Response)
+	assert.Equal(t, int32(1), msg.MessageCount)
+}
+
+func TestMergeFieldMask(t *testing.T) {
+	md := kindsDescriptor(t)
+	registry := NewRegistry(protoregistry.GlobalFiles)
+	const dst = `{
+		"stringValue": "old", "int32Value": 1, "tags": ["old"], "byNumber": {"1": "old"},
+		"child": {"stringValue": "old child", "boolValue": true}
+	}`
+	const src = `{
+		"stringValue": "new", "tags": ["new", "newer"], "byNumber": {"2": "new"}, "bytesValue": "AQI=",
+		"child": {"stringValue": "new child"}, "children": [{"stringValue": "new"}]
+	}`
+	testCases := []struct {
+		description string
+		paths       []string
+		expected    string
+	}{
+		{
+			description: "golden case: no paths merge every field",
+			expected: `{
+				"stringValue": "new", "int32Value": 1, "tags": ["old", "new", "newer"], "byNumber": {"1": "old", "2": "new"}, "bytesValue": "AQI=",
+				"child": {"stringValue": "new child", "boolValue": true}, "children": [{"stringValue": "new"}]
+			}`,
+		},
+		{
+			description: "golden case: masked fields are replaced",
+			paths:       []string{"string_value", "tags", "by_number", "bytes_value"},
+			expected: `{
+				"stringValue": "new", "int32Value": 1, "tags": ["new", "newer"], "byNumber": {"2": "new"}, "bytesValue": "AQI=",
+				"child": {"stringValue": "old child", "boolValue": true}
+			}`,
+		},
+		{
+			description: "golden case: fields unset in the source are cleared",
+			paths:       []string{"int32_value", "child.bool_value", "nested.string_value"},
+			expected:    `{"stringValue": "old", "tags": ["old"], "byNumber": {"1": "old"}, "child": {"stringValue": "old child"}}`,
+		},
+		{
+			description: "golden case: nested fields",
+			paths:       []string{"child.string_value", "children"},
+			expected: `{
+				"stringValue": "old", "int32Value": 1, "tags": ["old"], "byNumber": {"1": "old"},
+				"child": {"stringValue": "new child", "boolValue": true}, "children": [{"stringValue": "new"}]
+			}`,
+		},
+		{
+			description: "golden case: whole messages are replaced",
+			paths:       []string{"child"},
+			expected: `{
+				"stringValue": "old", "int32Value": 1, "tags": ["old"], "byNumber": {"1": "old"},
+				"child": {"stringValue": "new child"}
+			}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			d, err := registry.NewMessageFromJSON(md, []byte(dst))
+			require.NoError(t, err)
+			s, err := registry.NewMessageFromJSON(md, []byte(src))
+			require.NoError(t, err)
+			original := proto.Clone(s)
+
+			require.NoError(t, MergeFieldMask(d, s, &fieldmaskpb.FieldMask{Paths: tc.paths}))
+			actual, err := registry.FormatJSON(d)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(actual))
+
+			t.Log("testing: the merged values are copies")
+			assert.True(t, proto.Equal(original, s), "the source is not modified")
+			for _, path := range []string{"child.string_value", "children[0].string_value", "tags[0]"} {
+				require.NoError(t, SetByProtoReflection(s, path, "changed"))
+			}
+			s.Get(md.Fields().ByName("bytes_value")).Bytes()[0] = 9
+			again, err := registry.FormatJSON(d)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(actual), string(again))
+		})
+	}
+
+	t.Log("testing: messages of different types are not merged")
+	err := MergeFieldMask(&echo.EchoResponse{}, &echo.EchoRequest{}, nil)
+	assert.ErrorIs(t, err, ErrWrongMessage)
+	err = MergeFieldMask(&echo.EchoResponse{}, &echo.EchoResponse{}, &fieldmaskpb.FieldMask{Paths: []string{"talk.unknown"}})
+	assert.ErrorIs(t, err, ErrFieldNotFound)
+}
@@ -0,0 +1,352 @@
+package reflection
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// typeResolver resolves the types of Any payloads and extensions when decoding JSON values.
+type typeResolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
+
+// segment is a segment of a field path: a field or oneof name, optionally followed by a list index or map key in brackets.
+type segment struct {
+	name   string
+	sub    string
+	hasSub bool
+}
+
+// parsePath splits a field path such as talk.text, values[2].text or labels["a.b"] into its segments.
+func parsePath(path string) ([]segment, error) {
+	var segments []segment
+	i := 0
+	for {
+		start := i
+		for i < len(path) && path[i] != '.' && path[i] != '[' {
+			i++
+		}
+		seg := segment{name: path[start:i]}
+		if seg.name == "" {
+			return nil, fmt.Errorf("%w: %q has an empty field name", ErrInvalidPath, path)
+		}
+		if i < len(path) && path[i] == '[' {
+			rest := path[i+1:]
+			// consumed counts the bytes of the key and the closing bracket
+			var consumed int
+			if strings.HasPrefix(rest, `"`) {
+				// quoted map keys may contain dots and brackets
+				end := strings.Index(rest[1:], `"]`)
+				if end < 0 {
+					return nil, fmt.Errorf("%w: %q has an unterminated bracket", ErrInvalidPath, path)
+				}
+				seg.sub, consumed = rest[1:1+end], end+3
+			} else {
+				end := strings.IndexByte(rest, ']')
+				if end < 0 {
+					return nil, fmt.Errorf("%w: %q has an unterminated bracket", ErrInvalidPath, path)
+				}
+				seg.sub, consumed = rest[:end], end+1
+			}
+			seg.hasSub = true
+			i += 1 + consumed
+		}
+		segments = append(segments, seg)
+		if i == len(path) {
+			return segments, nil
+		}
+		if path[i] != '.' {
+			return nil, fmt.Errorf("%w: %q has no dot after a bracket", ErrInvalidPath, path)
+		}
+		i++
+	}
+}
+
+// step resolves a segment of a field path in a message.
+type step struct {
+	field protoreflect.FieldDescriptor
+	// oneof is set instead of field for a path ending in the name of a oneof, resolving to its populated field.
+	oneof protoreflect.OneofDescriptor
+	// index of the list element, -1 for none
+	index int
+	key   protoreflect.MapKey
+	keyed bool
+}
+
+// valueField returns the field describing the values the step resolves to.
+func (s step) valueField() protoreflect.FieldDescriptor {
+	if s.keyed {
+		return s.field.MapValue()
+	}
+	return s.field
+}
+
+// compilePath resolves the segments of a field path against the message descriptor md.
+// Fields are named by their proto or JSON name.
+func compilePath(md protoreflect.MessageDescriptor, path string) ([]step, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]step, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		fd := md.Fields().ByName(protoreflect.Name(seg.name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(seg.name)
+		}
+		if fd == nil {
+			if od := md.Oneofs().ByName(protoreflect.Name(seg.name)); od != nil && last && !seg.hasSub {
+				return append(steps, step{oneof: od, index: -1}), nil
+			}
+			return nil, fmt.Errorf("%w: %s has no field %s", ErrFieldNotFound, md.FullName(), seg.name)
+		}
+		s := step{field: fd, index: -1}
+		if seg.hasSub {
+			switch {
+			case fd.IsList():
+				index, err := strconv.Atoi(seg.sub)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("%w: %q is no index of %s", ErrInvalidPath, seg.sub, fd.FullName())
+				}
+				s.index = index
+			case fd.IsMap():
+				key, err := parseScalar(fd.MapKey(), seg.sub)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %q is no key of %s", ErrInvalidPath, seg.sub, fd.FullName())
+				}
+				s.key, s.keyed = key.MapKey(), true
+			default:
+				return nil, fmt.Errorf("%w: %s is neither repeated nor a map", ErrInvalidPath, fd.FullName())
+			}
+		}
+		steps = append(steps, s)
+		if last {
+			break
+		}
+		next := s.valueField()
+		if next.Message() == nil || (!seg.hasSub && (next.IsList() || next.IsMap())) {
+			return nil, fmt.Errorf("%w: %s is not a message", ErrInvalidPath, next.FullName())
+		}
+		md = next.Message()
+	}
+	return steps, nil
+}
+
+// get returns the value the steps resolve to in msg, the field describing it and whether it is set.
+func get(msg protoreflect.Message, steps []step) (protoreflect.Value, protoreflect.FieldDescriptor, bool) {
+	for i, s := range steps {
+		if s.oneof != nil {
+			fd := msg.WhichOneof(s.oneof)
+			if fd == nil {
+				return protoreflect.Value{}, nil, false
+			}
+			return msg.Get(fd), fd, true
+		}
+		if !msg.Has(s.field) {
+			return protoreflect.Value{}, nil, false
+		}
+		v := msg.Get(s.field)
+		switch {
+		case s.index >= 0:
+			list := v.List()
+			if s.index >= list.Len() {
+				return protoreflect.Value{}, nil, false
+			}
+			v = list.Get(s.index)
+		case s.keyed:
+			m := v.Map()
+			if !m.Has(s.key) {
+				return protoreflect.Value{}, nil, false
+			}
+			v = m.Get(s.key)
+		}
+		if i == len(steps)-1 {
+			return v, s.valueField(), true
+		}
+		msg = v.Message()
+	}
+	return protoreflect.Value{}, nil, false
+}
+
+// convertFunc returns the value to set to a field, given an empty value of the field to decode messages into.
+type convertFunc func(fd protoreflect.FieldDescriptor, empty protoreflect.Value) (protoreflect.Value, error)
+
+// set sets the value the steps resolve to in msg, populating the messages and map entries along the path.
+// Lists are not grown along the path, but the last step may append to a list by indexing its length
+// or by naming the list without index.
+func set(msg protoreflect.Message, steps []step, convert convertFunc) error {
+	for _, s := range steps[:len(steps)-1] {
+		switch {
+		case s.index >= 0:
+			list := msg.Mutable(s.field).List()
+			if s.index >= list.Len() {
+				return fmt.Errorf("%w: %s has %d elements, not %d", ErrIndexOutOfRange, s.field.FullName(), list.Len(), s.index+1)
+			}
+			msg = list.Get(s.index).Message()
+		case s.keyed:
+			msg = msg.Mutable(s.field).Map().Mutable(s.key).Message()
+		default:
+			msg = msg.Mutable(s.field).Message()
+		}
+	}
+	s := steps[len(steps)-1]
+	if s.oneof != nil {
+		return fmt.Errorf("%w: oneof %s cannot be set, name one of its fields", ErrInvalidPath, s.oneof.FullName())
+	}
+	switch {
+	case s.field.IsList():
+		list := msg.Mutable(s.field).List()
+		if s.index > list.Len() {
+			return fmt.Errorf("%w: %s has %d elements, not %d", ErrIndexOutOfRange, s.field.FullName(), list.Len(), s.index+1)
+		}
+		v, err := convert(s.field, list.NewElement())
+		if err != nil {
+			return err
+		}
+		if s.index < 0 || s.index == list.Len() {
+			list.Append(v)
+		} else {
+			list.Set(s.index, v)
+		}
+	case s.field.IsMap():
+		if !s.keyed {
+			return fmt.Errorf("%w: map %s cannot be set without key", ErrInvalidPath, s.field.FullName())
+		}
+		m := msg.Mutable(s.field).Map()
+		v, err := convert(s.field.MapValue(), m.NewValue())
+		if err != nil {
+			return err
+		}
+		m.Set(s.key, v)
+	default:
+		v, err := convert(s.field, msg.NewField(s.field))
+		if err != nil {
+			return err
+		}
+		msg.Set(s.field, v)
+	}
+	return nil
+}
+
+// GetField returns the value of the field at path in msg and whether it is set. A path names fields by their
+// proto or JSON name, dotted for fields of nested messages, e.g. talk.text. List elements are selected by index,
+// e.g. values[0], and map values by key, e.g. labels[env] or labels["a.b"] for keys with dots or brackets.
+// A path may end in the name of a oneof, resolving to whichever of its fields is set.
+// The path is resolved once per message type, see AccessorFor.
+func GetField(msg proto.Message, path string) (protoreflect.Value, bool, error) {
+	a, err := AccessorFor(msg.ProtoReflect().Descriptor(), path)
+	if err != nil {
+		return protoreflect.Value{}, false, err
+	}
+	v, found := a.Get(msg)
+	return v, found, nil
+}
+
+// SetField sets the field at path in msg to value, populating the messages along the path. A list index may be
+// the length of the list, or left out, to append to it. Setting a field of a oneof clears its other fields.
+func SetField(msg proto.Message, path string, value protoreflect.Value) error {
+	a, err := AccessorFor(msg.ProtoReflect().Descriptor(), path)
+	if err != nil {
+		return err
+	}
+	return a.Set(msg, value)
+}
+
+// setValue returns a convertFunc setting value as it is.
+func setValue(value protoreflect.Value) convertFunc {
+	return func(protoreflect.FieldDescriptor, protoreflect.Value) (protoreflect.Value, error) {
+		return value, nil
+	}
+}
+
+// formatValue formats a value of the field fd as string: enums by name, bytes as base64 and messages as JSON.
+// Whole lists and maps cannot be formatted.
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (string, bool) {
+	switch v := v.Interface().(type) {
+	case protoreflect.List, protoreflect.Map:
+		return "", false
+	case protoreflect.Message:
+		b, err := protojson.Marshal(v.Interface())
+		return string(b), err == nil
+	}
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), true
+		}
+		return strconv.Itoa(int(v.Enum())), true
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes()), true
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), true
+	default:
+		return v.String(), true
+	}
+}
+
+// parseValue converts s to a value of the field fd, decoding messages from JSON into empty.
+func parseValue(fd protoreflect.FieldDescriptor, empty protoreflect.Value, s string, resolver typeResolver) (protoreflect.Value, error) {
+	if fd.Message() != nil {
+		if err := (protojson.UnmarshalOptions{Resolver: resolver}).Unmarshal([]byte(s), empty.Message().Interface()); err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%w: decoding %s: %v", ErrInvalidValue, fd.Message().FullName(), err)
+		}
+		return empty, nil
+	}
+	v, err := parseScalar(fd, s)
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("%w: %q for %s: %v", ErrInvalidValue, s, fd.FullName(), err)
+	}
+	return v, nil
+}
+
+// parseScalar converts s to a value of the kind of the non message field fd.
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(i), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		u, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(u)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		u, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(u), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(s)
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		i, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%s has no value %s", fd.Enum().FullName(), s)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("field %s of kind %s cannot be parsed", fd.FullName(), fd.Kind())
+	}
+}
@@ -0,0 +1,239 @@
+package reflection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// kindsProto declares a field of every kind, besides repeated, map and oneof fields.
+const kindsProto = `
+name: "reflection/kinds.proto"
+package: "grpc_playground.reflection"
+syntax: "proto3"
+enum_type { name: "Color" value { name: "COLOR_UNSPECIFIED" number: 0 } value { name: "COLOR_RED" number: 1 } }
+message_type {
+  name: "Kinds"
+  field { name: "bool_value" number: 1 label: LABEL_OPTIONAL type: TYPE_BOOL }
+  field { name: "int32_value" number: 2 label: LABEL_OPTIONAL type: TYPE_INT32 }
+  field { name: "sint32_value" number: 3 label: LABEL_OPTIONAL type: TYPE_SINT32 }
+  field { name: "sfixed32_value" number: 4 label: LABEL_OPTIONAL type: TYPE_SFIXED32 }
+  field { name: "int64_value" number: 5 label: LABEL_OPTIONAL type: TYPE_INT64 }
+  field { name: "sint64_value" number: 6 label: LABEL_OPTIONAL type: TYPE_SINT64 }
+  field { name: "sfixed64_value" number: 7 label: LABEL_OPTIONAL type: TYPE_SFIXED64 }
+  field { name: "uint32_value" number: 8 label: LABEL_OPTIONAL type: TYPE_UINT32 }
+  field { name: "fixed32_value" number: 9 label: LABEL_OPTIONAL type: TYPE_FIXED32 }
+  field { name: "uint64_value" number: 10 label: LABEL_OPTIONAL type: TYPE_UINT64 }
+  field { name: "fixed64_value" number: 11 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+  field { name: "float_value" number: 12 label: LABEL_OPTIONAL type: TYPE_FLOAT }
+  field { name: "double_value" number: 13 label: LABEL_OPTIONAL type: TYPE_DOUBLE }
+  field { name: "string_value" number: 14 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "bytes_value" number: 15 label: LABEL_OPTIONAL type: TYPE_BYTES }
+  field { name: "color" number: 16 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".grpc_playground.reflection.Color" }
+  field { name: "child" number: 17 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Kinds" }
+  field { name: "tags" number: 18 label: LABEL_REPEATED type: TYPE_STRING }
+  field { name: "children" number: 19 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Kinds" }
+  field { name: "by_name" number: 20 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Kinds.ByNameEntry" }
+  field { name: "by_number" number: 21 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Kinds.ByNumberEntry" }
+  field { name: "text" number: 22 label: LABEL_OPTIONAL type: TYPE_STRING oneof_index: 0 }
+  field { name: "nested" number: 23 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Kinds" oneof_index: 0 }
+  nested_type {
+    name: "ByNameEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Kinds" }
+    options { map_entry: true }
+  }
+  nested_type {
+    name: "ByNumberEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_INT32 }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+    options { map_entry: true }
+  }
+  oneof_decl { name: "choice" }
+}
+`
+
+// kindsDescriptor returns the descriptor of the Kinds message of kindsProto.
+func kindsDescriptor(t testing.TB) protoreflect.MessageDescriptor {
+	fdp := &descriptorpb.FileDescriptorProto{}
+	require.NoError(t, prototext.Unmarshal([]byte(kindsProto), fdp))
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+	return fd.Messages().ByName("Kinds")
+}
+
+func TestFieldPath_SetAndExtract(t *testing.T) {
+	md := kindsDescriptor(t)
+	testCases := []struct {
+		description string
+		path        string
+		value       string
+		expected    string
+	}{
+		{description: "bool", path: "bool_value", value: "true", expected: "true"},
+		{description: "int32", path: "int32_value", value: "-42", expected: "-42"},
+		{description: "sint32", path: "sint32_value", value: "-42", expected: "-42"},
+		{description: "sfixed32", path: "sfixed32_value", value: "-42", expected: "-42"},
+		{description: "int64", path: "int64_value", value: "-9000000000", expected: "-9000000000"},
+		{description: "sint64", path: "sint64_value", value: "-9000000000", expected: "-9000000000"},
+		{description: "sfixed64", path: "sfixed64_value", value: "-9000000000", expected: "-9000000000"},
+		{description: "uint32", path: "uint32_value", value: "4000000000", expected: "4000000000"},
+		{description: "fixed32", path: "fixed32_value", value: "4000000000", expected: "4000000000"},
+		{description: "uint64", path: "uint64_value", value: "18000000000000000000", expected: "18000000000000000000"},
+		{description: "fixed64", path: "fixed64_value", value: "18000000000000000000", expected: "18000000000000000000"},
+		{description: "float", path: "float_value", value: "1.1", expected: "1.1"},
+		{description: "double", path: "double_value", value: "2.25", expected: "2.25"},
+		{description: "string", path: "string_value", value: "hello", expected: "hello"},
+		{description: "bytes from base64", path: "bytes_value", value: "aGVsbG8=", expected: "aGVsbG8="},
+		{description: "enum by name", path: "color", value: "COLOR_RED", expected: "COLOR_RED"},
+		{description: "enum by number", path: "color", value: "1", expected: "COLOR_RED"},
+		{description: "field by JSON name", path: "int32Value", value: "7", expected: "7"},
+		{description: "nested field", path: "child.child.string_value", value: "deep", expected: "deep"},
+		{description: "message from JSON", path: "child", value: `{"stringValue":"json"}`, expected: `{"stringValue":"json"}`},
+		{description: "repeated scalar appended", path: "tags", value: "first", expected: ""},
+		{description: "repeated scalar by index", path: "tags[0]", value: "first", expected: "first"},
+		{description: "map with string keys", path: "by_name[alice].string_value", value: "hi", expected: "hi"},
+		{description: "map with quoted keys", path: `by_name["a.b[c]"].int32_value`, value: "3", expected: "3"},
+		{description: "map with int keys", path: "by_number[-3]", value: "minus three", expected: "minus three"},
+		{description: "oneof field", path: "text", value: "chosen", expected: "chosen"},
+		{description: "message in oneof", path: "nested.string_value", value: "chosen", expected: "chosen"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			msg := dynamicpb.NewMessage(md)
+			require.NoError(t, SetByProtoReflection(msg, tc.path, tc.value))
+			actual, found := ExtractByProtoReflection(msg, tc.path)
+			assert.Equal(t, tc.expected != "", found)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestFieldPath_Collections(t *testing.T) {
+	msg := dynamicpb.NewMessage(kindsDescriptor(t))
+
+	t.Log("testing: repeated fields are appended to by index or without")
+	require.NoError(t, SetByProtoReflection(msg, "tags[0]", "a"))
+	require.NoError(t, SetByProtoReflection(msg, "tags", "b"))
+	require.NoError(t, SetByProtoReflection(msg, "tags[2]", "c"))
+	require.NoError(t, SetByProtoReflection(msg, "tags[1]", "B"))
+	for path, expected := range map[string]string{"tags[0]": "a", "tags[1]": "B", "tags[2]": "c"} {
+		actual, found := ExtractByProtoReflection(msg, path)
+		assert.True(t, found, path)
+		assert.Equal(t, expected, actual, path)
+	}
+	_, found := ExtractByProtoReflection(msg, "tags[3]")
+	assert.False(t, found)
+	_, found = ExtractByProtoReflection(msg, "tags")
+	assert.False(t, found, "whole lists cannot be formatted")
+	list, found, err := GetField(msg, "tags")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 3, list.List().Len())
+
+	t.Log("testing: indexes beyond the end of a list are out of range")
+	assert.ErrorIs(t, SetByProtoReflection(msg, "tags[5]", "x"), ErrIndexOutOfRange)
+	assert.ErrorIs(t, SetByProtoReflection(msg, "children[0].string_value", "x"), ErrIndexOutOfRange)
+
+	t.Log("testing: messages of lists are set by index")
+	require.NoError(t, SetByProtoReflection(msg, "children", `{"stringValue":"first"}`))
+	require.NoError(t, SetByProtoReflection(msg, "children[0].tags", "inner"))
+	actual, found := ExtractByProtoReflection(msg, "children[0].tags[0]")
+	assert.True(t, found)
+	assert.Equal(t, "inner", actual)
+
+	t.Log("testing: map values are populated along the path")
+	require.NoError(t, SetByProtoReflection(msg, "by_name[bob].child.bool_value", "true"))
+	actual, found = ExtractByProtoReflection(msg, "by_name[bob].child.bool_value")
+	assert.True(t, found)
+	assert.Equal(t, "true", actual)
+	_, found = ExtractByProtoReflection(msg, "by_name[carol].child")
+	assert.False(t, found)
+}
+
+func TestFieldPath_Oneof(t *testing.T) {
+	msg := dynamicpb.NewMessage(kindsDescriptor(t))
+
+	t.Log("testing: a oneof resolves to its populated field")
+	_, found := ExtractByProtoReflection(msg, "choice")
+	assert.False(t, found)
+	require.NoError(t, SetByProtoReflection(msg, "text", "chosen"))
+	actual, found := ExtractByProtoReflection(msg, "choice")
+	assert.True(t, found)
+	assert.Equal(t, "chosen", actual)
+
+	t.Log("testing: setting a field of a oneof clears the others")
+	require.NoError(t, SetByProtoReflection(msg, "nested.int32_value", "5"))
+	_, found = ExtractByProtoReflection(msg, "text")
+	assert.False(t, found)
+	actual, found = ExtractByProtoReflection(msg, "choice")
+	assert.True(t, found)
+	assert.Equal(t, `{"int32Value":5}`, actual)
+
+	t.Log("testing: a oneof cannot be set")
+	assert.ErrorIs(t, SetByProtoReflection(msg, "choice", "chosen"), ErrInvalidPath)
+}
+
+func TestFieldPath_TypedValues(t *testing.T) {
+	md := kindsDescriptor(t)
+	msg := dynamicpb.NewMessage(md)
+	require.NoError(t, SetField(msg, "child.uint64_value", protoreflect.ValueOfUint64(12)))
+	require.NoError(t, SetField(msg, "by_number[7]", protoreflect.ValueOfString("seven")))
+	v, found, err := GetField(msg, "child.uint64_value")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, uint64(12), v.Uint())
+	v, found, err = GetField(msg, "by_number[7]")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "seven", v.String())
+	_, found, err = GetField(msg, "child.child.uint64_value")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestFieldPath_Errors(t *testing.T) {
+	md := kindsDescriptor(t)
+	testCases := []struct {
+		description string
+		path        string
+		value       string
+		expectedErr error
+	}{
+		{description: "empty path", path: "", expectedErr: ErrInvalidPath},
+		{description: "empty segment", path: "child..string_value", expectedErr: ErrInvalidPath},
+		{description: "unterminated bracket", path: "tags[0", expectedErr: ErrInvalidPath},
+		{description: "unterminated quoted key", path: `by_name["a].int32_value`, expectedErr: ErrInvalidPath},
+		{description: "text after bracket", path: "tags[0]x", expectedErr: ErrInvalidPath},
+		{description: "unknown field", path: "child.unknown", expectedErr: ErrFieldNotFound},
+		{description: "path through scalar", path: "string_value.length", expectedErr: ErrInvalidPath},
+		{description: "path through list without index", path: "children.string_value", expectedErr: ErrInvalidPath},
+		{description: "index of a singular field", path: "child[0]", expectedErr: ErrInvalidPath},
+		{description: "negative index", path: "tags[-1]", expectedErr: ErrInvalidPath},
+		{description: "key not of the map key kind", path: "by_number[seven]", expectedErr: ErrInvalidPath},
+		{description: "map without key", path: "by_number", value: "x", expectedErr: ErrInvalidPath},
+		{description: "oneof followed by field", path: "choice.string_value", expectedErr: ErrFieldNotFound},
+		{description: "number out of range", path: "int32_value", value: "3000000000", expectedErr: ErrInvalidValue},
+		{description: "not a bool", path: "bool_value", value: "maybe", expectedErr: ErrInvalidValue},
+		{description: "bytes not base64", path: "bytes_value", value: "not base64!", expectedErr: ErrInvalidValue},
+		{description: "unknown enum value", path: "color", value: "COLOR_BLUE", expectedErr: ErrInvalidValue},
+		{description: "message not JSON", path: "child", value: "hello", expectedErr: ErrInvalidValue},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			msg := dynamicpb.NewMessage(md)
+			assert.ErrorIs(t, SetByProtoReflection(msg, tc.path, tc.value), tc.expectedErr)
+			_, _, err := GetField(msg, tc.path)
+			if tc.value == "" {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
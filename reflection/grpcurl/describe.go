@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const indentUnit = "  "
+
+// describe renders a descriptor in protobuf syntax, preceded by what kind of symbol it is.
+func describe(d protoreflect.Descriptor) string {
+	var b strings.Builder
+	switch d := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(&b, "%s is a service:\n", d.FullName())
+		writeService(&b, d)
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintf(&b, "%s is a method:\n", d.FullName())
+		writeMethod(&b, d, "")
+	case protoreflect.MessageDescriptor:
+		fmt.Fprintf(&b, "%s is a message:\n", d.FullName())
+		writeMessage(&b, d, "")
+	case protoreflect.EnumDescriptor:
+		fmt.Fprintf(&b, "%s is an enum:\n", d.FullName())
+		writeEnum(&b, d, "")
+	case protoreflect.FieldDescriptor:
+		fmt.Fprintf(&b, "%s is a field:\n", d.FullName())
+		writeField(&b, d, "")
+	case protoreflect.EnumValueDescriptor:
+		fmt.Fprintf(&b, "%s is an enum value:\n", d.FullName())
+		fmt.Fprintf(&b, "%s = %d;\n", d.Name(), d.Number())
+	default:
+		fmt.Fprintf(&b, "%s\n", d.FullName())
+	}
+	return b.String()
+}
+
+func writeService(b *strings.Builder, sd protoreflect.ServiceDescriptor) {
+	fmt.Fprintf(b, "service %s {\n", sd.Name())
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		writeMethod(b, methods.Get(i), indentUnit)
+	}
+	b.WriteString("}\n")
+}
+
+func writeMethod(b *strings.Builder, md protoreflect.MethodDescriptor, indent string) {
+	fmt.Fprintf(b, "%srpc %s(%s%s) returns (%s%s);\n", indent, md.Name(),
+		streamPrefix(md.IsStreamingClient()), md.Input().FullName(),
+		streamPrefix(md.IsStreamingServer()), md.Output().FullName())
+}
+
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+func writeMessage(b *strings.Builder, md protoreflect.MessageDescriptor, indent string) {
+	fmt.Fprintf(b, "%smessage %s {\n", indent, md.Name())
+	inner := indent + indentUnit
+	enums := md.Enums()
+	for i := 0; i < enums.Len(); i++ {
+		writeEnum(b, enums.Get(i), inner)
+	}
+	messages := md.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		if nested := messages.Get(i); !nested.IsMapEntry() {
+			writeMessage(b, nested, inner)
+		}
+	}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		oneof := fd.ContainingOneof()
+		if oneof == nil || oneof.IsSynthetic() {
+			writeField(b, fd, inner)
+			continue
+		}
+		// the fields of a oneof are written together, where its first field is declared
+		if oneof.Fields().Get(0) != fd {
+			continue
+		}
+		fmt.Fprintf(b, "%soneof %s {\n", inner, oneof.Name())
+		for j := 0; j < oneof.Fields().Len(); j++ {
+			writeField(b, oneof.Fields().Get(j), inner+indentUnit)
+		}
+		fmt.Fprintf(b, "%s}\n", inner)
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func writeField(b *strings.Builder, fd protoreflect.FieldDescriptor, indent string) {
+	label := ""
+	switch {
+	case fd.IsMap():
+	case fd.IsList():
+		label = "repeated "
+	case fd.HasOptionalKeyword():
+		label = "optional "
+	}
+	fmt.Fprintf(b, "%s%s%s %s = %d;\n", indent, label, fieldType(fd), fd.Name(), fd.Number())
+}
+
+// fieldType returns the type of a field as written in protobuf syntax.
+func fieldType(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("map<%s, %s>", fieldType(fd.MapKey()), fieldType(fd.MapValue()))
+	case fd.Message() != nil:
+		return string(fd.Message().FullName())
+	case fd.Enum() != nil:
+		return string(fd.Enum().FullName())
+	default:
+		return fd.Kind().String()
+	}
+}
+
+func writeEnum(b *strings.Builder, ed protoreflect.EnumDescriptor, indent string) {
+	fmt.Fprintf(b, "%senum %s {\n", indent, ed.Name())
+	values := ed.Values()
+	for i := 0; i < values.Len(); i++ {
+		fmt.Fprintf(b, "%s%s%s = %d;\n", indent, indentUnit, values.Get(i).Name(), values.Get(i).Number())
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
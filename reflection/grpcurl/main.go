@@ -0,0 +1,221 @@
+// Command grpcurl calls the playground servers by hand, resolving services and messages through server reflection,
+// so no compiled stubs are needed:
+//
+//	grpcurl [flags] list                   lists the services of the server
+//	grpcurl [flags] list <service>         lists the methods of a service
+//	grpcurl [flags] describe <symbol>      describes a service, method, message or enum
+//	grpcurl [flags] call <method> [json]   calls a method with JSON requests, read from stdin when omitted
+//
+// Client streaming methods take a sequence of JSON requests, e.g. '{"message":"a"} {"message":"b"}'.
+// Responses are written as JSON, one per message received.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/reflection"
+	"github.com/pgbytes/grpc-playground/testdata"
+	"github.com/pgbytes/grpc-playground/tlsconfig"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // decodes the error details of failed calls
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var errUsage = errors.New("usage: grpcurl [flags] list [service] | describe <symbol> | call <method> [json]")
+
+// headers collects the repeated -H flag.
+type headers []string
+
+func (h *headers) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headers) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q is not of the form 'name: value'", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// metadata returns the headers as outgoing metadata.
+func (h headers) metadata() metadata.MD {
+	md := metadata.MD{}
+	for _, header := range h {
+		name, value, _ := strings.Cut(header, ":")
+		md.Append(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return md
+}
+
+func main() {
+	var hdrs headers
+	addr := flag.String("addr", "localhost:8080", "address of the server")
+	timeout := flag.Duration("timeout", 10*time.Second, "time the command may take, including connecting, 0 for no limit")
+	plaintext := flag.Bool("plaintext", false, "connect without TLS")
+	caFile := flag.String("ca", testdata.Path("ca.pem"), "CA file the server certificate is verified against")
+	serverName := flag.String("server-name", "echo.test.youtube.com", "name the server certificate is verified for")
+	certFile := flag.String("cert", "", "client certificate file presented for mutual TLS")
+	keyFile := flag.String("key", "", "private key file of the client certificate")
+	flag.Var(&hdrs, "H", "metadata sent with calls, e.g. 'authorization: Bearer some-secret', can be repeated")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, errUsage)
+		os.Exit(2)
+	}
+
+	creds := insecure.NewCredentials()
+	if !*plaintext {
+		tlsCfg, err := tlsconfig.Client(*caFile, *serverName, *certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	conn, err := grpc.DialContext(ctx, *addr, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to %s: %v\n", *addr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	ctx = metadata.NewOutgoingContext(ctx, hdrs.metadata())
+	if err := run(ctx, conn, flag.Args(), os.Stdin, os.Stdout); err != nil {
+		printError(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by args against the server of conn.
+func run(ctx context.Context, conn grpc.ClientConnInterface, args []string, stdin io.Reader, stdout io.Writer) error {
+	client, err := newReflectionClient(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer client.close()
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		services, err := client.listServices()
+		if err != nil {
+			return err
+		}
+		for _, service := range services {
+			fmt.Fprintln(stdout, service)
+		}
+		return nil
+	case args[0] == "list" && len(args) == 2:
+		d, err := client.resolve(args[1])
+		if err != nil {
+			return err
+		}
+		sd, ok := d.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return fmt.Errorf("%s is not a service", args[1])
+		}
+		for i := 0; i < sd.Methods().Len(); i++ {
+			fmt.Fprintln(stdout, sd.Methods().Get(i).FullName())
+		}
+		return nil
+	case args[0] == "describe" && len(args) == 2:
+		d, err := client.resolve(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(stdout, describe(d))
+		return nil
+	case args[0] == "call" && (len(args) == 2 || len(args) == 3):
+		method, err := client.resolveMethod(args[1])
+		if err != nil {
+			return err
+		}
+		data := stdin
+		if len(args) == 3 {
+			data = strings.NewReader(args[2])
+		}
+		registry := client.registry
+		requests, err := parseRequests(registry, method, data)
+		if err != nil {
+			return err
+		}
+		return reflection.InvokeStream(ctx, conn, method, requests, func(resp *dynamicpb.Message) error {
+			out, err := registry.FormatJSON(resp)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(stdout, "%s\n", out)
+			return err
+		})
+	default:
+		return errUsage
+	}
+}
+
+// parseRequests decodes the JSON request messages of a method from data, a sequence of JSON objects.
+// Without any object the method is called with an empty request.
+func parseRequests(registry *reflection.Registry, method protoreflect.MethodDescriptor, data io.Reader) ([]proto.Message, error) {
+	var requests []proto.Message
+	dec := json.NewDecoder(data)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding request %d: %w", len(requests)+1, err)
+		}
+		req, err := registry.NewMessageFromJSON(method.Input(), raw)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %w", len(requests)+1, err)
+		}
+		requests = append(requests, req)
+	}
+	if len(requests) == 0 {
+		requests = append(requests, dynamicpb.NewMessage(method.Input()))
+	}
+	return requests, nil
+}
+
+// printError writes an error, with the code and details of failed calls.
+func printError(w io.Writer, err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintf(w, "ERROR:\n  Code: %s\n  Message: %s\n", st.Code(), st.Message())
+	for _, detail := range st.Details() {
+		msg, ok := detail.(proto.Message)
+		if !ok {
+			fmt.Fprintf(w, "  Detail: %v\n", detail)
+			continue
+		}
+		out, err := reflection.NewRegistry(protoregistry.GlobalFiles).FormatJSON(msg)
+		if err != nil {
+			out = []byte(fmt.Sprint(msg))
+		}
+		fmt.Fprintf(w, "  Detail %s: %s\n", msg.ProtoReflect().Descriptor().FullName(), out)
+	}
+}
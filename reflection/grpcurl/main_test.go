@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcreflection "google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// echoServer echoes requests, failing those without message.
+type echoServer struct {
+	echo.UnimplementedEchoServiceServer
+}
+
+func (echoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	if req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "message is empty")
+	}
+	return &echo.EchoResponse{Response: req.Message + req.GetTalk().GetText(), MessageCount: 1}, nil
+}
+
+func (echoServer) ServerStreamEcho(req *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer) error {
+	for i := int32(0); i < req.Count; i++ {
+		if err := stream.Send(&echo.EchoResponse{Response: req.GetRequest().GetMessage(), MessageCount: 1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (echoServer) ClientStreamEcho(stream echo.EchoService_ClientStreamEchoServer) error {
+	var messages []string
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&echo.EchoResponse{Response: strings.Join(messages, " "), MessageCount: int32(len(messages))})
+		} else if err != nil {
+			return err
+		}
+		messages = append(messages, req.Message)
+	}
+}
+
+func startServer(t *testing.T) *grpc.ClientConn {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	echo.RegisterEchoServiceServer(server, echoServer{})
+	grpcreflection.Register(server)
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestRun(t *testing.T) {
+	conn := startServer(t)
+	testCases := []struct {
+		description    string
+		args           []string
+		stdin          string
+		expectedOutput []string
+		expectedCode   codes.Code
+		expectedError  string
+	}{
+		{
+			description:    "golden case: list services",
+			args:           []string{"list"},
+			expectedOutput: []string{"grpc.reflection.v1alpha.ServerReflection\ngrpc_playground.echo.EchoService\n"},
+		},
+		{
+			description: "golden case: list methods of a service",
+			args:        []string{"list", "grpc_playground.echo.EchoService"},
+			expectedOutput: []string{
+				"grpc_playground.echo.EchoService.Echo\n",
+				"grpc_playground.echo.EchoService.BidiStreamEcho\n",
+			},
+		},
+		{
+			description: "golden case: describe service",
+			args:        []string{"describe", "grpc_playground.echo.EchoService"},
+			expectedOutput: []string{
+				"grpc_playground.echo.EchoService is a service:\nservice EchoService {\n",
+				"  rpc ClientStreamEcho(stream grpc_playground.echo.EchoRequest) returns (grpc_playground.echo.EchoResponse);\n",
+			},
+		},
+		{
+			description: "golden case: describe message",
+			args:        []string{"describe", "grpc_playground.echo.EchoRequest"},
+			expectedOutput: []string{
+				"message EchoRequest {\n  string message = 1;\n  grpc_playground.echo.Message talk = 2;\n  grpc_playground.echo.ErrorType errorType = 3;\n}\n",
+			},
+		},
+		{
+			description:    "golden case: describe method",
+			args:           []string{"describe", "grpc_playground.echo.EchoService.Echo"},
+			expectedOutput: []string{"rpc Echo(grpc_playground.echo.EchoRequest) returns (grpc_playground.echo.EchoResponse);\n"},
+		},
+		{
+			description:    "golden case: describe message of an imported file",
+			args:           []string{"describe", "google.protobuf.Duration"},
+			expectedOutput: []string{"message Duration {\n  int64 seconds = 1;\n  int32 nanos = 2;\n}\n"},
+		},
+		{
+			description:    "golden case: call unary method with nested message",
+			args:           []string{"call", "grpc_playground.echo.EchoService/Echo", `{"message": "hello", "talk": {"text": " world"}}`},
+			expectedOutput: []string{"{\n  \"response\": \"hello world\",\n  \"messageCount\": 1\n}\n"},
+		},
+		{
+			description:    "golden case: call server streaming method",
+			args:           []string{"call", "/grpc_playground.echo.EchoService/ServerStreamEcho", `{"request": {"message": "again"}, "count": 2, "interval": "0.001s"}`},
+			expectedOutput: []string{"\"response\": \"again\",\n  \"messageCount\": 1\n}\n{\n  \"response\": \"again\""},
+		},
+		{
+			description:    "golden case: call client streaming method with requests from stdin",
+			args:           []string{"call", "grpc_playground.echo.EchoService.ClientStreamEcho"},
+			stdin:          `{"message": "one"} {"message": "two"}`,
+			expectedOutput: []string{"\"response\": \"one two\",\n  \"messageCount\": 2\n"},
+		},
+		{
+			description:  "failure case: call fails with status of the server",
+			args:         []string{"call", "grpc_playground.echo.EchoService/Echo", `{}`},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			description:   "failure case: several requests to unary method",
+			args:          []string{"call", "grpc_playground.echo.EchoService/Echo", `{"message": "a"} {"message": "b"}`},
+			expectedError: "takes a single request",
+		},
+		{
+			description:   "failure case: unknown field in request",
+			args:          []string{"call", "grpc_playground.echo.EchoService/Echo", `{"text": "hello"}`},
+			expectedError: "request 1: decoding grpc_playground.echo.EchoRequest",
+		},
+		{
+			description:  "failure case: unknown method",
+			args:         []string{"call", "grpc_playground.echo.EchoService/Unknown"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			description:   "failure case: call of a message",
+			args:          []string{"call", "grpc_playground.echo.EchoRequest"},
+			expectedError: "is not a method",
+		},
+		{
+			description:   "failure case: unknown command",
+			args:          []string{"invoke", "grpc_playground.echo.EchoService/Echo"},
+			expectedError: errUsage.Error(),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var stdout bytes.Buffer
+			err := run(ctx, conn, tc.args, strings.NewReader(tc.stdin), &stdout)
+			switch {
+			case tc.expectedCode != codes.OK:
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedCode.String())
+			case tc.expectedError != "":
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+			default:
+				require.NoError(t, err)
+				for _, expected := range tc.expectedOutput {
+					assert.Contains(t, stdout.String(), expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pgbytes/grpc-playground/reflection"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// reflectionClient resolves descriptors through the server reflection service of a connection.
+// Files received from the server are kept in its registry, so every file is only requested once.
+type reflectionClient struct {
+	stream   rpb.ServerReflection_ServerReflectionInfoClient
+	registry *reflection.Registry
+}
+
+func newReflectionClient(ctx context.Context, conn grpc.ClientConnInterface) (*reflectionClient, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("opening reflection stream: %w", err)
+	}
+	return &reflectionClient{stream: stream, registry: reflection.NewRegistry(&protoregistry.Files{})}, nil
+}
+
+// close ends the reflection stream.
+func (c *reflectionClient) close() {
+	_ = c.stream.CloseSend()
+}
+
+// send sends a request on the reflection stream, returning the response or the error reported by the server.
+func (c *reflectionClient) send(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	if err := c.stream.Send(req); err != nil {
+		return nil, fmt.Errorf("sending reflection request: %w", err)
+	}
+	resp, err := c.stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("receiving reflection response: %w", err)
+	}
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		return nil, status.Error(codes.Code(errResp.ErrorCode), errResp.ErrorMessage)
+	}
+	return resp, nil
+}
+
+// listServices returns the sorted names of the services of the server.
+func (c *reflectionClient) listServices() ([]string, error) {
+	resp, err := c.send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.Name)
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// resolve returns the descriptor of a fully qualified symbol, e.g. a service, method, message or enum.
+func (c *reflectionClient) resolve(symbol string) (protoreflect.Descriptor, error) {
+	name := protoreflect.FullName(strings.TrimPrefix(symbol, "."))
+	if d, err := c.registry.Files().FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	// members of messages and services are not symbols of the reflection service, ask for their parent
+	lookup := name
+	resp, err := c.send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: string(lookup)},
+	})
+	for err != nil && status.Code(err) == codes.NotFound && lookup.Parent() != "" {
+		lookup = lookup.Parent()
+		resp, err = c.send(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: string(lookup)},
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", symbol, err)
+	}
+	if err := c.addFiles(resp.GetFileDescriptorResponse().GetFileDescriptorProto()); err != nil {
+		return nil, err
+	}
+	d, err := c.registry.Files().FindDescriptorByName(name)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "symbol %s not found", symbol)
+	}
+	return d, nil
+}
+
+// resolveMethod returns the descriptor of a method named pkg.Service/Method, /pkg.Service/Method or pkg.Service.Method.
+func (c *reflectionClient) resolveMethod(method string) (protoreflect.MethodDescriptor, error) {
+	name := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[:i] + "." + name[i+1:]
+	}
+	d, err := c.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", method)
+	}
+	return md, nil
+}
+
+// addFiles registers serialized file descriptors, their dependencies first. Dependencies that were
+// not sent along are requested by name.
+func (c *reflectionClient) addFiles(encoded [][]byte) error {
+	fds, err := decodeFiles(encoded)
+	if err != nil {
+		return err
+	}
+	return c.registry.AddFiles(fds, c.fetchFile)
+}
+
+// fetchFile requests the file descriptor of path from the server, which usually sends its imports along.
+func (c *reflectionClient) fetchFile(path string) ([]*descriptorpb.FileDescriptorProto, error) {
+	resp, err := c.send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: path},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeFiles(resp.GetFileDescriptorResponse().GetFileDescriptorProto())
+}
+
+func decodeFiles(encoded [][]byte) ([]*descriptorpb.FileDescriptorProto, error) {
+	fds := make([]*descriptorpb.FileDescriptorProto, 0, len(encoded))
+	for _, b := range encoded {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fd); err != nil {
+			return nil, fmt.Errorf("decoding file descriptor: %w", err)
+		}
+		fds = append(fds, fd)
+	}
+	return fds, nil
+}
@@ -0,0 +1,127 @@
+package reflection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Codec encodes messages with the proto API directly, so calls need no generated code. Pre-encoded
+// []byte messages are sent as they are and responses can be received as raw bytes into a *[]byte.
+var Codec encoding.Codec = rawCodec{}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case proto.Message:
+		return proto.Marshal(v)
+	default:
+		return nil, fmt.Errorf("cannot marshal %T, want proto.Message or []byte", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("cannot unmarshal into %T, want proto.Message or *[]byte", v)
+	}
+}
+
+// Name is the content subtype of the default gRPC codec, the encoding on the wire being the same.
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// FullMethod returns the name of the method as used on the wire, e.g. /grpc_playground.echo.EchoService/Echo.
+func FullMethod(method protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+}
+
+// Invoke calls the unary method with req, returning the response as dynamic message.
+func Invoke(ctx context.Context, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor,
+	req proto.Message, opts ...grpc.CallOption) (*dynamicpb.Message, error) {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, fmt.Errorf("%s is a streaming method", method.FullName())
+	}
+	resp := dynamicpb.NewMessage(method.Output())
+	if err := conn.Invoke(ctx, FullMethod(method), req, resp, append(opts, grpc.ForceCodec(Codec))...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// InvokeStream calls a method of any kind with the requests, passing every response to out. Requests are
+// sent while responses are received, so bidi calls answering every request do not stall on flow control.
+// Unary and server streaming methods take a single request. The call is cancelled when out fails.
+func InvokeStream(ctx context.Context, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor,
+	requests []proto.Message, out func(*dynamicpb.Message) error, opts ...grpc.CallOption) error {
+	if len(requests) != 1 && !method.IsStreamingClient() {
+		return fmt.Errorf("%s takes a single request, got %d", method.FullName(), len(requests))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	desc := &grpc.StreamDesc{
+		StreamName:    string(method.Name()),
+		ClientStreams: method.IsStreamingClient(),
+		ServerStreams: method.IsStreamingServer(),
+	}
+	stream, err := conn.NewStream(ctx, desc, FullMethod(method), append(opts, grpc.ForceCodec(Codec))...)
+	if err != nil {
+		return err
+	}
+	sent := make(chan error, 1)
+	go func() {
+		err := sendRequests(stream, requests)
+		sent <- err
+		if err != nil {
+			// the server keeps waiting for requests, end the call to stop receiving
+			cancel()
+		}
+	}()
+	for {
+		resp := dynamicpb.NewMessage(method.Output())
+		if err := stream.RecvMsg(resp); errors.Is(err, io.EOF) {
+			return <-sent
+		} else if err != nil {
+			select {
+			case sendErr := <-sent:
+				if sendErr != nil {
+					return sendErr
+				}
+			default:
+			}
+			return err
+		}
+		if err := out(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// sendRequests sends the requests and closes the sending side of the stream.
+func sendRequests(stream grpc.ClientStream, requests []proto.Message) error {
+	for _, req := range requests {
+		// io.EOF means the server ended the call, its status is returned by RecvMsg
+		if err := stream.SendMsg(req); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return stream.CloseSend()
+}
@@ -0,0 +1,183 @@
+package reflection
+
+import (
+	"sync"
+
+	"github.com/pgbytes/grpc-playground/api/go/options"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// RedactedText replaces the values of sensitive string and bytes fields.
+const RedactedText = "[REDACTED]"
+
+const anyFullName protoreflect.FullName = "google.protobuf.Any"
+
+// RedactMode selects what Redact does to sensitive fields.
+type RedactMode int
+
+const (
+	// MaskSensitive replaces the values of sensitive string and bytes fields, including the elements of lists and
+	// the values of maps, with RedactedText. Sensitive fields of other kinds are cleared.
+	MaskSensitive RedactMode = iota
+	// RemoveSensitive clears all sensitive fields.
+	RemoveSensitive
+)
+
+// IsSensitive reports whether the field is marked with the (grpc_playground.sensitive) option.
+func IsSensitive(fd protoreflect.FieldDescriptor) bool {
+	opts := fd.Options()
+	if opts == nil {
+		return false
+	}
+	sensitive, _ := proto.GetExtension(opts, options.E_Sensitive).(bool)
+	return sensitive
+}
+
+// Redact returns a copy of msg with its sensitive fields masked or removed, at any depth: in nested messages,
+// in the elements of lists, in the values of maps and in Any messages of types linked into the binary.
+// msg itself is never modified, messages of types that cannot hold sensitive fields are returned as they are.
+func Redact(msg proto.Message, mode RedactMode) proto.Message {
+	if msg == nil || !mayBeSensitive(msg.ProtoReflect().Descriptor()) {
+		return msg
+	}
+	redacted := proto.Clone(msg)
+	redact(redacted.ProtoReflect(), mode)
+	return redacted
+}
+
+// sensitiveTypes caches, per message descriptor, whether its messages can hold sensitive fields.
+var sensitiveTypes sync.Map
+
+// mayBeSensitive reports whether messages of md can hold sensitive fields, directly or in nested messages.
+// Any messages can hold anything.
+func mayBeSensitive(md protoreflect.MessageDescriptor) bool {
+	if cached, ok := sensitiveTypes.Load(md); ok {
+		return cached.(bool)
+	}
+	sensitive := hasSensitiveFields(md, map[protoreflect.MessageDescriptor]bool{})
+	sensitiveTypes.Store(md, sensitive)
+	return sensitive
+}
+
+// hasSensitiveFields walks the message types reachable from md. Only the result for md is cached by the caller:
+// the results for nested types are incomplete while a recursive type is still being walked.
+func hasSensitiveFields(md protoreflect.MessageDescriptor, visiting map[protoreflect.MessageDescriptor]bool) bool {
+	if md.FullName() == anyFullName {
+		return true
+	}
+	if visiting[md] {
+		return false
+	}
+	visiting[md] = true
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if IsSensitive(fd) {
+			return true
+		}
+		if fd.Message() != nil && hasSensitiveFields(fd.Message(), visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact masks or removes the sensitive fields of m in place.
+func redact(m protoreflect.Message, mode RedactMode) {
+	if m.Descriptor().FullName() == anyFullName {
+		redactAny(m, mode)
+		return
+	}
+	// the fields are changed after ranging over them, changing a message while ranging over it is undefined.
+	var sensitive []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case IsSensitive(fd):
+			sensitive = append(sensitive, fd)
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				redact(list.Get(i).Message(), mode)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				redact(v.Message(), mode)
+				return true
+			})
+		case fd.Message() != nil && !fd.IsList() && !fd.IsMap():
+			redact(v.Message(), mode)
+		}
+		return true
+	})
+	for _, fd := range sensitive {
+		redactField(m, fd, mode)
+	}
+}
+
+// redactField masks or removes the sensitive field fd of m.
+func redactField(m protoreflect.Message, fd protoreflect.FieldDescriptor, mode RedactMode) {
+	if mode == RemoveSensitive {
+		m.Clear(fd)
+		return
+	}
+	switch {
+	case fd.IsList() && maskable(fd):
+		list := m.Mutable(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			list.Set(i, mask(fd))
+		}
+	case fd.IsMap() && maskable(fd.MapValue()):
+		entries := m.Mutable(fd).Map()
+		var keys []protoreflect.MapKey
+		entries.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, k)
+			return true
+		})
+		for _, k := range keys {
+			entries.Set(k, mask(fd.MapValue()))
+		}
+	case !fd.IsList() && !fd.IsMap() && maskable(fd):
+		m.Set(fd, mask(fd))
+	default:
+		m.Clear(fd)
+	}
+}
+
+// maskable reports whether values of fd are replaced with RedactedText rather than cleared.
+func maskable(fd protoreflect.FieldDescriptor) bool {
+	return fd.Kind() == protoreflect.StringKind || fd.Kind() == protoreflect.BytesKind
+}
+
+// mask returns RedactedText as a value of the kind of fd.
+func mask(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	if fd.Kind() == protoreflect.BytesKind {
+		return protoreflect.ValueOfBytes([]byte(RedactedText))
+	}
+	return protoreflect.ValueOfString(RedactedText)
+}
+
+// redactAny redacts the message packed into the Any message m. Messages of types that are not linked into
+// the binary cannot be inspected and are kept as they are.
+func redactAny(m protoreflect.Message, mode RedactMode) {
+	fields := m.Descriptor().Fields()
+	typeURL, value := fields.ByName("type_url"), fields.ByName("value")
+	if typeURL == nil || value == nil {
+		return
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(m.Get(typeURL).String())
+	if err != nil || !mayBeSensitive(mt.Descriptor()) {
+		return
+	}
+	packed := mt.New()
+	if err := proto.Unmarshal(m.Get(value).Bytes(), packed.Interface()); err != nil {
+		return
+	}
+	redact(packed, mode)
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(packed.Interface())
+	if err != nil {
+		return
+	}
+	m.Set(value, protoreflect.ValueOfBytes(b))
+}
@@ -0,0 +1,145 @@
+package reflection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// secretsProto declares sensitive fields of every shape, in a recursive message.
+const secretsProto = `
+name: "reflection/secrets.proto"
+package: "grpc_playground.reflection"
+dependency: "options/options.proto"
+dependency: "google/protobuf/any.proto"
+syntax: "proto3"
+message_type {
+  name: "Secrets"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "password" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING options { [grpc_playground.sensitive]: true } }
+  field { name: "token" number: 3 label: LABEL_OPTIONAL type: TYPE_BYTES options { [grpc_playground.sensitive]: true } }
+  field { name: "pin" number: 4 label: LABEL_OPTIONAL type: TYPE_INT32 options { [grpc_playground.sensitive]: true } }
+  field { name: "codes" number: 5 label: LABEL_REPEATED type: TYPE_STRING options { [grpc_playground.sensitive]: true } }
+  field { name: "by_user" number: 6 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Secrets.ByUserEntry" options { [grpc_playground.sensitive]: true } }
+  field { name: "child" number: 7 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Secrets" }
+  field { name: "children" number: 8 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Secrets" }
+  field { name: "details" number: 9 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".google.protobuf.Any" }
+  nested_type {
+    name: "ByUserEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+    options { map_entry: true }
+  }
+}
+message_type {
+  name: "Plain"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "self" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".grpc_playground.reflection.Plain" }
+}
+`
+
+// secretsDescriptors returns the descriptors of the Secrets and Plain messages of secretsProto.
+func secretsDescriptors(t *testing.T) (protoreflect.MessageDescriptor, protoreflect.MessageDescriptor) {
+	fdp := &descriptorpb.FileDescriptorProto{}
+	require.NoError(t, prototext.Unmarshal([]byte(secretsProto), fdp))
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+	return fd.Messages().ByName("Secrets"), fd.Messages().ByName("Plain")
+}
+
+func TestIsSensitive(t *testing.T) {
+	assert.True(t, IsSensitive((&echo.Message{}).ProtoReflect().Descriptor().Fields().ByName("text")))
+	assert.True(t, IsSensitive((&chat.ChatMessage{}).ProtoReflect().Descriptor().Fields().ByName("message")))
+	assert.False(t, IsSensitive((&chat.ChatMessage{}).ProtoReflect().Descriptor().Fields().ByName("user")))
+	assert.False(t, IsSensitive((&echo.EchoRequest{}).ProtoReflect().Descriptor().Fields().ByName("message")))
+}
+
+func TestRedact(t *testing.T) {
+	secrets, _ := secretsDescriptors(t)
+	registry := NewRegistry(protoregistry.GlobalFiles)
+	details, err := anypb.New(&echo.EchoRequest{Message: "visible", Talk: &echo.Message{Text: "hidden"}})
+	require.NoError(t, err)
+	detailsJSON, err := registry.FormatJSON(details)
+	require.NoError(t, err)
+	input := `{
+		"name": "alice", "password": "p4ss", "token": "c2VjcmV0", "pin": 1234,
+		"codes": ["a", "b"], "byUser": {"bob": "x"},
+		"child": {"name": "child", "password": "p4ss"},
+		"children": [{"name": "first", "pin": 1}, {"name": "second", "codes": ["c"]}],
+		"details": ` + string(detailsJSON) + `
+	}`
+
+	testCases := []struct {
+		description string
+		mode        RedactMode
+		expected    string
+	}{
+		{
+			description: "golden case: sensitive fields are masked",
+			mode:        MaskSensitive,
+			expected: `{
+				"name": "alice", "password": "[REDACTED]", "token": "W1JFREFDVEVEXQ==",
+				"codes": ["[REDACTED]", "[REDACTED]"], "byUser": {"bob": "[REDACTED]"},
+				"child": {"name": "child", "password": "[REDACTED]"},
+				"children": [{"name": "first"}, {"name": "second", "codes": ["[REDACTED]"]}],
+				"details": {"@type": "type.googleapis.com/grpc_playground.echo.EchoRequest", "message": "visible", "talk": {"text": "[REDACTED]"}}
+			}`,
+		},
+		{
+			description: "golden case: sensitive fields are removed",
+			mode:        RemoveSensitive,
+			expected: `{
+				"name": "alice",
+				"child": {"name": "child"},
+				"children": [{"name": "first"}, {"name": "second"}],
+				"details": {"@type": "type.googleapis.com/grpc_playground.echo.EchoRequest", "message": "visible", "talk": {}}
+			}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			msg, err := registry.NewMessageFromJSON(secrets, []byte(input))
+			require.NoError(t, err)
+			original := proto.Clone(msg)
+
+			redacted := Redact(msg, tc.mode)
+			actual, err := registry.FormatJSON(redacted)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(actual))
+			assert.True(t, proto.Equal(original, msg), "the input is not modified")
+		})
+	}
+}
+
+func TestRedact_WithoutSensitiveFields(t *testing.T) {
+	_, plain := secretsDescriptors(t)
+	registry := NewRegistry(protoregistry.GlobalFiles)
+
+	t.Log("testing: messages that cannot hold sensitive fields are returned as they are")
+	msg, err := registry.NewMessageFromJSON(plain, []byte(`{"name": "a", "self": {"name": "b"}}`))
+	require.NoError(t, err)
+	assert.Same(t, msg, Redact(msg, MaskSensitive))
+	interval := durationpb.New(time.Second)
+	assert.Same(t, interval, Redact(interval, MaskSensitive))
+
+	t.Log("testing: messages of sensitive types without sensitive values are copied unchanged")
+	echoReq := &echo.EchoRequest{Message: "hello"}
+	redacted := Redact(echoReq, MaskSensitive)
+	assert.NotSame(t, echoReq, redacted)
+	assert.True(t, proto.Equal(echoReq, redacted))
+
+	t.Log("testing: nil messages are returned as they are")
+	assert.Nil(t, Redact(nil, MaskSensitive))
+}
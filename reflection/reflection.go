@@ -1,3 +1,5 @@
+// Package reflection reads and writes proto messages without knowing their Go types, from extracting a field
+// to building and invoking dynamic messages of any method described by a FileDescriptorSet.
 package reflection
 
 import (
@@ -5,20 +7,20 @@ import (
 	"reflect"
 
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-const (
-	protoFieldMessage = "message"
-	structFieldName   = "Message"
-)
+const structFieldName = "Message"
 
 var (
 	errNotAStruct           = fmt.Errorf("request object is not a struct")
 	errImmutableField       = fmt.Errorf("request object field is immutable")
-	errFieldNotFound        = fmt.Errorf("field not found in request object")
 	errFieldNotOfTypeString = fmt.Errorf("field is not of type string")
-	errNotAProto            = fmt.Errorf("request object is not a proto message")
+
+	ErrFieldNotFound   = fmt.Errorf("field not found in request object")
+	ErrNotAProto       = fmt.Errorf("request object is not a proto message")
+	ErrInvalidPath     = fmt.Errorf("invalid field path")
+	ErrIndexOutOfRange = fmt.Errorf("index out of range")
+	ErrInvalidValue    = fmt.Errorf("invalid field value")
 )
 
 // extractByReflection extract a field value from provided request using so reflection
@@ -50,7 +52,7 @@ func setByReflection(req interface{}, valueToSet string) error {
 	// check if struct type request has field protoFieldMessage
 	field, exist := reqType.Elem().FieldByName(structFieldName)
 	if !exist {
-		return errFieldNotFound
+		return ErrFieldNotFound
 	}
 	// check if field is of type string
 	if field.Type.Kind() != reflect.String {
@@ -67,39 +69,34 @@ func setByReflection(req interface{}, valueToSet string) error {
 	return nil
 }
 
-func extractByProtoReflection(req interface{}) (string, bool) {
+// ExtractByProtoReflection returns the value of the field at path in the proto message req, formatted as string,
+// and whether it is set. Paths are dotted field names such as talk.text, see GetField. Enums are formatted by name,
+// bytes as base64 and messages as JSON.
+func ExtractByProtoReflection(req interface{}, path string) (string, bool) {
 	// check if request is of type proto message
 	reqMessage, ok := req.(proto.Message)
 	if !ok {
 		return "", false
 	}
-	// check if message has field protoFieldMessage and is of kind string
-	messageStringField := reqMessage.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(protoFieldMessage))
-	if messageStringField != nil && messageStringField.Kind() == protoreflect.StringKind {
-		// get the field value and cast to string
-		fieldValue := reqMessage.ProtoReflect().Get(messageStringField).String()
-		if fieldValue != "" {
-			return fieldValue, true
-		}
+	accessor, err := AccessorFor(reqMessage.ProtoReflect().Descriptor(), path)
+	if err != nil {
+		return "", false
 	}
-	return "", false
+	return accessor.Extract(reqMessage)
 }
 
-// setByProtoReflection set provided string value to proto message request string field using proto reflect.
-func setByProtoReflection(req interface{}, valueToSet string) error {
+// SetByProtoReflection sets the field at path in the proto message req to valueToSet, converted to the kind
+// of the field: enums by name or number, bytes from base64 and messages from JSON. Paths are dotted field names
+// such as talk.text, see SetField.
+func SetByProtoReflection(req interface{}, path string, valueToSet string) error {
 	// check if request is of type proto message
 	reqMessage, ok := req.(proto.Message)
 	if !ok {
-		return errNotAProto
-	}
-	// check if message has field protoFieldMessage and is of kind string
-	messageStringField := reqMessage.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(protoFieldMessage))
-	if messageStringField == nil {
-		return errFieldNotFound
+		return ErrNotAProto
 	}
-	if messageStringField.Kind() != protoreflect.StringKind {
-		return errFieldNotOfTypeString
+	accessor, err := AccessorFor(reqMessage.ProtoReflect().Descriptor(), path)
+	if err != nil {
+		return err
 	}
-	reqMessage.ProtoReflect().Set(messageStringField, protoreflect.ValueOfString(valueToSet))
-	return nil
+	return accessor.SetString(reqMessage, valueToSet)
 }
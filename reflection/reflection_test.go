@@ -5,6 +5,7 @@ import (
 
 	"github.com/pgbytes/grpc-playground/api/go/echo"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestExtractByReflection(t *testing.T) {
@@ -91,7 +92,7 @@ func TestSetByReflection(t *testing.T) {
 			description:    "failure case: field not found in request",
 			request:        &fakeRequestWithoutAnyMessage{},
 			requestMessage: "test-message-to-set",
-			expectedErr:    errFieldNotFound,
+			expectedErr:    ErrFieldNotFound,
 		},
 		{
 			description:    "failure case: request object does not have field of type string",
@@ -118,37 +119,70 @@ func TestExtractByProtoReflection(t *testing.T) {
 	testCases := []struct {
 		description          string
 		request              interface{}
+		path                 string
 		expectedMessage      string
 		expectedMessageFound bool
 	}{
 		{
 			description:          "golden case: message field found",
 			request:              &echo.EchoRequest{Message: "3sm5akzqp2u0"},
+			path:                 "message",
 			expectedMessage:      "3sm5akzqp2u0",
 			expectedMessageFound: true,
 		},
+		{
+			description:          "golden case: nested talk text found",
+			request:              &echo.EchoRequest{Talk: &echo.Message{Text: "3sm5akzqp2u0"}},
+			path:                 "talk.text",
+			expectedMessage:      "3sm5akzqp2u0",
+			expectedMessageFound: true,
+		},
+		{
+			description:          "golden case: enum field found by name",
+			request:              &echo.EchoRequest{ErrorType: echo.ErrorType_ERROR_TYPE_HELP},
+			path:                 "errorType",
+			expectedMessage:      "ERROR_TYPE_HELP",
+			expectedMessageFound: true,
+		},
+		{
+			description:          "golden case: nested message found as JSON",
+			request:              &echo.EchoRequest{Talk: &echo.Message{Text: "hi"}},
+			path:                 "talk",
+			expectedMessage:      `{"text":"hi"}`,
+			expectedMessageFound: true,
+		},
 		{
 			description:          "failure case: message field found but empty",
 			request:              &echo.EchoRequest{Message: ""},
+			path:                 "message",
+			expectedMessage:      "",
+			expectedMessageFound: false,
+		},
+		{
+			description:          "failure case: nested talk not set",
+			request:              &echo.EchoRequest{Message: "3sm5akzqp2u0"},
+			path:                 "talk.text",
 			expectedMessage:      "",
 			expectedMessageFound: false,
 		},
 		{
 			description:          "failure case: message field not found",
 			request:              &echo.Message{Text: "foobar-request-id"},
+			path:                 "message",
 			expectedMessage:      "",
 			expectedMessageFound: false,
 		},
 		{
 			description:          "failure case: not a struct",
 			request:              &something,
+			path:                 "message",
 			expectedMessage:      "",
 			expectedMessageFound: false,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
-			actualMessage, actualFound := extractByProtoReflection(tc.request)
+			actualMessage, actualFound := ExtractByProtoReflection(tc.request, tc.path)
 			require.Equal(t, tc.expectedMessage, actualMessage)
 			require.Equal(t, tc.expectedMessageFound, actualFound)
 		})
@@ -160,38 +194,83 @@ func TestSetByProtoReflection(t *testing.T) {
 		Message string
 	}
 	testCases := []struct {
-		description    string
-		request        interface{}
-		requestMessage string
-		expectedErr    error
+		description     string
+		request         interface{}
+		path            string
+		requestMessage  string
+		expectedRequest proto.Message
+		expectedErr     error
 	}{
 		{
-			description:    "golden case: message set correctly",
-			request:        &echo.EchoRequest{},
-			requestMessage: "test-message-to-set",
-			expectedErr:    nil,
+			description:     "golden case: message set correctly",
+			request:         &echo.EchoRequest{},
+			path:            "message",
+			requestMessage:  "test-message-to-set",
+			expectedRequest: &echo.EchoRequest{Message: "test-message-to-set"},
+		},
+		{
+			description:     "golden case: nested talk created and its text set",
+			request:         &echo.EchoRequest{Message: "kept"},
+			path:            "talk.text",
+			requestMessage:  "test-message-to-set",
+			expectedRequest: &echo.EchoRequest{Message: "kept", Talk: &echo.Message{Text: "test-message-to-set"}},
+		},
+		{
+			description:     "golden case: enum set by name",
+			request:         &echo.EchoRequest{},
+			path:            "errorType",
+			requestMessage:  "ERROR_TYPE_RETRY_INFO",
+			expectedRequest: &echo.EchoRequest{ErrorType: echo.ErrorType_ERROR_TYPE_RETRY_INFO},
+		},
+		{
+			description:     "golden case: nested message set from JSON",
+			request:         &echo.ServerStreamEchoRequest{},
+			path:            "request",
+			requestMessage:  `{"message": "hi", "talk": {"text": "there"}}`,
+			expectedRequest: &echo.ServerStreamEchoRequest{Request: &echo.EchoRequest{Message: "hi", Talk: &echo.Message{Text: "there"}}},
 		},
 		{
 			description:    "failure case: request not a proto message",
 			request:        &fakeRequestWithMessage{},
+			path:           "message",
 			requestMessage: "test-message-to-set",
-			expectedErr:    errNotAProto,
+			expectedErr:    ErrNotAProto,
 		},
 		{
 			description:    "failure case: field not found in request",
 			request:        &echo.Message{},
+			path:           "message",
+			requestMessage: "test-message-to-set",
+			expectedErr:    ErrFieldNotFound,
+		},
+		{
+			description:    "failure case: nested field not found in request",
+			request:        &echo.EchoRequest{},
+			path:           "talk.message",
+			requestMessage: "test-message-to-set",
+			expectedErr:    ErrFieldNotFound,
+		},
+		{
+			description:    "failure case: value not of the field kind",
+			request:        &echo.EchoResponse{},
+			path:           "message_count",
 			requestMessage: "test-message-to-set",
-			expectedErr:    errFieldNotFound,
+			expectedErr:    ErrInvalidValue,
+		},
+		{
+			description:    "failure case: path through a string field",
+			request:        &echo.EchoRequest{},
+			path:           "message.text",
+			requestMessage: "test-message-to-set",
+			expectedErr:    ErrInvalidPath,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
-			actualErr := setByProtoReflection(tc.request, tc.requestMessage)
-			require.Equal(t, tc.expectedErr, actualErr)
+			actualErr := SetByProtoReflection(tc.request, tc.path, tc.requestMessage)
+			require.ErrorIs(t, actualErr, tc.expectedErr)
 			if tc.expectedErr == nil {
-				req, ok := tc.request.(*echo.EchoRequest)
-				require.True(t, ok)
-				require.Equal(t, tc.requestMessage, req.Message)
+				require.True(t, proto.Equal(tc.expectedRequest, tc.request.(proto.Message)), "got %v", tc.request)
 			}
 		})
 	}
@@ -203,25 +282,42 @@ var benchMarkResultProto string
 var benchMarkResultProtoBool bool
 var benchmarkResultError error
 
+// benchmarkPaths are the flat and nested string fields of EchoRequest the benchmarks access.
+var benchmarkPaths = []struct {
+	description string
+	path        string
+}{
+	{description: "flat", path: "message"},
+	{description: "nested", path: "talk.text"},
+}
+
 func BenchmarkExtractByProtoReflection(b *testing.B) {
-	request := &echo.EchoRequest{Message: "3sm5akzqp2u0"}
-	var actualMessage string
-	var actualFound bool
-	for i := 0; i < b.N; i++ {
-		actualMessage, actualFound = extractByProtoReflection(request)
+	for _, bc := range benchmarkPaths {
+		b.Run(bc.description, func(b *testing.B) {
+			request := &echo.EchoRequest{Message: "3sm5akzqp2u0", Talk: &echo.Message{Text: "3sm5akzqp2u0"}}
+			var actualMessage string
+			var actualFound bool
+			for i := 0; i < b.N; i++ {
+				actualMessage, actualFound = ExtractByProtoReflection(request, bc.path)
+			}
+			benchMarkResultProto = actualMessage
+			benchMarkResultProtoBool = actualFound
+		})
 	}
-	benchMarkResultProto = actualMessage
-	benchMarkResultProtoBool = actualFound
 }
 
 func BenchmarkSetByProtoReflection(b *testing.B) {
-	request := &echo.EchoRequest{}
-	messageToSet := "test-message-to-set"
-	var actualErr error
-	for i := 0; i < b.N; i++ {
-		actualErr = setByProtoReflection(request, messageToSet)
+	for _, bc := range benchmarkPaths {
+		b.Run(bc.description, func(b *testing.B) {
+			request := &echo.EchoRequest{}
+			messageToSet := "test-message-to-set"
+			var actualErr error
+			for i := 0; i < b.N; i++ {
+				actualErr = SetByProtoReflection(request, bc.path, messageToSet)
+			}
+			benchmarkResultError = actualErr
+		})
 	}
-	benchmarkResultError = actualErr
 }
 
 func BenchmarkExtractByReflection(b *testing.B) {
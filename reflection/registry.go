@@ -0,0 +1,213 @@
+package reflection
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Registry resolves the services, methods and messages of a set of proto files, to build and invoke
+// dynamic messages of them without compiled stubs. It also resolves the types of Any payloads and
+// extensions, so it can be passed as resolver to protojson and proto.UnmarshalOptions.
+type Registry struct {
+	files *protoregistry.Files
+}
+
+// NewRegistry creates a registry of the files, which may keep being added to.
+func NewRegistry(files *protoregistry.Files) *Registry {
+	return &Registry{files: files}
+}
+
+// LoadFileDescriptorSet creates a registry of the files of a serialized FileDescriptorSet, such as the image
+// written by `buf build -o image.bin` or `protoc --include_imports -o`. Files ending in .json are decoded as JSON.
+func LoadFileDescriptorSet(path string) (*Registry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading descriptor set: %w", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = protojson.Unmarshal(b, set)
+	} else {
+		err = proto.Unmarshal(b, set)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding descriptor set %s: %w", path, err)
+	}
+	return NewRegistryFromSet(set)
+}
+
+// NewRegistryFromSet creates a registry of the files of a FileDescriptorSet. Imports missing from the set,
+// e.g. of a set built with --exclude-imports, are taken from the files linked into the binary.
+func NewRegistryFromSet(set *descriptorpb.FileDescriptorSet) (*Registry, error) {
+	r := NewRegistry(&protoregistry.Files{})
+	if err := r.AddFiles(set.GetFile(), nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// FetchFile returns the descriptor of the file at path, possibly along with other files, e.g. its imports.
+type FetchFile func(path string) ([]*descriptorpb.FileDescriptorProto, error)
+
+// AddFiles registers file descriptors, their imports first. Imports neither among fds nor registered yet are
+// requested with fetch, e.g. from the reflection service of a server, or taken from the files linked into the
+// binary when fetch is nil. Files already registered are kept.
+func (r *Registry) AddFiles(fds []*descriptorpb.FileDescriptorProto, fetch FetchFile) error {
+	pending := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range fds {
+		pending[fd.GetName()] = fd
+	}
+	visiting := map[string]bool{}
+	for _, fd := range fds {
+		if err := r.register(fd.GetName(), pending, fetch, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register registers the file descriptor of path after its dependencies. visiting holds the files whose
+// dependencies are being registered, to fail on import cycles instead of recursing without end.
+func (r *Registry) register(path string, pending map[string]*descriptorpb.FileDescriptorProto, fetch FetchFile,
+	visiting map[string]bool) error {
+	if _, err := r.files.FindFileByPath(path); err == nil {
+		return nil
+	}
+	if visiting[path] {
+		return fmt.Errorf("import cycle through %s", path)
+	}
+	fd, ok := pending[path]
+	if !ok && fetch != nil {
+		fetched, err := fetch(path)
+		if err != nil {
+			return fmt.Errorf("resolving file %s: %w", path, err)
+		}
+		for _, f := range fetched {
+			pending[f.GetName()] = f
+		}
+		if fd, ok = pending[path]; !ok {
+			return fmt.Errorf("file %s was not returned", path)
+		}
+	}
+	if !ok {
+		linked, err := protoregistry.GlobalFiles.FindFileByPath(path)
+		if err != nil {
+			return fmt.Errorf("import %s is neither in the descriptor set nor linked in", path)
+		}
+		return r.files.RegisterFile(linked)
+	}
+	visiting[path] = true
+	defer delete(visiting, path)
+	for _, dep := range fd.GetDependency() {
+		if err := r.register(dep, pending, fetch, visiting); err != nil {
+			return err
+		}
+	}
+	file, err := protodesc.NewFile(fd, r.files)
+	if err != nil {
+		return fmt.Errorf("building descriptor of %s: %w", path, err)
+	}
+	return r.files.RegisterFile(file)
+}
+
+// Files returns the files of the registry.
+func (r *Registry) Files() *protoregistry.Files {
+	return r.files
+}
+
+// FindMethod returns the descriptor of a method named pkg.Service/Method, /pkg.Service/Method or pkg.Service.Method.
+func (r *Registry) FindMethod(name string) (protoreflect.MethodDescriptor, error) {
+	fullName := strings.TrimPrefix(name, "/")
+	if i := strings.LastIndex(fullName, "/"); i >= 0 {
+		fullName = fullName[:i] + "." + fullName[i+1:]
+	}
+	d, err := r.files.FindDescriptorByName(protoreflect.FullName(fullName))
+	if err != nil {
+		return nil, fmt.Errorf("method %s: %w", name, err)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", name)
+	}
+	return md, nil
+}
+
+// FindMessage returns the descriptor of a message by its full name, e.g. grpc_playground.echo.EchoRequest.
+func (r *Registry) FindMessage(name string) (protoreflect.MessageDescriptor, error) {
+	d, err := r.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", name, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", name)
+	}
+	return md, nil
+}
+
+// FindMessageByName returns a dynamic type of the message, implementing protoregistry.MessageTypeResolver.
+func (r *Registry) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	md, err := r.FindMessage(string(name))
+	if err != nil {
+		return nil, protoregistry.NotFound
+	}
+	return dynamicpb.NewMessageType(md), nil
+}
+
+// FindMessageByURL returns a dynamic type of the message of an Any type URL, e.g.
+// type.googleapis.com/grpc_playground.echo.EchoRequest.
+func (r *Registry) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	return r.FindMessageByName(protoreflect.FullName(url[strings.LastIndex(url, "/")+1:]))
+}
+
+// FindExtensionByName returns a dynamic type of the extension, implementing protoregistry.ExtensionTypeResolver.
+func (r *Registry) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	d, err := r.files.FindDescriptorByName(field)
+	if err != nil {
+		return nil, err
+	}
+	xd, ok := d.(protoreflect.ExtensionDescriptor)
+	if !ok || !xd.IsExtension() {
+		return nil, protoregistry.NotFound
+	}
+	return dynamicpb.NewExtensionType(xd), nil
+}
+
+// FindExtensionByNumber returns a dynamic type of the extension of message with the field number.
+func (r *Registry) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	var found protoreflect.ExtensionDescriptor
+	r.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		found = findExtension(fd.Extensions(), fd.Messages(), message, field)
+		return found == nil
+	})
+	if found == nil {
+		return nil, protoregistry.NotFound
+	}
+	return dynamicpb.NewExtensionType(found), nil
+}
+
+// findExtension searches the extensions and those declared in the messages, recursively.
+func findExtension(extensions protoreflect.ExtensionDescriptors, messages protoreflect.MessageDescriptors,
+	message protoreflect.FullName, field protoreflect.FieldNumber) protoreflect.ExtensionDescriptor {
+	for i := 0; i < extensions.Len(); i++ {
+		if xd := extensions.Get(i); xd.ContainingMessage().FullName() == message && xd.Number() == field {
+			return xd
+		}
+	}
+	for i := 0; i < messages.Len(); i++ {
+		if xd := findExtension(messages.Get(i).Extensions(), messages.Get(i).Messages(), message, field); xd != nil {
+			return xd
+		}
+	}
+	return nil
+}
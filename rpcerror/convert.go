@@ -0,0 +1,120 @@
+package rpcerror
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ToStatus converts any error returned by a handler to the status sent to the client.
+// Typed errors are found anywhere in the wrapped chain of err, context errors become CANCELED
+// and DEADLINE_EXCEEDED, and everything else is converted by the status package, usually to UNKNOWN.
+func ToStatus(err error) *status.Status {
+	if err == nil {
+		return nil
+	}
+	var typed interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &typed) {
+		return typed.GRPCStatus()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err)
+	}
+	return status.Convert(err)
+}
+
+// FromError turns a status error received by a client back into the typed error of the package matching
+// its code and details. Errors that are not statuses or have no typed counterpart are returned unchanged.
+func FromError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || st == nil || st.Code() == codes.OK {
+		return err
+	}
+	if typed := FromStatus(st); typed != nil {
+		return typed
+	}
+	return err
+}
+
+// FromStatus returns the typed error for the code and details of the status, nil if there is none.
+func FromStatus(st *status.Status) Error {
+	msg := st.Message()
+	switch st.Code() {
+	case codes.NotFound:
+		e := &NotFound{Message: msg}
+		if info := findDetail[*errdetails.ResourceInfo](st); info != nil {
+			e.ResourceType, e.ResourceName, e.Owner, e.Description = info.ResourceType, info.ResourceName, info.Owner, info.Description
+		}
+		return e
+	case codes.AlreadyExists:
+		e := &AlreadyExists{Message: msg}
+		if info := findDetail[*errdetails.ResourceInfo](st); info != nil {
+			e.ResourceType, e.ResourceName, e.Owner, e.Description = info.ResourceType, info.ResourceName, info.Owner, info.Description
+		}
+		return e
+	case codes.InvalidArgument:
+		badRequest := findDetail[*errdetails.BadRequest](st)
+		if badRequest == nil {
+			// plain INVALID_ARGUMENT statuses carry no violations worth a typed error
+			return nil
+		}
+		e := &Validation{Message: msg}
+		for _, v := range badRequest.FieldViolations {
+			e.Violations = append(e.Violations, FieldViolation{Field: v.Field, Description: v.Description})
+		}
+		return e
+	case codes.ResourceExhausted:
+		e := &RateLimited{Message: msg}
+		if retry := findDetail[*errdetails.RetryInfo](st); retry != nil {
+			e.RetryDelay = retry.RetryDelay.AsDuration()
+		}
+		if quota := findDetail[*errdetails.QuotaFailure](st); quota != nil {
+			for _, v := range quota.Violations {
+				e.Violations = append(e.Violations, QuotaViolation{Subject: v.Subject, Description: v.Description})
+			}
+		}
+		return e
+	case codes.Unavailable:
+		e := &Unavailable{Message: msg}
+		if retry := findDetail[*errdetails.RetryInfo](st); retry != nil {
+			e.RetryDelay = retry.RetryDelay.AsDuration()
+		}
+		return e
+	case codes.FailedPrecondition:
+		e := &FailedPrecondition{Message: msg}
+		if precondition := findDetail[*errdetails.PreconditionFailure](st); precondition != nil {
+			for _, v := range precondition.Violations {
+				e.Violations = append(e.Violations, PreconditionViolation{Type: v.Type, Subject: v.Subject, Description: v.Description})
+			}
+		}
+		return e
+	case codes.Unauthenticated:
+		e := &Unauthenticated{Message: msg}
+		if info := findDetail[*errdetails.ErrorInfo](st); info != nil {
+			e.Reason, e.Domain, e.Metadata = info.Reason, info.Domain, info.Metadata
+		}
+		return e
+	case codes.PermissionDenied:
+		e := &PermissionDenied{Message: msg}
+		if info := findDetail[*errdetails.ErrorInfo](st); info != nil {
+			e.Reason, e.Domain, e.Metadata = info.Reason, info.Domain, info.Metadata
+		}
+		return e
+	default:
+		return nil
+	}
+}
+
+// findDetail returns the first detail of type T of the status, the zero value if there is none.
+func findDetail[T any](st *status.Status) T {
+	for _, detail := range st.Details() {
+		if d, ok := detail.(T); ok {
+			return d
+		}
+	}
+	var zero T
+	return zero
+}
@@ -0,0 +1,256 @@
+// Package rpcerror maps typed domain errors to gRPC statuses with standard google.rpc error details and back.
+//
+// Handlers return the typed errors, possibly wrapped with fmt.Errorf and %w, and the server interceptors
+// convert them to statuses. Clients turn received statuses back into the typed errors with FromError
+// or the client interceptors, so callers can inspect them with errors.As:
+//
+//	var notFound *rpcerror.NotFound
+//	if errors.As(err, &notFound) {
+//		fmt.Println("missing", notFound.ResourceName)
+//	}
+package rpcerror
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Error is implemented by all typed errors of the package.
+// GRPCStatus returns the status the error is sent to clients as.
+type Error interface {
+	error
+	GRPCStatus() *status.Status
+}
+
+// newStatus builds a status carrying the given details, details that cannot be marshalled are left out.
+func newStatus(code codes.Code, msg string, details ...protoiface.MessageV1) *status.Status {
+	st := status.New(code, msg)
+	if len(details) == 0 {
+		return st
+	}
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+// messageOr returns msg, or the fallback built from format and args when msg is empty.
+func messageOr(msg string, format string, args ...interface{}) string {
+	if msg != "" {
+		return msg
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// NotFound reports a missing resource, sent as NOT_FOUND with a ResourceInfo.
+type NotFound struct {
+	Message      string
+	ResourceType string
+	ResourceName string
+	Owner        string
+	Description  string
+}
+
+func (e *NotFound) Error() string {
+	return messageOr(e.Message, "%s %q not found", e.ResourceType, e.ResourceName)
+}
+
+func (e *NotFound) GRPCStatus() *status.Status {
+	return newStatus(codes.NotFound, e.Error(), &errdetails.ResourceInfo{
+		ResourceType: e.ResourceType,
+		ResourceName: e.ResourceName,
+		Owner:        e.Owner,
+		Description:  e.Description,
+	})
+}
+
+// AlreadyExists reports a resource that cannot be created twice, sent as ALREADY_EXISTS with a ResourceInfo.
+type AlreadyExists struct {
+	Message      string
+	ResourceType string
+	ResourceName string
+	Owner        string
+	Description  string
+}
+
+func (e *AlreadyExists) Error() string {
+	return messageOr(e.Message, "%s %q already exists", e.ResourceType, e.ResourceName)
+}
+
+func (e *AlreadyExists) GRPCStatus() *status.Status {
+	return newStatus(codes.AlreadyExists, e.Error(), &errdetails.ResourceInfo{
+		ResourceType: e.ResourceType,
+		ResourceName: e.ResourceName,
+		Owner:        e.Owner,
+		Description:  e.Description,
+	})
+}
+
+// FieldViolation describes a single invalid field of a request, Field is the dotted path of the field.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Validation reports invalid request fields, sent as INVALID_ARGUMENT with a BadRequest.
+type Validation struct {
+	Message    string
+	Violations []FieldViolation
+}
+
+func (e *Validation) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	violations := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, v.Field+": "+v.Description)
+	}
+	return "invalid request: " + strings.Join(violations, ", ")
+}
+
+func (e *Validation) GRPCStatus() *status.Status {
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range e.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+	return newStatus(codes.InvalidArgument, e.Error(), badRequest)
+}
+
+// QuotaViolation describes a single exceeded quota, Subject names what the quota applies to, e.g. "user:alice".
+type QuotaViolation struct {
+	Subject     string
+	Description string
+}
+
+// RateLimited reports a caller exceeding its rate or quota, sent as RESOURCE_EXHAUSTED
+// with a RetryInfo when RetryDelay is set and a QuotaFailure when there are violations.
+type RateLimited struct {
+	Message    string
+	RetryDelay time.Duration
+	Violations []QuotaViolation
+}
+
+func (e *RateLimited) Error() string {
+	if e.RetryDelay > 0 {
+		return messageOr(e.Message, "rate limited, retry in %s", e.RetryDelay)
+	}
+	return messageOr(e.Message, "rate limited")
+}
+
+func (e *RateLimited) GRPCStatus() *status.Status {
+	var details []protoiface.MessageV1
+	if e.RetryDelay > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryDelay)})
+	}
+	if len(e.Violations) > 0 {
+		quota := &errdetails.QuotaFailure{}
+		for _, v := range e.Violations {
+			quota.Violations = append(quota.Violations, &errdetails.QuotaFailure_Violation{
+				Subject:     v.Subject,
+				Description: v.Description,
+			})
+		}
+		details = append(details, quota)
+	}
+	return newStatus(codes.ResourceExhausted, e.Error(), details...)
+}
+
+// Unavailable reports a transient failure, sent as UNAVAILABLE with a RetryInfo when RetryDelay is set.
+type Unavailable struct {
+	Message    string
+	RetryDelay time.Duration
+}
+
+func (e *Unavailable) Error() string {
+	return messageOr(e.Message, "service unavailable")
+}
+
+func (e *Unavailable) GRPCStatus() *status.Status {
+	if e.RetryDelay <= 0 {
+		return newStatus(codes.Unavailable, e.Error())
+	}
+	return newStatus(codes.Unavailable, e.Error(), &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryDelay)})
+}
+
+// PreconditionViolation describes a single failed precondition, Type is a service specific category, e.g. "TOS".
+type PreconditionViolation struct {
+	Type        string
+	Subject     string
+	Description string
+}
+
+// FailedPrecondition reports a system state the request cannot be executed in,
+// sent as FAILED_PRECONDITION with a PreconditionFailure.
+type FailedPrecondition struct {
+	Message    string
+	Violations []PreconditionViolation
+}
+
+func (e *FailedPrecondition) Error() string {
+	return messageOr(e.Message, "failed precondition")
+}
+
+func (e *FailedPrecondition) GRPCStatus() *status.Status {
+	precondition := &errdetails.PreconditionFailure{}
+	for _, v := range e.Violations {
+		precondition.Violations = append(precondition.Violations, &errdetails.PreconditionFailure_Violation{
+			Type:        v.Type,
+			Subject:     v.Subject,
+			Description: v.Description,
+		})
+	}
+	return newStatus(codes.FailedPrecondition, e.Error(), precondition)
+}
+
+// Unauthenticated reports missing or invalid credentials, sent as UNAUTHENTICATED with an ErrorInfo when Reason is set.
+type Unauthenticated struct {
+	Message  string
+	Reason   string
+	Domain   string
+	Metadata map[string]string
+}
+
+func (e *Unauthenticated) Error() string {
+	return messageOr(e.Message, "unauthenticated")
+}
+
+func (e *Unauthenticated) GRPCStatus() *status.Status {
+	return newStatus(codes.Unauthenticated, e.Error(), errorInfo(e.Reason, e.Domain, e.Metadata)...)
+}
+
+// PermissionDenied reports a caller not allowed to do what it asked for,
+// sent as PERMISSION_DENIED with an ErrorInfo when Reason is set.
+type PermissionDenied struct {
+	Message  string
+	Reason   string
+	Domain   string
+	Metadata map[string]string
+}
+
+func (e *PermissionDenied) Error() string {
+	return messageOr(e.Message, "permission denied")
+}
+
+func (e *PermissionDenied) GRPCStatus() *status.Status {
+	return newStatus(codes.PermissionDenied, e.Error(), errorInfo(e.Reason, e.Domain, e.Metadata)...)
+}
+
+// errorInfo returns the ErrorInfo detail for the reason, none when the reason is empty.
+func errorInfo(reason, domain string, metadata map[string]string) []protoiface.MessageV1 {
+	if reason == "" {
+		return nil
+	}
+	return []protoiface.MessageV1{&errdetails.ErrorInfo{Reason: reason, Domain: domain, Metadata: metadata}}
+}
@@ -0,0 +1,248 @@
+package rpcerror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeEchoServer fails every call with the error registered for the message of the request.
+type fakeEchoServer struct {
+	echo.UnimplementedEchoServiceServer
+	errs map[string]error
+}
+
+func (f *fakeEchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	if err, ok := f.errs[req.Message]; ok {
+		return nil, err
+	}
+	return &echo.EchoResponse{Response: req.Message}, nil
+}
+
+func (f *fakeEchoServer) ServerStreamEcho(req *echo.ServerStreamEchoRequest, stream echo.EchoService_ServerStreamEchoServer) error {
+	if err := stream.Send(&echo.EchoResponse{Response: req.GetRequest().GetMessage()}); err != nil {
+		return err
+	}
+	return f.errs[req.GetRequest().GetMessage()]
+}
+
+// startServer serves the fake echo server with the rpcerror interceptors on both ends.
+func startServer(t *testing.T, errs map[string]error) echo.EchoServiceClient {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor()),
+		grpc.StreamInterceptor(StreamServerInterceptor()),
+	)
+	echo.RegisterEchoServiceServer(server, &fakeEchoServer{errs: errs})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lst.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(StreamClientInterceptor()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return echo.NewEchoServiceClient(conn)
+}
+
+func TestRoundTrip(t *testing.T) {
+	testCases := []struct {
+		description string
+		err         error
+		code        codes.Code
+		// target is a pointer to a typed error, errors.As fills it with the error received by the client
+		target   interface{}
+		expected interface{}
+	}{
+		{
+			description: "not found with resource info",
+			err:         &NotFound{ResourceType: "echo.Room", ResourceName: "rooms/lobby", Owner: "alice"},
+			code:        codes.NotFound,
+			target:      new(*NotFound),
+			expected:    &NotFound{Message: `echo.Room "rooms/lobby" not found`, ResourceType: "echo.Room", ResourceName: "rooms/lobby", Owner: "alice"},
+		},
+		{
+			description: "already exists with resource info",
+			err:         &AlreadyExists{Message: "room exists", ResourceType: "echo.Room", ResourceName: "rooms/lobby"},
+			code:        codes.AlreadyExists,
+			target:      new(*AlreadyExists),
+			expected:    &AlreadyExists{Message: "room exists", ResourceType: "echo.Room", ResourceName: "rooms/lobby"},
+		},
+		{
+			description: "validation with several field violations",
+			err: &Validation{Violations: []FieldViolation{
+				{Field: "message", Description: "must not be empty"},
+				{Field: "talk.text", Description: "too long"},
+			}},
+			code:   codes.InvalidArgument,
+			target: new(*Validation),
+			expected: &Validation{
+				Message: "invalid request: message: must not be empty, talk.text: too long",
+				Violations: []FieldViolation{
+					{Field: "message", Description: "must not be empty"},
+					{Field: "talk.text", Description: "too long"},
+				},
+			},
+		},
+		{
+			description: "rate limited with retry delay and quota violation",
+			err:         &RateLimited{RetryDelay: 3 * time.Second, Violations: []QuotaViolation{{Subject: "user:alice", Description: "10 calls per minute"}}},
+			code:        codes.ResourceExhausted,
+			target:      new(*RateLimited),
+			expected:    &RateLimited{Message: "rate limited, retry in 3s", RetryDelay: 3 * time.Second, Violations: []QuotaViolation{{Subject: "user:alice", Description: "10 calls per minute"}}},
+		},
+		{
+			description: "unavailable with retry delay",
+			err:         &Unavailable{Message: "restarting", RetryDelay: time.Second},
+			code:        codes.Unavailable,
+			target:      new(*Unavailable),
+			expected:    &Unavailable{Message: "restarting", RetryDelay: time.Second},
+		},
+		{
+			description: "failed precondition with violations",
+			err:         &FailedPrecondition{Violations: []PreconditionViolation{{Type: "TOS", Subject: "user:alice", Description: "not accepted"}}},
+			code:        codes.FailedPrecondition,
+			target:      new(*FailedPrecondition),
+			expected:    &FailedPrecondition{Message: "failed precondition", Violations: []PreconditionViolation{{Type: "TOS", Subject: "user:alice", Description: "not accepted"}}},
+		},
+		{
+			description: "unauthenticated with error info",
+			err:         &Unauthenticated{Reason: "TOKEN_EXPIRED", Domain: "playground", Metadata: map[string]string{"issuer": "local"}},
+			code:        codes.Unauthenticated,
+			target:      new(*Unauthenticated),
+			expected:    &Unauthenticated{Message: "unauthenticated", Reason: "TOKEN_EXPIRED", Domain: "playground", Metadata: map[string]string{"issuer": "local"}},
+		},
+		{
+			description: "permission denied without error info",
+			err:         &PermissionDenied{Message: "not a member"},
+			code:        codes.PermissionDenied,
+			target:      new(*PermissionDenied),
+			expected:    &PermissionDenied{Message: "not a member"},
+		},
+		{
+			description: "wrapped typed error",
+			err:         fmt.Errorf("loading room: %w", &NotFound{Message: "room gone"}),
+			code:        codes.NotFound,
+			target:      new(*NotFound),
+			expected:    &NotFound{Message: "room gone"},
+		},
+	}
+	errs := map[string]error{}
+	for _, tc := range testCases {
+		errs[tc.description] = tc.err
+	}
+	client := startServer(t, errs)
+
+	for _, tc := range testCases {
+		t.Logf("testing: %s", tc.description)
+		_, err := client.Echo(context.Background(), &echo.EchoRequest{Message: tc.description})
+		require.Error(t, err)
+		assert.Equal(t, tc.code, status.Code(err))
+		require.Truef(t, errors.As(err, tc.target), "expected %T, got %T", tc.target, err)
+		// dereference the target to compare the typed error itself
+		assert.Equal(t, tc.expected, reflect.ValueOf(tc.target).Elem().Interface())
+	}
+}
+
+func TestRoundTrip_Stream(t *testing.T) {
+	client := startServer(t, map[string]error{
+		"rate limited": fmt.Errorf("stream: %w", &RateLimited{RetryDelay: time.Second}),
+	})
+
+	stream, err := client.ServerStreamEcho(context.Background(), &echo.ServerStreamEchoRequest{
+		Request: &echo.EchoRequest{Message: "rate limited"},
+		Count:   1,
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	var rateLimited *RateLimited
+	require.True(t, errors.As(err, &rateLimited))
+	assert.Equal(t, time.Second, rateLimited.RetryDelay)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestToStatus(t *testing.T) {
+	testCases := []struct {
+		description string
+		err         error
+		code        codes.Code
+		details     int
+	}{
+		{
+			description: "typed error carries its details",
+			err:         &Validation{Violations: []FieldViolation{{Field: "message", Description: "empty"}}},
+			code:        codes.InvalidArgument,
+			details:     1,
+		},
+		{
+			description: "status errors are kept",
+			err:         fmt.Errorf("wrapped: %w", status.Error(codes.Aborted, "aborted")),
+			code:        codes.Aborted,
+		},
+		{
+			description: "canceled context",
+			err:         fmt.Errorf("waiting: %w", context.Canceled),
+			code:        codes.Canceled,
+		},
+		{
+			description: "exceeded deadline",
+			err:         context.DeadlineExceeded,
+			code:        codes.DeadlineExceeded,
+		},
+		{
+			description: "plain errors are unknown",
+			err:         errors.New("boom"),
+			code:        codes.Unknown,
+		},
+		{
+			description: "rate limited without delay or violations has no details",
+			err:         &RateLimited{},
+			code:        codes.ResourceExhausted,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("testing: %s", tc.description)
+		st := ToStatus(tc.err)
+		assert.Equal(t, tc.code, st.Code())
+		assert.Len(t, st.Details(), tc.details)
+	}
+	assert.Nil(t, ToStatus(nil))
+}
+
+func TestFromError(t *testing.T) {
+	t.Log("testing: errors without a typed counterpart are returned unchanged")
+	plain := status.Error(codes.Internal, "internal")
+	assert.Equal(t, plain, FromError(plain))
+	invalid := status.Error(codes.InvalidArgument, "no details")
+	assert.Equal(t, invalid, FromError(invalid))
+	other := errors.New("not a status")
+	assert.Equal(t, other, FromError(other))
+	assert.Nil(t, FromError(nil))
+
+	t.Log("testing: details of other types are ignored")
+	st, err := status.New(codes.NotFound, "missing").WithDetails(&errdetails.DebugInfo{Detail: "debug"})
+	require.NoError(t, err)
+	var notFound *NotFound
+	require.True(t, errors.As(FromError(st.Err()), &notFound))
+	assert.Equal(t, &NotFound{Message: "missing"}, notFound)
+}
@@ -0,0 +1,290 @@
+// Package testpki generates an ephemeral PKI at test time: a CA issuing server and client certificates
+// with the names, validity and key types a test needs, so tests do not depend on certificate files that expire.
+//
+// TLS failure modes are produced with the same calls:
+//
+//	ca := testpki.NewCA(t)
+//	server := ca.Server(t, testpki.WithDNSNames("echo.playground.test"))
+//	expired := ca.Server(t, testpki.Expired())
+//	wrongHost := ca.Server(t, testpki.WithDNSNames("chat.playground.test"))
+//	untrusted := testpki.NewCA(t).Client(t) // not signed by ca
+package testpki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// KeyType is the algorithm and size of a generated key.
+type KeyType string
+
+const (
+	ECDSAP256 KeyType = "ecdsa-p256"
+	ECDSAP384 KeyType = "ecdsa-p384"
+	RSA2048   KeyType = "rsa-2048"
+	Ed25519   KeyType = "ed25519"
+)
+
+// generateKey creates a key of the given type.
+func generateKey(t testing.TB, keyType KeyType) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case ECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case RSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case Ed25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("testpki: unknown key type %q", keyType)
+	}
+	if err != nil {
+		t.Fatalf("testpki: generating %s key: %v", keyType, err)
+	}
+	return key
+}
+
+// options are the settings of an issued certificate.
+type options struct {
+	commonName string
+	dnsNames   []string
+	ips        []net.IP
+	uris       []string
+	notBefore  time.Time
+	notAfter   time.Time
+	keyType    KeyType
+}
+
+// Option customizes an issued certificate.
+type Option func(*options)
+
+// WithCommonName sets the common name of the subject.
+func WithCommonName(name string) Option {
+	return func(o *options) {
+		o.commonName = name
+	}
+}
+
+// WithDNSNames replaces the DNS names of the certificate.
+func WithDNSNames(names ...string) Option {
+	return func(o *options) {
+		o.dnsNames = names
+	}
+}
+
+// WithIPs replaces the IP addresses of the certificate.
+func WithIPs(ips ...net.IP) Option {
+	return func(o *options) {
+		o.ips = ips
+	}
+}
+
+// WithURIs sets the URI names of the certificate, e.g. a SPIFFE ID.
+func WithURIs(uris ...string) Option {
+	return func(o *options) {
+		o.uris = uris
+	}
+}
+
+// WithValidity sets the period the certificate is valid in.
+func WithValidity(notBefore, notAfter time.Time) Option {
+	return func(o *options) {
+		o.notBefore, o.notAfter = notBefore, notAfter
+	}
+}
+
+// Expired issues a certificate that expired an hour ago.
+func Expired() Option {
+	return WithValidity(time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+}
+
+// WithKeyType sets the type of the generated key, ECDSA P-256 by default.
+func WithKeyType(keyType KeyType) Option {
+	return func(o *options) {
+		o.keyType = keyType
+	}
+}
+
+// newOptions applies opts to the defaults, certificates being valid for an hour with an ECDSA P-256 key unless set.
+func newOptions(defaults options, opts []Option) options {
+	defaults.notBefore = time.Now().Add(-time.Minute)
+	defaults.notAfter = time.Now().Add(time.Hour)
+	defaults.keyType = ECDSAP256
+	for _, opt := range opts {
+		opt(&defaults)
+	}
+	return defaults
+}
+
+// Certificate is a generated certificate and its key, kept in memory.
+type Certificate struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// TLSCertificate returns the certificate for tls.Config.Certificates.
+func (c *Certificate) TLSCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key, Leaf: c.Cert}
+}
+
+// CertPEM returns the PEM encoded certificate.
+func (c *Certificate) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM returns the PEM encoded PKCS #8 private key.
+func (c *Certificate) KeyPEM(t testing.TB) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		t.Fatalf("testpki: encoding key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// WriteFiles stores the certificate and key as name.pem and name.key in dir, returning their paths.
+// Existing files are replaced, e.g. to rotate certificates.
+func (c *Certificate) WriteFiles(t testing.TB, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	// the key is written first, so readers never pair the new certificate with the old key
+	err := os.WriteFile(keyFile, c.KeyPEM(t), 0o600)
+	if err == nil {
+		err = os.WriteFile(certFile, c.CertPEM(), 0o600)
+	}
+	if err != nil {
+		t.Fatalf("testpki: writing %s: %v", name, err)
+	}
+	return certFile, keyFile
+}
+
+// CA is a generated certificate authority issuing server and client certificates.
+type CA struct {
+	Certificate
+}
+
+// NewCA creates a self-signed CA, named "testpki CA" unless WithCommonName is given.
+func NewCA(t testing.TB, opts ...Option) *CA {
+	t.Helper()
+	o := newOptions(options{commonName: "testpki CA"}, opts)
+	template := &x509.Certificate{
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	return &CA{Certificate: *create(t, template, o, nil)}
+}
+
+// Pool returns a pool trusting only this CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// Server issues a server certificate, valid for localhost, 127.0.0.1 and ::1 unless WithDNSNames or WithIPs is given.
+func (ca *CA) Server(t testing.TB, opts ...Option) *Certificate {
+	t.Helper()
+	o := newOptions(options{
+		commonName: "testpki server",
+		dnsNames:   []string{"localhost"},
+		ips:        []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}, opts)
+	template := &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return create(t, template, o, ca)
+}
+
+// Client issues a client certificate, named "testpki client" unless WithCommonName is given.
+func (ca *CA) Client(t testing.TB, opts ...Option) *Certificate {
+	t.Helper()
+	o := newOptions(options{commonName: "testpki client"}, opts)
+	template := &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return create(t, template, o, ca)
+}
+
+// create completes template with the options and signs it by the issuer, self-signed without issuer.
+func create(t testing.TB, template *x509.Certificate, o options, issuer *CA) *Certificate {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		t.Fatalf("testpki: generating serial: %v", err)
+	}
+	template.SerialNumber = serial
+	template.Subject = pkix.Name{CommonName: o.commonName}
+	template.DNSNames = o.dnsNames
+	template.IPAddresses = o.ips
+	template.NotBefore = o.notBefore
+	template.NotAfter = o.notAfter
+	for _, raw := range o.uris {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("testpki: parsing URI %q: %v", raw, err)
+		}
+		template.URIs = append(template.URIs, u)
+	}
+	key := generateKey(t, o.keyType)
+	// RSA keys are used for key encipherment in TLS 1.2 RSA key exchanges
+	if _, ok := key.(*rsa.PrivateKey); ok && !template.IsCA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.Cert, issuer.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatalf("testpki: creating certificate %q: %v", o.commonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("testpki: parsing certificate %q: %v", o.commonName, err)
+	}
+	return &Certificate{Cert: cert, Key: key}
+}
+
+// ServerConfig returns a server configuration serving the certificate,
+// requiring client certificates signed by clientCA when it is not nil.
+func (c *Certificate) ServerConfig(clientCA *CA) *tls.Config {
+	cfg := &tls.Config{Certificates: []tls.Certificate{c.TLSCertificate()}, MinVersion: tls.VersionTLS12}
+	if clientCA != nil {
+		cfg.ClientCAs = clientCA.Pool()
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// ClientConfig returns a client configuration trusting only this CA for serverName,
+// presenting the client certificate when it is not nil.
+func (ca *CA) ClientConfig(serverName string, client *Certificate) *tls.Config {
+	cfg := &tls.Config{RootCAs: ca.Pool(), ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{client.TLSCertificate()}
+	}
+	return cfg
+}
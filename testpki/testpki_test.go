@@ -0,0 +1,107 @@
+package testpki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handshake connects a client with clientCfg to a server with serverCfg, returning the error of either side.
+func handshake(serverCfg, clientCfg *tls.Config) error {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer lst.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverConn, err := lst.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		_ = serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+		server := tls.Server(serverConn, serverCfg)
+		err = server.Handshake()
+		// with TLS 1.3 the client finishes before the server verified its certificate, the server reports it
+		_ = server.Close()
+		serverErr <- err
+	}()
+	clientConn, err := net.Dial("tcp", lst.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer clientConn.Close()
+	_ = clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	client := tls.Client(clientConn, clientCfg)
+	err = client.Handshake()
+	if err == nil {
+		// read until the server closes, surfacing alerts sent after the client finished
+		_, err = io.ReadAll(client)
+	}
+	return errors.Join(err, <-serverErr)
+}
+
+func TestHandshake(t *testing.T) {
+	ca := NewCA(t)
+	otherCA := NewCA(t, WithCommonName("other CA"))
+
+	testCases := []struct {
+		description string
+		server      *Certificate
+		client      *Certificate
+		serverName  string
+		expectError bool
+	}{
+		{description: "localhost server", server: ca.Server(t), serverName: "localhost"},
+		{description: "server name", server: ca.Server(t, WithDNSNames("echo.playground.test")), serverName: "echo.playground.test"},
+		{description: "RSA server", server: ca.Server(t, WithKeyType(RSA2048)), serverName: "localhost"},
+		{description: "ECDSA P-384 server", server: ca.Server(t, WithKeyType(ECDSAP384)), serverName: "localhost"},
+		{description: "Ed25519 server", server: ca.Server(t, WithKeyType(Ed25519)), serverName: "localhost"},
+		{description: "client certificate", server: ca.Server(t), client: ca.Client(t, WithURIs("spiffe://playground.test/client")), serverName: "localhost"},
+		{description: "expired server", server: ca.Server(t, Expired()), serverName: "localhost", expectError: true},
+		{description: "not yet valid server", server: ca.Server(t, WithValidity(time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))), serverName: "localhost", expectError: true},
+		{description: "wrong host", server: ca.Server(t, WithDNSNames("chat.playground.test")), serverName: "echo.playground.test", expectError: true},
+		{description: "untrusted server", server: otherCA.Server(t), serverName: "localhost", expectError: true},
+		{description: "expired client", server: ca.Server(t), client: ca.Client(t, Expired()), serverName: "localhost", expectError: true},
+		{description: "untrusted client", server: ca.Server(t), client: otherCA.Client(t), serverName: "localhost", expectError: true},
+		{description: "server certificate used by client", server: ca.Server(t), client: ca.Server(t), serverName: "localhost", expectError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var clientCA *CA
+			if tc.client != nil {
+				clientCA = ca
+			}
+			err := handshake(tc.server.ServerConfig(clientCA), ca.ClientConfig(tc.serverName, tc.client))
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCertificate_WriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := NewCA(t)
+	for _, keyType := range []KeyType{ECDSAP256, ECDSAP384, RSA2048, Ed25519} {
+		t.Run(string(keyType), func(t *testing.T) {
+			issued := ca.Client(t, WithKeyType(keyType), WithCommonName("client "+string(keyType)))
+			certFile, keyFile := issued.WriteFiles(t, dir, string(keyType))
+			pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+			require.NoError(t, err)
+			assert.Equal(t, issued.Cert.Raw, pair.Certificate[0])
+			_, err = issued.Cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// fileStamp identifies a version of a file by its modification time and size.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// Reloader serves the certificate and client CAs of its files and swaps them when the files change,
+// so certificates can be rotated without restarting the server. Only new handshakes use the new files,
+// established connections are kept.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+	// stamps of the files last loaded and of the files last failing to load, only touched by Reload
+	stamps map[string]fileStamp
+	failed map[string]fileStamp
+}
+
+// NewReloader loads the certificate and key of certFile and keyFile, and the client CAs of clientCAFile when set.
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	_, err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// currentStamps returns the stamps of the files, the zero stamp for files that cannot be checked.
+func (r *Reloader) currentStamps() (map[string]fileStamp, error) {
+	stamps := map[string]fileStamp{}
+	var firstErr error
+	for _, file := range r.files() {
+		stamp, err := stampOf(file)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("checking %s: %w", file, err)
+		}
+		stamps[file] = stamp
+	}
+	return stamps, firstErr
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if a == nil || b == nil || len(a) != len(b) {
+		return false
+	}
+	for file, stamp := range a {
+		if b[file] != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// Reload loads the files again if any of them changed, reporting whether it did.
+// Nothing is swapped when loading fails, the previous certificate and CAs stay in use. Files failing to load
+// are not tried again until one of them changes, so a broken rotation is reported once.
+func (r *Reloader) Reload() (bool, error) {
+	// take the stamps before reading, so a write during loading is picked up by the next reload
+	stamps, err := r.currentStamps()
+	if sameStamps(stamps, r.stamps) || sameStamps(stamps, r.failed) {
+		return false, nil
+	}
+	if err == nil {
+		err = r.load()
+	}
+	if err != nil {
+		r.failed = stamps
+		return false, err
+	}
+	r.stamps, r.failed = stamps, nil
+	return true, nil
+}
+
+// load loads the files and swaps them in.
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading server certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+		pool, err = loadPool(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+	r.cert.Store(&cert)
+	r.clientCAs.Store(pool)
+	return nil
+}
+
+// Watch reloads the files whenever they change, checking every interval until ctx is done.
+// Failed reloads are logged once and retried with the next change of the files.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := r.Reload()
+		if err != nil {
+			slog.Warn("reloading TLS files failed, keeping the previous certificate", "cert", r.certFile, "error", err)
+		} else if reloaded {
+			slog.Info("reloaded TLS files", "cert", r.certFile, "client_ca", r.clientCAFile)
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// ServerConfig returns a server configuration using the current certificate and client CAs on every handshake.
+// Clients must present a certificate signed by the client CAs if the reloader has a client CA file.
+//
+// nextProtos are the ALPN protocols of the server, e.g. h2 for gRPC. They must be given here, since the
+// per-handshake configurations do not inherit the protocols credentials.NewTLS adds to the returned config.
+func (r *Reloader) ServerConfig(nextProtos ...string) *tls.Config {
+	cfg := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		NextProtos:     nextProtos,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if r.clientCAFile != "" {
+		// ClientCAs cannot be swapped in a shared config, every handshake gets a config with the current pool
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				GetCertificate: r.GetCertificate,
+				NextProtos:     nextProtos,
+				MinVersion:     tls.VersionTLS12,
+				ClientCAs:      r.clientCAs.Load(),
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+			}, nil
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,155 @@
+package tlsconfig
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/testpki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveEcho accepts connections on lst, echoing the lines received after the handshake.
+func serveEcho(lst net.Listener) {
+	for {
+		conn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
+
+// roundTrip sends a line on conn and returns the echoed line.
+func roundTrip(conn *tls.Conn) (string, error) {
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	_, err := conn.Write([]byte("ping\n"))
+	if err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+// dial connects with the client certificate and returns the serial of the server certificate,
+// after checking the server accepted the client with a round trip.
+func dial(t *testing.T, addr string, roots *x509.CertPool, client *testpki.Certificate) (*tls.Conn, *big.Int, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, &tls.Config{
+		ServerName:   "echo.playground.test",
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{client.TLSCertificate()},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_, err = roundTrip(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.ConnectionState().PeerCertificates[0].SerialNumber, nil
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := testpki.NewCA(t)
+	roots := ca.Pool()
+	first := ca.Server(t, testpki.WithDNSNames("echo.playground.test"))
+	second := ca.Server(t, testpki.WithDNSNames("echo.playground.test"))
+	client := ca.Client(t)
+	certFile, keyFile := first.WriteFiles(t, dir, "server")
+	caFile, _ := ca.WriteFiles(t, dir, "ca")
+
+	reloader, err := NewReloader(certFile, keyFile, caFile)
+	require.NoError(t, err)
+	lst, err := tls.Listen("tcp", "127.0.0.1:0", reloader.ServerConfig())
+	require.NoError(t, err)
+	defer lst.Close()
+	go serveEcho(lst)
+
+	established, serial, err := dial(t, lst.Addr().String(), roots, client)
+	require.NoError(t, err)
+	assert.Equal(t, first.Cert.SerialNumber, serial)
+
+	t.Log("testing: unchanged files are not reloaded")
+	reloaded, err := reloader.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	t.Log("testing: new connections get the rotated certificate, established connections are kept")
+	second.WriteFiles(t, dir, "server")
+	reloaded, err = reloader.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	_, serial, err = dial(t, lst.Addr().String(), roots, client)
+	require.NoError(t, err)
+	assert.Equal(t, second.Cert.SerialNumber, serial)
+	reply, err := roundTrip(established)
+	require.NoError(t, err)
+	assert.Equal(t, "ping\n", reply)
+
+	t.Log("testing: rotated client CAs are verified by new handshakes")
+	otherCA := testpki.NewCA(t, testpki.WithCommonName("other CA"))
+	otherClient := otherCA.Client(t)
+	_, _, err = dial(t, lst.Addr().String(), roots, otherClient)
+	assert.Error(t, err)
+	otherCA.WriteFiles(t, dir, "ca")
+	_, err = reloader.Reload()
+	require.NoError(t, err)
+	_, _, err = dial(t, lst.Addr().String(), roots, otherClient)
+	assert.NoError(t, err)
+	_, _, err = dial(t, lst.Addr().String(), roots, client)
+	assert.Error(t, err)
+
+	t.Log("testing: invalid files keep the previous certificate")
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.Cert.Raw, cert.Certificate[0])
+
+	t.Log("testing: files failing to load are not tried again until they change")
+	reloaded, err = reloader.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+	require.NoError(t, os.WriteFile(keyFile, []byte("still not a key"), 0o600))
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+
+	t.Log("testing: watched files are reloaded when changed")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+	first.WriteFiles(t, dir, "server")
+	require.Eventually(t, func() bool {
+		cert, _ := reloader.GetCertificate(nil)
+		return string(cert.Certificate[0]) == string(first.Cert.Raw)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestNewReloader_Errors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := testpki.NewCA(t).WriteFiles(t, dir, "ca")
+
+	_, err := NewReloader(certFile, dir+"/missing.key", "")
+	assert.Error(t, err)
+	_, err = NewReloader(certFile, keyFile, dir+"/missing.pem")
+	assert.Error(t, err)
+
+	reloader, err := NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	cfg := reloader.ServerConfig("h2")
+	assert.Nil(t, cfg.GetConfigForClient, "without client CA clients are not verified")
+	assert.Equal(t, []string{"h2"}, cfg.NextProtos)
+}
@@ -0,0 +1,104 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pgbytes/grpc-playground/testpki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := testpki.NewCA(t, testpki.WithCommonName("playground CA"))
+	otherCA := testpki.NewCA(t, testpki.WithCommonName("other CA"))
+	caFile, _ := ca.WriteFiles(t, dir, "ca")
+	serverCert, serverKey := ca.Server(t, testpki.WithDNSNames("echo.playground.test")).WriteFiles(t, dir, "server")
+	clientCert, clientKey := ca.Client(t, testpki.WithCommonName("echo client")).WriteFiles(t, dir, "client")
+	untrustedCert, untrustedKey := otherCA.Client(t, testpki.WithCommonName("intruder")).WriteFiles(t, dir, "untrusted")
+	expiredCert, expiredKey := ca.Client(t, testpki.Expired()).WriteFiles(t, dir, "expired")
+
+	reloader, err := NewReloader(serverCert, serverKey, caFile)
+	require.NoError(t, err)
+	lst, err := tls.Listen("tcp", "127.0.0.1:0", reloader.ServerConfig())
+	require.NoError(t, err)
+	defer lst.Close()
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					_, _ = conn.Write([]byte("ok"))
+				}
+			}()
+		}
+	}()
+
+	testCases := []struct {
+		description string
+		serverName  string
+		certFile    string
+		keyFile     string
+		expectError bool
+	}{
+		{description: "trusted client certificate", serverName: "echo.playground.test", certFile: clientCert, keyFile: clientKey},
+		{description: "without client certificate", serverName: "echo.playground.test", expectError: true},
+		{description: "client certificate of another CA", serverName: "echo.playground.test", certFile: untrustedCert, keyFile: untrustedKey, expectError: true},
+		{description: "expired client certificate", serverName: "echo.playground.test", certFile: expiredCert, keyFile: expiredKey, expectError: true},
+		{description: "wrong server name", serverName: "chat.playground.test", certFile: clientCert, keyFile: clientKey, expectError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			clientCfg, err := Client(caFile, tc.serverName, tc.certFile, tc.keyFile)
+			require.NoError(t, err)
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", lst.Addr().String(), clientCfg)
+			if err == nil {
+				// with TLS 1.3 the server rejects the client certificate after the client finished its handshake
+				defer conn.Close()
+				_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+				var reply []byte
+				reply, err = io.ReadAll(conn)
+				if err == nil && string(reply) != "ok" {
+					err = io.ErrUnexpectedEOF
+				}
+			}
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+	certFile, keyFile := testpki.NewCA(t).WriteFiles(t, dir, "ca")
+
+	_, err := NewReloader(filepath.Join(dir, "missing.pem"), keyFile, "")
+	assert.Error(t, err)
+	_, err = NewReloader(certFile, keyFile, notPEM)
+	assert.Error(t, err)
+	_, err = Client(notPEM, "localhost", "", "")
+	assert.Error(t, err)
+	_, err = Client(certFile, "localhost", certFile, "")
+	assert.Error(t, err)
+
+	reloader, err := NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	cfg := reloader.ServerConfig()
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth, "without client CA clients are not verified")
+	assert.Nil(t, cfg.GetConfigForClient, "nor is a client CA pool swapped in per handshake")
+}
@@ -0,0 +1,225 @@
+// Package validate checks messages against the (grpc_playground.rules) options of their fields,
+// declared in proto/options/options.proto, and provides interceptors rejecting invalid requests
+// with INVALID_ARGUMENT and a BadRequest listing every violated field.
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pgbytes/grpc-playground/api/go/options"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Validate checks msg against the rules of its fields, in nested messages as well. It returns a *rpcerror.Validation
+// listing every violation, with fields named by their path, e.g. talk.text or children[0].name, or nil when msg is valid.
+// Rules that cannot be checked, such as invalid patterns, fail with INTERNAL.
+func Validate(msg proto.Message) error {
+	var violations []rpcerror.FieldViolation
+	if err := validateMessage(msg.ProtoReflect(), "", &violations); err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &rpcerror.Validation{Violations: violations}
+	}
+	return nil
+}
+
+// fieldRules are the rules of a field, nil for fields that only hold nested messages.
+type fieldRules struct {
+	fd      protoreflect.FieldDescriptor
+	rules   *options.FieldRules
+	pattern *regexp.Regexp
+}
+
+// messageRules are the fields of a message that have rules or hold nested messages, in declaration order.
+type messageRules struct {
+	once   sync.Once
+	fields []fieldRules
+	err    error
+}
+
+// compiled caches the rules of every validated message type.
+var compiled sync.Map
+
+// rulesOf returns the rules of the fields of md, compiling them on first use.
+func rulesOf(md protoreflect.MessageDescriptor) ([]fieldRules, error) {
+	cached, ok := compiled.Load(md)
+	if !ok {
+		cached, _ = compiled.LoadOrStore(md, &messageRules{})
+	}
+	entry := cached.(*messageRules)
+	entry.once.Do(func() {
+		entry.fields, entry.err = compileRules(md)
+	})
+	return entry.fields, entry.err
+}
+
+func compileRules(md protoreflect.MessageDescriptor) ([]fieldRules, error) {
+	var rules []fieldRules
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		field := fieldRules{fd: fd}
+		if opts := fd.Options(); opts != nil && proto.HasExtension(opts, options.E_Rules) {
+			field.rules, _ = proto.GetExtension(opts, options.E_Rules).(*options.FieldRules)
+		}
+		if field.rules.GetPattern() != "" {
+			pattern, err := regexp.Compile(field.rules.GetPattern())
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "invalid pattern of %s: %v", fd.FullName(), err)
+			}
+			field.pattern = pattern
+		}
+		if field.rules != nil || messageValues(fd) != nil {
+			rules = append(rules, field)
+		}
+	}
+	return rules, nil
+}
+
+// messageValues returns the message type of the values of fd, nil if its values are not messages.
+func messageValues(fd protoreflect.FieldDescriptor) protoreflect.MessageDescriptor {
+	if fd.IsMap() {
+		return fd.MapValue().Message()
+	}
+	return fd.Message()
+}
+
+func validateMessage(m protoreflect.Message, prefix string, violations *[]rpcerror.FieldViolation) error {
+	fields, err := rulesOf(m.Descriptor())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		fd := field.fd
+		path := string(fd.Name())
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if !m.Has(fd) {
+			if field.rules.GetRequired() {
+				*violations = append(*violations, rpcerror.FieldViolation{Field: path, Description: "is required"})
+			}
+			continue
+		}
+		v := m.Get(fd)
+		if field.rules != nil {
+			checkLength(fd, v, field.rules, path, violations)
+			checkValues(field, v, path, violations)
+		}
+		if err := validateNested(fd, v, path, violations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLength checks the min_len and max_len rules of a set field.
+func checkLength(fd protoreflect.FieldDescriptor, v protoreflect.Value, rules *options.FieldRules, path string, violations *[]rpcerror.FieldViolation) {
+	var length int
+	var unit string
+	switch {
+	case fd.IsList():
+		length, unit = v.List().Len(), "entries"
+	case fd.IsMap():
+		length, unit = v.Map().Len(), "entries"
+	case fd.Kind() == protoreflect.StringKind:
+		length, unit = utf8.RuneCountInString(v.String()), "characters"
+	case fd.Kind() == protoreflect.BytesKind:
+		length, unit = len(v.Bytes()), "bytes"
+	default:
+		return
+	}
+	if min := int(rules.GetMinLen()); min > 0 && length < min {
+		*violations = append(*violations, rpcerror.FieldViolation{
+			Field:       path,
+			Description: fmt.Sprintf("must have at least %d %s, got %d", min, unit, length),
+		})
+	}
+	if max := int(rules.GetMaxLen()); max > 0 && length > max {
+		*violations = append(*violations, rpcerror.FieldViolation{
+			Field:       path,
+			Description: fmt.Sprintf("must have at most %d %s, got %d", max, unit, length),
+		})
+	}
+}
+
+// checkValues checks the pattern and defined_only rules of a set field, against every element of lists.
+func checkValues(field fieldRules, v protoreflect.Value, path string, violations *[]rpcerror.FieldViolation) {
+	fd := field.fd
+	if fd.IsMap() {
+		return
+	}
+	check := func(v protoreflect.Value, path string) {
+		switch {
+		case field.pattern != nil && fd.Kind() == protoreflect.StringKind && !field.pattern.MatchString(v.String()):
+			*violations = append(*violations, rpcerror.FieldViolation{
+				Field:       path,
+				Description: fmt.Sprintf("must match %s", field.pattern),
+			})
+		case field.rules.GetDefinedOnly() && fd.Kind() == protoreflect.EnumKind && fd.Enum().Values().ByNumber(v.Enum()) == nil:
+			*violations = append(*violations, rpcerror.FieldViolation{
+				Field:       path,
+				Description: fmt.Sprintf("%d is not a value of %s", v.Enum(), fd.Enum().FullName()),
+			})
+		}
+	}
+	if !fd.IsList() {
+		check(v, path)
+		return
+	}
+	list := v.List()
+	for i := 0; i < list.Len(); i++ {
+		check(list.Get(i), fmt.Sprintf("%s[%d]", path, i))
+	}
+}
+
+// validateNested validates the messages held by a set field.
+func validateNested(fd protoreflect.FieldDescriptor, v protoreflect.Value, path string, violations *[]rpcerror.FieldViolation) error {
+	if messageValues(fd) == nil {
+		return nil
+	}
+	switch {
+	case fd.IsList():
+		list := v.List()
+		for i := 0; i < list.Len(); i++ {
+			if err := validateMessage(list.Get(i).Message(), fmt.Sprintf("%s[%d]", path, i), violations); err != nil {
+				return err
+			}
+		}
+	case fd.IsMap():
+		// maps are ranged in random order, the entries are validated by key to report violations in a stable order.
+		entries := v.Map()
+		var keys []protoreflect.MapKey
+		entries.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, k)
+			return true
+		})
+		sort.Slice(keys, func(i, j int) bool { return formatKey(keys[i]) < formatKey(keys[j]) })
+		for _, k := range keys {
+			if err := validateMessage(entries.Get(k).Message(), path+"["+formatKey(k)+"]", violations); err != nil {
+				return err
+			}
+		}
+	default:
+		return validateMessage(v.Message(), path, violations)
+	}
+	return nil
+}
+
+// formatKey formats a map key as in field paths, strings are quoted.
+func formatKey(k protoreflect.MapKey) string {
+	if s, ok := k.Interface().(string); ok {
+		return strconv.Quote(s)
+	}
+	return k.String()
+}
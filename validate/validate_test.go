@@ -0,0 +1,250 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pgbytes/grpc-playground/api/go/chat"
+	"github.com/pgbytes/grpc-playground/api/go/echo"
+	"github.com/pgbytes/grpc-playground/rpcerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// rulesProto declares every rule, on singular, repeated, map and nested fields.
+const rulesProto = `
+name: "validate/rules.proto"
+package: "grpc_playground.validate"
+dependency: "options/options.proto"
+syntax: "proto3"
+enum_type { name: "Color" value { name: "COLOR_UNSPECIFIED" number: 0 } value { name: "COLOR_RED" number: 1 } }
+message_type {
+  name: "Rules"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING options { [grpc_playground.rules] { required: true min_len: 2 max_len: 5 } } }
+  field { name: "code" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING options { [grpc_playground.rules] { pattern: "^[a-z]+$" } } }
+  field { name: "data" number: 3 label: LABEL_OPTIONAL type: TYPE_BYTES options { [grpc_playground.rules] { max_len: 2 } } }
+  field { name: "color" number: 4 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".grpc_playground.validate.Color" options { [grpc_playground.rules] { defined_only: true } } }
+  field { name: "count" number: 5 label: LABEL_OPTIONAL type: TYPE_INT32 options { [grpc_playground.rules] { required: true } } }
+  field { name: "tags" number: 6 label: LABEL_REPEATED type: TYPE_STRING options { [grpc_playground.rules] { max_len: 2 pattern: "^#" } } }
+  field { name: "colors" number: 7 label: LABEL_REPEATED type: TYPE_ENUM type_name: ".grpc_playground.validate.Color" options { [grpc_playground.rules] { defined_only: true } } }
+  field { name: "labels" number: 8 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.validate.Rules.LabelsEntry" options { [grpc_playground.rules] { min_len: 1 max_len: 2 } } }
+  field { name: "child" number: 9 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".grpc_playground.validate.Rules" }
+  field { name: "children" number: 10 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.validate.Rules" }
+  field { name: "by_name" number: 11 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".grpc_playground.validate.Rules.ByNameEntry" }
+  nested_type {
+    name: "LabelsEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+    options { map_entry: true }
+  }
+  nested_type {
+    name: "ByNameEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".grpc_playground.validate.Rules" }
+    options { map_entry: true }
+  }
+}
+message_type {
+  name: "BadPattern"
+  field { name: "code" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING options { [grpc_playground.rules] { pattern: "[" } } }
+}
+`
+
+// rulesDescriptor returns the descriptor of the message name of rulesProto.
+func rulesDescriptor(t *testing.T, name protoreflect.Name) protoreflect.MessageDescriptor {
+	fdp := &descriptorpb.FileDescriptorProto{}
+	require.NoError(t, prototext.Unmarshal([]byte(rulesProto), fdp))
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+	return fd.Messages().ByName(name)
+}
+
+// violations returns the violations of the Validation err, as field: description.
+func violations(t *testing.T, err error) []string {
+	var validation *rpcerror.Validation
+	require.True(t, errors.As(err, &validation), "expected a validation error, got %v", err)
+	var actual []string
+	for _, v := range validation.Violations {
+		actual = append(actual, v.Field+": "+v.Description)
+	}
+	return actual
+}
+
+func TestValidate(t *testing.T) {
+	md := rulesDescriptor(t, "Rules")
+	testCases := []struct {
+		description string
+		message     string
+		expected    []string
+	}{
+		{
+			description: "golden case: all rules satisfied",
+			message: `{"name": "abcde", "code": "abc", "data": "AQI=", "color": "COLOR_RED", "count": -1, "tags": ["#a", "#b"],
+				"colors": [0, 1], "labels": {"a": "b"}, "child": {"name": "ab", "count": 1}, "byName": {"k": {"name": "xy", "count": 1}}}`,
+		},
+		{
+			description: "golden case: unset fields only violate required rules",
+			message:     `{"name": "ab", "count": 1}`,
+		},
+		{
+			description: "failure case: missing required fields",
+			message:     `{}`,
+			expected:    []string{"name: is required", "count: is required"},
+		},
+		{
+			description: "failure case: lengths out of bounds",
+			message:     `{"name": "äöüäöü", "data": "AQID", "count": 1, "tags": ["#a", "#b", "#c"], "labels": {"a": "", "b": "", "c": ""}}`,
+			expected: []string{
+				"name: must have at most 5 characters, got 6",
+				"data: must have at most 2 bytes, got 3",
+				"tags: must have at most 2 entries, got 3",
+				"labels: must have at most 2 entries, got 3",
+			},
+		},
+		{
+			description: "failure case: patterns and enums",
+			message:     `{"name": "a", "code": "ABC", "color": 7, "count": 1, "tags": ["#a", "b"], "colors": [1, 9]}`,
+			expected: []string{
+				"name: must have at least 2 characters, got 1",
+				"code: must match ^[a-z]+$",
+				"color: 7 is not a value of grpc_playground.validate.Color",
+				"tags[1]: must match ^#",
+				"colors[1]: 9 is not a value of grpc_playground.validate.Color",
+			},
+		},
+		{
+			description: "failure case: nested messages",
+			message: `{"name": "ab", "count": 1, "child": {"name": "ab", "count": 1, "child": {"code": "1", "count": 1}},
+				"children": [{"name": "ab", "count": 1}, {"name": "ab"}], "byName": {"b": {"name": "a", "count": 1}, "a": {"count": 1}}}`,
+			expected: []string{
+				"child.child.name: is required",
+				"child.child.code: must match ^[a-z]+$",
+				"children[1].count: is required",
+				`by_name["a"].name: is required`,
+				`by_name["b"].name: must have at least 2 characters, got 1`,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			msg := dynamicpb.NewMessage(md)
+			require.NoError(t, protojson.Unmarshal([]byte(tc.message), msg))
+			err := Validate(msg)
+			if tc.expected == nil {
+				require.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tc.expected, violations(t, err))
+		})
+	}
+}
+
+func TestValidate_InvalidRules(t *testing.T) {
+	msg := dynamicpb.NewMessage(rulesDescriptor(t, "BadPattern"))
+	err := Validate(msg)
+	require.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), "invalid pattern of grpc_playground.validate.BadPattern.code")
+}
+
+func TestValidate_Annotations(t *testing.T) {
+	t.Log("testing: the rules of the echo and chat protos")
+	require.NoError(t, Validate(&echo.EchoRequest{Message: "hello", Talk: &echo.Message{Text: "hi"}}))
+	assert.Equal(t, []string{
+		"message: is required",
+		"talk.text: must have at most 1024 characters, got 1025",
+		"errorType: 42 is not a value of grpc_playground.echo.ErrorType",
+	}, violations(t, Validate(&echo.EchoRequest{Talk: &echo.Message{Text: strings.Repeat("x", 1025)}, ErrorType: 42})))
+
+	require.NoError(t, Validate(&chat.ChatMessage{User: "bob", Message: "hi"}))
+	assert.Equal(t, []string{
+		"user: is required",
+		"sealed.key_id: must match ^[0-9a-f]{16}$",
+		"sealed.nonce: is required",
+	}, violations(t, Validate(&chat.ChatMessage{Sealed: &chat.SealedMessage{KeyId: "xyz", Ciphertext: []byte{1}}})))
+}
+
+type fakeEchoServer struct {
+	echo.UnimplementedEchoServiceServer
+}
+
+func (f *fakeEchoServer) Echo(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	return &echo.EchoResponse{Response: req.Message}, nil
+}
+
+func (f *fakeEchoServer) ClientStreamEcho(stream echo.EchoService_ClientStreamEchoServer) error {
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+	}
+}
+
+func startServer(t *testing.T) echo.EchoServiceClient {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor()),
+	)
+	echo.RegisterEchoServiceServer(server, &fakeEchoServer{})
+	go func() {
+		_ = server.Serve(lst)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lst.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return echo.NewEchoServiceClient(conn)
+}
+
+// fieldViolations returns the fields of the BadRequest detail of err.
+func fieldViolations(t *testing.T, err error) []string {
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok, "expected a BadRequest, got %T", st.Details()[0])
+	var fields []string
+	for _, v := range badRequest.GetFieldViolations() {
+		fields = append(fields, v.GetField())
+	}
+	return fields
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := startServer(t)
+
+	resp, err := client.Echo(context.Background(), &echo.EchoRequest{Message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", resp.Response)
+
+	_, err = client.Echo(context.Background(), &echo.EchoRequest{Talk: &echo.Message{Text: strings.Repeat("x", 1025)}, ErrorType: 42})
+	assert.Equal(t, []string{"message", "talk.text", "errorType"}, fieldViolations(t, err))
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := startServer(t)
+
+	stream, err := client.ClientStreamEcho(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&echo.EchoRequest{Message: "valid"}))
+	require.NoError(t, stream.Send(&echo.EchoRequest{}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, []string{"message"}, fieldViolations(t, err))
+}